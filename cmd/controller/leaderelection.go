@@ -0,0 +1,219 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/knative/pkg/controller"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// lockName is the name of the ConfigMap in the system namespace through
+	// which the replicas of the controller elect a leader.
+	lockName = "warmimage-controller"
+
+	// shutdownTimeout is how long we wait for the workers once we are told
+	// to stop, within the default termination grace period of pods.
+	shutdownTimeout = 20 * time.Second
+)
+
+var (
+	leaderElect   = flag.Bool("leader-elect", true, "Whether to elect a leader among the replicas of the controller, so that only one of them reconciles at a time.")
+	leaseDuration = flag.Duration("leader-elect-lease-duration", 15*time.Second, "How long the other replicas wait after the leader last renewed its lease to try to take over.")
+	renewDeadline = flag.Duration("leader-elect-renew-deadline", 10*time.Second, "How long the leader keeps trying to renew its lease before it steps down.")
+	retryPeriod   = flag.Duration("leader-elect-retry-period", 2*time.Second, "How long to wait between attempts to acquire or renew the lease.")
+)
+
+// runLeaderElection runs the workers once this replica is elected the leader,
+// until it loses the lease or stopCh is closed. Either way, it then stops the
+// workers and drains them, so that the next leader starts from a clean slate,
+// and if stopCh was closed, releases the lease so that the next leader need not
+// wait for it to expire. It returns once stopCh is closed.
+func runLeaderElection(logger *zap.SugaredLogger, kubeClient kubernetes.Interface, namespace string,
+	w *workers, stopCh <-chan struct{}) {
+	id, err := os.Hostname()
+	if err != nil {
+		logger.Fatalf("Error getting hostname: %s", err.Error())
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events(namespace)})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: lockName})
+
+	newLock := func() resourcelock.Interface {
+		return &resourcelock.ConfigMapLock{
+			ConfigMapMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      lockName,
+			},
+			Client: kubeClient.CoreV1(),
+			LockConfig: resourcelock.ResourceLockConfig{
+				Identity:      id,
+				EventRecorder: recorder,
+			},
+		}
+	}
+
+	// The leader elector only stops once it fails to renew the lease.
+	leading := make(chan struct{})
+	lost := make(chan struct{})
+	go leaderelection.RunOrDie(leaderelection.LeaderElectionConfig{
+		Lock:          newLock(),
+		LeaseDuration: *leaseDuration,
+		RenewDeadline: *renewDeadline,
+		RetryPeriod:   *retryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(<-chan struct{}) {
+				close(leading)
+			},
+			OnStoppedLeading: func() {
+				close(lost)
+			},
+			OnNewLeader: func(identity string) {
+				logger.Infof("The leader is now %q", identity)
+			},
+		},
+	})
+
+	select {
+	case <-leading:
+		logger.Infof("Started leading as %q", id)
+	case <-stopCh:
+		return
+	}
+	stop := make(chan struct{})
+	w.run(logger, stop)
+	select {
+	case <-lost:
+		// No other replica can take over until our lease expires, so that
+		// is how long we have to drain.
+		close(stop)
+		w.drain(logger, *leaseDuration-*renewDeadline)
+		logger.Fatalf("Lost the lease as %q", id)
+	case <-stopCh:
+		// We keep renewing the lease meanwhile.
+		close(stop)
+		w.drain(logger, shutdownTimeout)
+		if err := release(newLock(), id); err != nil {
+			logger.Errorw("Failed to release the lease", zap.Error(err))
+		} else {
+			logger.Infof("Released the lease as %q", id)
+		}
+	}
+}
+
+// release gives up the lease, if we still hold it, so that another replica can
+// take over without waiting for it to expire. Our vendored leader elector can't
+// do so itself.
+func release(lock resourcelock.Interface, id string) error {
+	record, err := lock.Get()
+	if err != nil {
+		return err
+	}
+	if record.HolderIdentity != id {
+		return nil
+	}
+	now := metav1.Now()
+	return lock.Update(resourcelock.LeaderElectionRecord{
+		LeaderTransitions:    record.LeaderTransitions,
+		LeaseDurationSeconds: 1,
+		RenewTime:            now,
+		AcquireTime:          now,
+	})
+}
+
+// workers run the controllers, and keep track of the keys that they are
+// reconciling, so that we can wait for those once we stop them.
+type workers struct {
+	// inflight is the number of keys being reconciled. It comes first to
+	// be aligned for atomic operations.
+	inflight int64
+	// stopped is non-zero once we stop the workers, after which they skip
+	// the keys left in their queues.
+	stopped int32
+
+	controllers []*controller.Impl
+	threadiness int
+}
+
+// newWorkers wraps the Reconcilers of the controllers to keep track of them.
+func newWorkers(controllers []*controller.Impl, threadiness int) *workers {
+	w := &workers{controllers: controllers, threadiness: threadiness}
+	for _, ctrlr := range controllers {
+		ctrlr.Reconciler = &trackedReconciler{workers: w, inner: ctrlr.Reconciler}
+	}
+	return w
+}
+
+// run starts the controllers' workers, until stop is closed.
+func (w *workers) run(logger *zap.SugaredLogger, stop <-chan struct{}) {
+	for _, ctrlr := range w.controllers {
+		go func(ctrlr *controller.Impl) {
+			// We don't expect this to return until stop is called,
+			// but if it does, propagate it back.
+			if err := ctrlr.Run(w.threadiness, stop); err != nil {
+				logger.Fatalf("Error running controller: %s", err.Error())
+			}
+		}(ctrlr)
+	}
+}
+
+// drain stops the workers from reconciling any more keys, and waits until
+// those they are reconciling are done, or the timeout passes. The keys left
+// in the work queues are dropped: the next leader reconciles everything as it
+// starts.
+func (w *workers) drain(logger *zap.SugaredLogger, timeout time.Duration) {
+	atomic.StoreInt32(&w.stopped, 1)
+	err := wait.PollImmediate(100*time.Millisecond, timeout, func() (bool, error) {
+		return atomic.LoadInt64(&w.inflight) == 0, nil
+	})
+	if err != nil {
+		logger.Warnf("Timed out draining the workers after %v", timeout)
+	}
+}
+
+// trackedReconciler counts the keys being reconciled, and skips them once the
+// workers are stopped.
+type trackedReconciler struct {
+	workers *workers
+	inner   controller.Reconciler
+}
+
+// Reconcile implements controller.Reconciler
+func (r *trackedReconciler) Reconcile(ctx context.Context, key string) error {
+	atomic.AddInt64(&r.workers.inflight, 1)
+	defer atomic.AddInt64(&r.workers.inflight, -1)
+	if atomic.LoadInt32(&r.workers.stopped) != 0 {
+		return nil
+	}
+	return r.inner.Reconcile(ctx, key)
+}
@@ -18,12 +18,20 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
+	"net/http"
+	"os"
 	"time"
 
 	"github.com/knative/pkg/controller"
 	"github.com/knative/pkg/logging"
 	"github.com/knative/pkg/signals"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
@@ -31,18 +39,44 @@ import (
 
 	clientset "github.com/mattmoor/warm-image/pkg/client/clientset/versioned"
 	informers "github.com/mattmoor/warm-image/pkg/client/informers/externalversions"
+	"github.com/mattmoor/warm-image/pkg/metrics"
+	"github.com/mattmoor/warm-image/pkg/priorityclass"
+	"github.com/mattmoor/warm-image/pkg/reconciler/eligibility"
+	"github.com/mattmoor/warm-image/pkg/reconciler/startuptaint"
 	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/config"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/resources"
+	"github.com/mattmoor/warm-image/pkg/registry"
+	"github.com/mattmoor/warm-image/pkg/webhook"
 )
 
 const (
 	threadsPerController = 2
+	component            = "controller"
+
+	// webhookSecretName is the secret in the system namespace that holds the
+	// conversion webhook's certificate, which every replica serves.
+	webhookSecretName = "warmimage-webhook-certs"
+	// warmimageCRDName is the CustomResourceDefinition whose versions the
+	// conversion webhook converts between.
+	warmimageCRDName = "warmimages.mattmoor.io"
 )
 
 var (
 	masterURL  = flag.String("kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
 	kubeconfig = flag.String("master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
-	// TODO(mattmoor): Move into a configmap and use the watcher.
+	// The sleeper-image in the config-warmimage ConfigMap takes precedence.
 	sleeper = flag.String("sleeper", "", "The name of the sleeper image, see //cmd/sleeper")
+	// The node-agent-image in the config-warmimage ConfigMap takes precedence.
+	nodeAgent = flag.String("node-agent", "", "The name of the node agent image, see //cmd/nodeagent")
+	// The namespace in which ClusterWarmImages are warmed.
+	systemNamespace = flag.String("system-namespace", "warmimage-system", "The namespace in which to warm ClusterWarmImages.")
+	// The node agents may read every secret in this namespace.
+	agentNamespace = flag.String("agent-namespace", "warmimage-agents", "The namespace in which to keep the secrets that the node agents pull with.")
+	metricsAddr    = flag.String("metrics-addr", ":9090", "The address on which to serve Prometheus metrics, or empty to disable them.")
+	// The API server reaches the conversion webhook through this service.
+	webhookAddr    = flag.String("webhook-addr", ":8443", "The address on which to serve the conversion webhook of WarmImages, or empty to disable it.")
+	webhookService = flag.String("webhook-service", "warmimage-webhook", "The name of the service in the system namespace through which the API server calls the conversion webhook.")
 )
 
 func main() {
@@ -51,7 +85,7 @@ func main() {
 	// set up signals so we handle the first shutdown signal gracefully
 	stopCh := signals.SetupSignalHandler()
 
-	logger := logging.FromContext(context.TODO()).Named("controller")
+	logger := logging.FromContext(context.TODO()).Named(component)
 
 	cfg, err := clientcmd.BuildConfigFromFlags(*masterURL, *kubeconfig)
 	if err != nil {
@@ -68,12 +102,89 @@ func main() {
 		logger.Fatalf("Error building warmimage clientset: %s", err.Error())
 	}
 
+	priorityclassClient, err := priorityclass.NewForConfig(cfg)
+	if err != nil {
+		logger.Fatalf("Error building priorityclass client: %s", err.Error())
+	}
+
+	// Configure logging from the config-warmimage ConfigMap, if it exists.
+	loggingConfigMap, err := kubeClient.CoreV1().ConfigMaps(*systemNamespace).Get(config.ConfigName, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		logger.Fatalf("Error loading %s: %s", config.ConfigName, err.Error())
+	} else if err != nil {
+		loggingConfigMap = &corev1.ConfigMap{}
+	}
+	loggingConfig, err := logging.NewConfigFromConfigMap(loggingConfigMap, component)
+	if err != nil {
+		logger.Fatalf("Error parsing logging configuration: %s", err.Error())
+	}
+	logger, atomicLevel := logging.NewLoggerFromConfig(loggingConfig, component)
+	defer logger.Sync()
+
+	// Serve the conversion webhook on every replica, rather than only on the
+	// leader, and before we list any WarmImages, some of which may still be
+	// stored as v2.
+	if *webhookAddr != "" {
+		cert, caBundle, err := webhook.GetOrCreateCertificate(kubeClient, *systemNamespace, webhookSecretName, *webhookService)
+		if err != nil {
+			logger.Fatalf("Error getting the webhook certificate: %s", err.Error())
+		}
+		if err := webhook.PatchCABundle(kubeClient, warmimageCRDName, caBundle); err != nil {
+			logger.Fatalf("Error configuring the conversion webhook: %s", err.Error())
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/convert", webhook.NewConversionHandler(logger))
+		server := &http.Server{
+			Addr:      *webhookAddr,
+			Handler:   mux,
+			TLSConfig: &tls.Config{Certificates: []tls.Certificate{*cert}},
+		}
+		go func() {
+			if err := server.ListenAndServeTLS("", ""); err != nil {
+				logger.Fatalf("Error serving the conversion webhook: %s", err.Error())
+			}
+		}()
+	}
+
 	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Second*30)
 	warmimageInformerFactory := informers.NewSharedInformerFactory(warmimageClient, time.Second*30)
+	// Only watch the pods that warm images, rather than every pod in the cluster.
+	podInformerFactory := kubeinformers.NewFilteredSharedInformerFactory(kubeClient, time.Second*30,
+		metav1.NamespaceAll, func(opts *metav1.ListOptions) {
+			opts.LabelSelector = resources.MakeAllLabelSelector().String()
+		})
+	// Only watch the ConfigMaps in our own namespace.
+	configMapInformerFactory := kubeinformers.NewFilteredSharedInformerFactory(kubeClient, time.Second*30,
+		*systemNamespace, nil)
+	// Only watch the secrets that we hand the node agents.
+	secretInformerFactory := kubeinformers.NewFilteredSharedInformerFactory(kubeClient, time.Second*30,
+		*agentNamespace, nil)
 
 	// obtain a reference to a shared index informer for the WarmImage type.
-	daemonsetInformer := kubeInformerFactory.Extensions().V1beta1().DaemonSets()
-	warmimageInformer := warmimageInformerFactory.Mattmoor().V2().WarmImages()
+	daemonsetInformer := kubeInformerFactory.Apps().V1().DaemonSets()
+	podInformer := podInformerFactory.Core().V1().Pods()
+	nodeInformer := kubeInformerFactory.Core().V1().Nodes()
+	priorityClassInformer := priorityclass.NewInformer(priorityclassClient, time.Second*30)
+	configMapInformer := configMapInformerFactory.Core().V1().ConfigMaps()
+	secretInformer := secretInformerFactory.Core().V1().Secrets()
+	warmimageInformer := warmimageInformerFactory.Mattmoor().V3().WarmImages()
+	clusterwarmimageInformer := warmimageInformerFactory.Mattmoor().V3().ClusterWarmImages()
+
+	// Export our metrics alongside those of the process.
+	metricsRegistry := prometheus.NewRegistry()
+	metricsRegistry.MustRegister(prometheus.NewProcessCollector(os.Getpid(), ""), prometheus.NewGoCollector())
+	stats, err := metrics.New(metricsRegistry)
+	if err != nil {
+		logger.Fatalf("Error registering metrics: %s", err.Error())
+	}
+	if err := stats.RegisterWarmImages(warmimageInformer.Lister(), clusterwarmimageInformer.Lister()); err != nil {
+		logger.Fatalf("Error registering metrics: %s", err.Error())
+	}
+
+	// Every controller shares the one configuration, from the
+	// config-warmimage ConfigMap.
+	configStore := config.NewStore(logger, *systemNamespace, config.New(*sleeper, *nodeAgent))
+	configStore.Watch(configMapInformer)
 
 	// Add new controllers here.
 	controllers := []*controller.Impl{
@@ -82,35 +193,110 @@ func main() {
 			kubeClient,
 			warmimageClient,
 			daemonsetInformer,
+			podInformer,
+			nodeInformer,
+			secretInformer,
+			priorityclassClient,
+			priorityClassInformer,
 			warmimageInformer,
-			*sleeper,
+			clusterwarmimageInformer,
+			registry.NewResolver(nil),
+			configStore,
+			*systemNamespace,
+			*agentNamespace,
+			stats,
+		),
+		warmimage.NewClusterController(
+			logger,
+			kubeClient,
+			warmimageClient,
+			daemonsetInformer,
+			podInformer,
+			nodeInformer,
+			secretInformer,
+			priorityclassClient,
+			priorityClassInformer,
+			warmimageInformer,
+			clusterwarmimageInformer,
+			registry.NewResolver(nil),
+			configStore,
+			*systemNamespace,
+			*agentNamespace,
+			stats,
+		),
+		eligibility.NewController(
+			logger,
+			kubeClient,
+			nodeInformer,
+			configStore,
+		),
+		startuptaint.NewController(
+			logger,
+			kubeClient,
+			nodeInformer,
+			warmimageInformer,
+			clusterwarmimageInformer,
+			configStore,
+			*systemNamespace,
 		),
 	}
 
+	// Update the logging level when the config-warmimage ConfigMap changes.
+	updateLevel := logging.UpdateLevelFromConfigMap(logger, atomicLevel, component, component)
+	configMapInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			cm, ok := obj.(*corev1.ConfigMap)
+			return ok && cm.Name == config.ConfigName
+		},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { updateLevel(obj.(*corev1.ConfigMap)) },
+			UpdateFunc: controller.PassNew(func(obj interface{}) { updateLevel(obj.(*corev1.ConfigMap)) }),
+		},
+	})
+
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+		go func() {
+			if err := http.ListenAndServe(*metricsAddr, mux); err != nil {
+				logger.Fatalf("Error serving metrics: %s", err.Error())
+			}
+		}()
+	}
+
 	go kubeInformerFactory.Start(stopCh)
 	go warmimageInformerFactory.Start(stopCh)
+	go podInformerFactory.Start(stopCh)
+	go configMapInformerFactory.Start(stopCh)
+	go secretInformerFactory.Start(stopCh)
+	go priorityClassInformer.Informer().Run(stopCh)
 
 	// Wait for the caches to be synced before starting controllers.
 	logger.Info("Waiting for informer caches to sync")
 	for i, synced := range []cache.InformerSynced{
 		daemonsetInformer.Informer().HasSynced,
+		podInformer.Informer().HasSynced,
+		nodeInformer.Informer().HasSynced,
+		configMapInformer.Informer().HasSynced,
+		secretInformer.Informer().HasSynced,
+		priorityClassInformer.Informer().HasSynced,
 		warmimageInformer.Informer().HasSynced,
+		clusterwarmimageInformer.Informer().HasSynced,
 	} {
 		if ok := cache.WaitForCacheSync(stopCh, synced); !ok {
 			logger.Fatalf("failed to wait for cache at index %v to sync", i)
 		}
 	}
 
-	// Start all of the controllers.
-	for _, ctrlr := range controllers {
-		go func(ctrlr *controller.Impl) {
-			// We don't expect this to return until stop is called,
-			// but if it does, propagate it back.
-			if err := ctrlr.Run(threadsPerController, stopCh); err != nil {
-				logger.Fatalf("Error running controller: %s", err.Error())
-			}
-		}(ctrlr)
+	// Start all of the controllers, and once we are told to stop, stop them
+	// and wait for what they are reconciling.
+	w := newWorkers(controllers, threadsPerController)
+	if *leaderElect {
+		// Only start the controllers once we are the leader.
+		runLeaderElection(logger, kubeClient, *systemNamespace, w, stopCh)
+	} else {
+		w.run(logger, stopCh)
+		<-stopCh
+		w.drain(logger, shutdownTimeout)
 	}
-
-	<-stopCh
 }
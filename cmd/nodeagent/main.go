@@ -0,0 +1,202 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/knative/pkg/controller"
+	"github.com/knative/pkg/logging"
+	"github.com/knative/pkg/signals"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+
+	clientset "github.com/mattmoor/warm-image/pkg/client/clientset/versioned"
+	informers "github.com/mattmoor/warm-image/pkg/client/informers/externalversions"
+	"github.com/mattmoor/warm-image/pkg/cri"
+	"github.com/mattmoor/warm-image/pkg/reconciler/nodeagent"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/config"
+)
+
+const (
+	// We pull one image at a time, like the kubelet does by default.
+	threadsPerController = 1
+	component            = "nodeagent"
+)
+
+var (
+	masterURL  = flag.String("kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
+	kubeconfig = flag.String("master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+	// The namespace in which ClusterWarmImages are warmed.
+	systemNamespace = flag.String("system-namespace", "warmimage-system", "The namespace in which to warm ClusterWarmImages.")
+	agentNamespace  = flag.String("agent-namespace", "warmimage-agents", "The namespace in which the controller keeps the secrets to pull with.")
+	runtimeEndpoint = flag.String("runtime-endpoint", config.DefaultRuntimeEndpoint, "The endpoint of the container runtime's CRI image service.")
+	runtimeTimeout  = flag.Duration("runtime-timeout", 2*time.Minute, "How long to wait for the container runtime, except for pulling images.")
+	nodeName        = flag.String("node-name", os.Getenv("NODE_NAME"), "The name of the node to pull images onto, by default $NODE_NAME.")
+	remove          = flag.Bool("remove", false, "Remove the images given as arguments from the node and exit, rather than run the agent, as the cleanup pods do.")
+	resyncPeriod    = flag.Duration("resync-period", 5*time.Minute, "How often to check that the images are still on the node.")
+)
+
+func main() {
+	flag.Parse()
+
+	// set up signals so we handle the first shutdown signal gracefully
+	stopCh := signals.SetupSignalHandler()
+
+	logger := logging.FromContext(context.TODO()).Named(component)
+
+	if *remove {
+		if err := removeImages(logger, flag.Args()); err != nil {
+			logger.Fatalf("Error removing images: %s", err.Error())
+		}
+		return
+	}
+
+	if *nodeName == "" {
+		logger.Fatal("Either -node-name or $NODE_NAME must be set")
+	}
+
+	cfg, err := clientcmd.BuildConfigFromFlags(*masterURL, *kubeconfig)
+	if err != nil {
+		logger.Fatalf("Error building kubeconfig: %s", err.Error())
+	}
+
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		logger.Fatalf("Error building kubernetes clientset: %s", err.Error())
+	}
+
+	warmimageClient, err := clientset.NewForConfig(cfg)
+	if err != nil {
+		logger.Fatalf("Error building warmimage clientset: %s", err.Error())
+	}
+
+	// Configure logging from the config-warmimage ConfigMap, if it exists.
+	loggingConfigMap, err := kubeClient.CoreV1().ConfigMaps(*systemNamespace).Get(config.ConfigName, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		logger.Fatalf("Error loading %s: %s", config.ConfigName, err.Error())
+	} else if err != nil {
+		loggingConfigMap = &corev1.ConfigMap{}
+	}
+	loggingConfig, err := logging.NewConfigFromConfigMap(loggingConfigMap, component)
+	if err != nil {
+		logger.Fatalf("Error parsing logging configuration: %s", err.Error())
+	}
+	logger, atomicLevel := logging.NewLoggerFromConfig(loggingConfig, component)
+	defer logger.Sync()
+
+	images, err := cri.NewImageService(*runtimeEndpoint, *runtimeTimeout)
+	if err != nil {
+		logger.Fatalf("Error connecting to the container runtime: %s", err.Error())
+	}
+	defer images.Close()
+
+	// Only watch our own node.
+	nodeInformerFactory := kubeinformers.NewFilteredSharedInformerFactory(kubeClient, *resyncPeriod,
+		metav1.NamespaceAll, func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", *nodeName).String()
+		})
+	// Only watch the ConfigMaps in our own namespace.
+	configMapInformerFactory := kubeinformers.NewFilteredSharedInformerFactory(kubeClient, *resyncPeriod,
+		*systemNamespace, nil)
+	warmimageInformerFactory := informers.NewSharedInformerFactory(warmimageClient, *resyncPeriod)
+
+	nodeInformer := nodeInformerFactory.Core().V1().Nodes()
+	configMapInformer := configMapInformerFactory.Core().V1().ConfigMaps()
+	warmimageInformer := warmimageInformerFactory.Mattmoor().V3().WarmImages()
+	clusterwarmimageInformer := warmimageInformerFactory.Mattmoor().V3().ClusterWarmImages()
+
+	configStore := config.NewStore(logger, *systemNamespace, config.New("", ""))
+	configStore.Watch(configMapInformer)
+
+	agent := nodeagent.NewController(
+		logger,
+		kubeClient,
+		nodeInformer,
+		warmimageInformer,
+		clusterwarmimageInformer,
+		images,
+		*nodeName,
+		configStore,
+		*systemNamespace,
+		*agentNamespace,
+	)
+
+	// Update the logging level when the config-warmimage ConfigMap changes.
+	updateLevel := logging.UpdateLevelFromConfigMap(logger, atomicLevel, component, component)
+	configMapInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			cm, ok := obj.(*corev1.ConfigMap)
+			return ok && cm.Name == config.ConfigName
+		},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { updateLevel(obj.(*corev1.ConfigMap)) },
+			UpdateFunc: controller.PassNew(func(obj interface{}) { updateLevel(obj.(*corev1.ConfigMap)) }),
+		},
+	})
+
+	go nodeInformerFactory.Start(stopCh)
+	go configMapInformerFactory.Start(stopCh)
+	go warmimageInformerFactory.Start(stopCh)
+
+	// Wait for the caches to be synced before starting the agent.
+	logger.Info("Waiting for informer caches to sync")
+	for i, synced := range []cache.InformerSynced{
+		nodeInformer.Informer().HasSynced,
+		configMapInformer.Informer().HasSynced,
+		warmimageInformer.Informer().HasSynced,
+		clusterwarmimageInformer.Informer().HasSynced,
+	} {
+		if ok := cache.WaitForCacheSync(stopCh, synced); !ok {
+			logger.Fatalf("failed to wait for cache at index %v to sync", i)
+		}
+	}
+
+	if err := agent.Run(threadsPerController, stopCh); err != nil {
+		logger.Fatalf("Error running node agent: %s", err.Error())
+	}
+}
+
+// removeImages removes the images from the node through the container
+// runtime. The cleanup pods that cool the nodes down do this, without any
+// access to the API server.
+func removeImages(logger *zap.SugaredLogger, images []string) error {
+	is, err := cri.NewImageService(*runtimeEndpoint, *runtimeTimeout)
+	if err != nil {
+		return err
+	}
+	defer is.Close()
+	for _, image := range images {
+		// Removing an image that the node doesn't have succeeds.
+		if err := is.RemoveImage(context.Background(), image); err != nil {
+			return fmt.Errorf("error removing %q: %v", image, err)
+		}
+		logger.Infof("Removed %q", image)
+	}
+	return nil
+}
@@ -27,7 +27,7 @@ import (
 )
 
 var (
-	mode = flag.String("mode", "sleep", "One of: sleep or copy")
+	mode = flag.String("mode", "sleep", "One of: sleep, exit or copy")
 	to   = flag.String("to", "", "Where to copy this binary")
 )
 
@@ -42,6 +42,8 @@ func main() {
 		// Sleep for 30 years.
 		time.Sleep(30 * 365 * 24 * time.Hour)
 		logger.Fatalf("Time to restart, goodbye cruel world.")
+	case "exit":
+		// Pulling the image was all that we were here for.
 	case "copy":
 		if *to == "" {
 			logger.Fatalf("-to must be specified with -mode=copy")
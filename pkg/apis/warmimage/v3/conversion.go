@@ -0,0 +1,56 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"bytes"
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// UnmarshalJSON implements json.Unmarshaler, converting the single
+// imagePullSecrets object of a v2 WarmImage into a list of one.
+func (wis *WarmImageSpec) UnmarshalJSON(b []byte) error {
+	// The spec type doesn't have our methods, so decoding into it doesn't
+	// recurse back into here.
+	type spec WarmImageSpec
+	var raw struct {
+		spec
+		// This shadows the ImagePullSecrets of the embedded spec.
+		ImagePullSecrets json.RawMessage `json:"imagePullSecrets,omitempty"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	*wis = WarmImageSpec(raw.spec)
+
+	ips := bytes.TrimSpace(raw.ImagePullSecrets)
+	switch {
+	case len(ips) == 0 || bytes.Equal(ips, []byte("null")):
+		wis.ImagePullSecrets = nil
+	case ips[0] == '{':
+		var secret corev1.LocalObjectReference
+		if err := json.Unmarshal(ips, &secret); err != nil {
+			return err
+		}
+		wis.ImagePullSecrets = []corev1.LocalObjectReference{secret}
+	default:
+		return json.Unmarshal(ips, &wis.ImagePullSecrets)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestWarmImageSpecUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    WarmImageSpec
+		wantErr bool
+	}{{
+		name: "v2 single object",
+		json: `{"image": "busybox", "imagePullSecrets": {"name": "my-secret"}}`,
+		want: WarmImageSpec{
+			Image:            "busybox",
+			ImagePullSecrets: []corev1.LocalObjectReference{{Name: "my-secret"}},
+		},
+	}, {
+		name: "v2 without secret",
+		json: `{"image": "busybox"}`,
+		want: WarmImageSpec{
+			Image: "busybox",
+		},
+	}, {
+		name: "null secrets",
+		json: `{"images": ["busybox"], "imagePullSecrets": null}`,
+		want: WarmImageSpec{
+			Images: []string{"busybox"},
+		},
+	}, {
+		name: "v3 list",
+		json: `{"images": ["busybox", "ubuntu"], "imagePullSecrets": [{"name": "a"}, {"name": "b"}], "serviceAccountName": "builder"}`,
+		want: WarmImageSpec{
+			Images:             []string{"busybox", "ubuntu"},
+			ImagePullSecrets:   []corev1.LocalObjectReference{{Name: "a"}, {Name: "b"}},
+			ServiceAccountName: "builder",
+		},
+	}, {
+		name: "v3 empty list",
+		json: `{"images": ["busybox"], "imagePullSecrets": []}`,
+		want: WarmImageSpec{
+			Images:           []string{"busybox"},
+			ImagePullSecrets: []corev1.LocalObjectReference{},
+		},
+	}, {
+		name:    "invalid single object",
+		json:    `{"image": "busybox", "imagePullSecrets": {"name": 42}}`,
+		wantErr: true,
+	}, {
+		name:    "invalid secrets",
+		json:    `{"image": "busybox", "imagePullSecrets": "my-secret"}`,
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got WarmImageSpec
+			err := json.Unmarshal([]byte(test.json), &got)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Unmarshal() = %v, wanted error %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Unmarshal() = %+v, wanted %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWarmImageUnmarshalJSON(t *testing.T) {
+	// The spec of a v2 WarmImage decodes as part of the whole object.
+	var wi WarmImage
+	if err := json.Unmarshal([]byte(`{
+  "apiVersion": "mattmoor.io/v2",
+  "kind": "WarmImage",
+  "metadata": {"name": "foo", "namespace": "bar"},
+  "spec": {"image": "busybox", "imagePullSecrets": {"name": "my-secret"}}
+}`), &wi); err != nil {
+		t.Fatalf("Unmarshal() = %v", err)
+	}
+	wi.SetDefaults()
+	if got, want := wi.Spec.Images, []string{"busybox"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Images = %v, wanted %v", got, want)
+	}
+	if got, want := wi.Spec.ImagePullSecrets, []corev1.LocalObjectReference{{Name: "my-secret"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ImagePullSecrets = %v, wanted %v", got, want)
+	}
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+// SetDefaults fills in the defaults for the WarmImage, folding the single
+// image of a v2 WarmImage into Images.
+func (wi *WarmImage) SetDefaults() {
+	wi.Spec.SetDefaults()
+}
+
+// SetDefaults fills in the defaults for the WarmImageSpec.
+func (wis *WarmImageSpec) SetDefaults() {
+	if wis.Strategy == "" {
+		wis.Strategy = WarmImageStrategyPinned
+	}
+	if wis.Priority == "" {
+		wis.Priority = WarmImagePriorityNormal
+	}
+	if wis.Image == "" {
+		return
+	}
+	for _, image := range wis.Images {
+		if image == wis.Image {
+			wis.Image = ""
+			return
+		}
+	}
+	wis.Images = append([]string{wis.Image}, wis.Images...)
+	wis.Image = ""
+}
@@ -0,0 +1,367 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"fmt"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GetCondition returns the condition of the given type, or nil if it is unset.
+func (wis *WarmImageStatus) GetCondition(t WarmImageConditionType) *WarmImageCondition {
+	for i := range wis.Conditions {
+		if wis.Conditions[i].Type == t {
+			return &wis.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// IsReady returns whether the images are warm on every targeted node.
+func (wis *WarmImageStatus) IsReady() bool {
+	c := wis.GetCondition(WarmImageConditionReady)
+	return c != nil && c.Status == corev1.ConditionTrue
+}
+
+// InitializeImages makes the per-image statuses match the given images,
+// keeping what we already know about images that we were already warming.
+func (wis *WarmImageStatus) InitializeImages(images []string) {
+	statuses := make([]WarmImageImageStatus, 0, len(images))
+	for _, image := range images {
+		if is := wis.GetImage(image); is != nil {
+			statuses = append(statuses, *is)
+		} else {
+			statuses = append(statuses, WarmImageImageStatus{Image: image})
+		}
+	}
+	wis.Images = statuses
+}
+
+// GetImage returns the status of the given image, or nil if it is unset.
+func (wis *WarmImageStatus) GetImage(image string) *WarmImageImageStatus {
+	for i := range wis.Images {
+		if wis.Images[i].Image == image {
+			return &wis.Images[i]
+		}
+	}
+	return nil
+}
+
+// MarkResolved records that the image's tag resolved to the given digest,
+// which supports the given platforms.
+func (wis *WarmImageStatus) MarkResolved(image, digest string, platforms []string) {
+	now := metav1.Now()
+	wis.LastResolvedTime = now
+	is := wis.GetImage(image)
+	if is == nil {
+		wis.Images = append(wis.Images, WarmImageImageStatus{Image: image})
+		is = &wis.Images[len(wis.Images)-1]
+	}
+	is.Platforms = platforms
+	if digest == is.Digest {
+		return
+	}
+	is.Digest = digest
+	is.DigestHistory = append(is.DigestHistory, WarmImageDigestTransition{
+		Digest: digest,
+		Time:   now,
+	})
+	if extra := len(is.DigestHistory) - MaxDigestHistory; extra > 0 {
+		is.DigestHistory = is.DigestHistory[extra:]
+	}
+}
+
+// MarkResolveFailed records that resolving the images of the given
+// generation of the spec failed, counting the consecutive failures of that
+// generation.
+func (wis *WarmImageStatus) MarkResolveFailed(generation int64) {
+	f := wis.ResolveFailure
+	if f == nil || f.Generation != generation {
+		f = &WarmImageResolveFailure{Generation: generation}
+	}
+	f.Count++
+	f.Time = metav1.Now()
+	wis.ResolveFailure = f
+}
+
+// PropagateDaemonSetStatuses records the node coverage of the DaemonSets
+// warming the images, one per architecture, and updates the Ready and
+// Progressing conditions to match.
+func (wis *WarmImageStatus) PropagateDaemonSetStatuses(dss []*appsv1.DaemonSet) {
+	wis.DaemonSetNames = nil
+	wis.DesiredNodes, wis.ReadyNodes, wis.UnavailableNodes = 0, 0, 0
+	var unobserved string
+	for _, ds := range dss {
+		wis.DaemonSetNames = append(wis.DaemonSetNames, ds.Name)
+		wis.DesiredNodes += ds.Status.DesiredNumberScheduled
+		wis.ReadyNodes += ds.Status.NumberReady
+		wis.UnavailableNodes += ds.Status.NumberUnavailable
+		if ds.Status.ObservedGeneration < ds.Generation && unobserved == "" {
+			unobserved = ds.Name
+		}
+	}
+
+	wis.setCondition(WarmImageConditionFailed, corev1.ConditionFalse, "", "")
+	switch {
+	case unobserved != "":
+		wis.markProgressing("Deploying", "Waiting for DaemonSet %q to be observed.", unobserved)
+	case wis.ReadyNodes < wis.DesiredNodes || wis.UnavailableNodes > 0:
+		wis.markProgressing("Warming", "Images are warm on %d of %d nodes.",
+			wis.ReadyNodes, wis.DesiredNodes)
+	default:
+		wis.setCondition(WarmImageConditionProgressing, corev1.ConditionFalse, "", "")
+		wis.setCondition(WarmImageConditionReady, corev1.ConditionTrue, "", "")
+	}
+}
+
+// PropagateCompletions records on how many of the targeted nodes the
+// one-shot pods or node agents have pulled the images, and updates the Ready
+// and Progressing conditions to match.
+func (wis *WarmImageStatus) PropagateCompletions(desired, completed int32) {
+	wis.DaemonSetNames = nil
+	wis.DesiredNodes = desired
+	wis.ReadyNodes = completed
+	wis.UnavailableNodes = desired - completed
+
+	wis.setCondition(WarmImageConditionFailed, corev1.ConditionFalse, "", "")
+	if completed < desired {
+		wis.markProgressing("Pulling", "Images are pulled onto %d of %d nodes.", completed, desired)
+	} else {
+		wis.setCondition(WarmImageConditionProgressing, corev1.ConditionFalse, "", "")
+		wis.setCondition(WarmImageConditionReady, corev1.ConditionTrue, "", "")
+	}
+}
+
+// MarkRollingOut records that the older versions are being kept until the new
+// version is warm on enough nodes.
+func (wis *WarmImageStatus) MarkRollingOut(oldDaemonSetNames []string, readyPercent int32, startTime metav1.Time) {
+	wis.Rollout = &WarmImageRolloutStatus{
+		OldDaemonSetNames: oldDaemonSetNames,
+		ReadyPercent:      readyPercent,
+		StartTime:         startTime,
+	}
+}
+
+// MarkRolledOut records that no older versions are being kept.
+func (wis *WarmImageStatus) MarkRolledOut() {
+	wis.Rollout = nil
+}
+
+// MarkCoolingDown records how far removing the images from the nodes has
+// gotten while the object that warmed them is being deleted.
+func (wis *WarmImageStatus) MarkCoolingDown(desired, removed int32, deadline metav1.Time) {
+	wis.CoolDown = &WarmImageCoolDownStatus{
+		DesiredNodes: desired,
+		RemovedNodes: removed,
+		Deadline:     deadline,
+	}
+	wis.markProgressing("CoolingDown", "Images are removed from %d of %d nodes.", removed, desired)
+}
+
+// transientReasons are the reasons a warm pod or node agent reports while it
+// is making normal progress, which should not be counted as failures.
+var transientReasons = map[string]bool{
+	"ContainerCreating": true,
+	"PodInitializing":   true,
+	"Pulling":           true,
+}
+
+// IsFailing returns whether an image failed to warm on the node.
+func (wns *WarmImageNodeStatus) IsFailing() bool {
+	return wns.Reason != "" && !transientReasons[wns.Reason]
+}
+
+// PropagateNodeStatuses records the warm state of the images on each node,
+// keeping at most MaxNodeStatuses of them, and summarizes the failing nodes
+// by reason and image.
+func (wis *WarmImageStatus) PropagateNodeStatuses(nodes []WarmImageNodeStatus) {
+	sorted := make([]WarmImageNodeStatus, len(nodes))
+	copy(sorted, nodes)
+	// Nodes that aren't warm are the interesting ones, so list them first.
+	sort.Slice(sorted, func(i, j int) bool {
+		iWarm, jWarm := sorted[i].Reason == "", sorted[j].Reason == ""
+		if iWarm != jWarm {
+			return jWarm
+		}
+		return sorted[i].NodeName < sorted[j].NodeName
+	})
+
+	type failureKey struct {
+		reason, image string
+	}
+	var failures []WarmImageFailure
+	byReason := make(map[failureKey]int)
+	for _, n := range sorted {
+		if !n.IsFailing() {
+			continue
+		}
+		key := failureKey{reason: n.Reason, image: n.Image}
+		idx, ok := byReason[key]
+		if !ok {
+			idx = len(failures)
+			byReason[key] = idx
+			failures = append(failures, WarmImageFailure{Reason: n.Reason, Image: n.Image})
+		}
+		f := &failures[idx]
+		f.Count++
+		if len(f.Nodes) < MaxFailureNodes {
+			f.Nodes = append(f.Nodes, n.NodeName)
+		}
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		if failures[i].Reason != failures[j].Reason {
+			return failures[i].Reason < failures[j].Reason
+		}
+		return failures[i].Image < failures[j].Image
+	})
+
+	if len(sorted) > MaxNodeStatuses {
+		sorted = sorted[:MaxNodeStatuses]
+	}
+	if len(sorted) == 0 {
+		sorted = nil
+	}
+	wis.Nodes = sorted
+	wis.Failures = failures
+}
+
+// PropagateExclusions records the targeted nodes that are ineligible for
+// warming, given their names by the reason why.
+func (wis *WarmImageStatus) PropagateExclusions(excluded map[string][]string) {
+	var exclusions []WarmImageExclusion
+	var count int32
+	for reason, nodes := range excluded {
+		sample := append([]string{}, nodes...)
+		sort.Strings(sample)
+		if len(sample) > MaxFailureNodes {
+			sample = sample[:MaxFailureNodes]
+		}
+		exclusions = append(exclusions, WarmImageExclusion{
+			Reason: reason,
+			Count:  int32(len(nodes)),
+			Nodes:  sample,
+		})
+		count += int32(len(nodes))
+	}
+	sort.Slice(exclusions, func(i, j int) bool {
+		return exclusions[i].Reason < exclusions[j].Reason
+	})
+	wis.ExcludedNodes = count
+	wis.Exclusions = exclusions
+}
+
+// PropagateNewNodes tracks how long the images take to warm onto the nodes
+// that joined the cluster, given when each of the eligible targeted nodes that
+// joined since this was created did so, and which nodes the images are warm
+// on. It starts tracking the nodes that joined after the given time, stops
+// tracking those that are no longer eligible before the images warm, and
+// returns those that the images have just warmed onto, whose time to warm is
+// yet to be recorded, marking them as recorded now.
+func (wis *WarmImageStatus) PropagateNewNodes(joined map[string]metav1.Time, warm map[string]bool, since, now metav1.Time) []WarmImageNewNode {
+	var warming, warmed, unrecorded []WarmImageNewNode
+	tracked := make(map[string]bool, len(wis.NewNodes))
+	for _, nn := range wis.NewNodes {
+		tracked[nn.NodeName] = true
+		if nn.WarmTime != nil {
+			warmed = append(warmed, nn)
+		} else if _, ok := joined[nn.NodeName]; ok {
+			warming = append(warming, nn)
+		}
+	}
+	for name, joinTime := range joined {
+		if !tracked[name] && joinTime.After(since.Time) {
+			warming = append(warming, WarmImageNewNode{NodeName: name, JoinTime: joinTime})
+		}
+	}
+
+	stillWarming := warming[:0]
+	for _, nn := range warming {
+		if !warm[nn.NodeName] {
+			stillWarming = append(stillWarming, nn)
+			continue
+		}
+		warmTime := now
+		nn.WarmTime = &warmTime
+		nn.TimeToWarm = &metav1.Duration{Duration: now.Sub(nn.JoinTime.Time)}
+		if nn.RecordedTime == nil {
+			recordedTime := now
+			nn.RecordedTime = &recordedTime
+			unrecorded = append(unrecorded, nn)
+		}
+		warmed = append(warmed, nn)
+	}
+	sort.Slice(stillWarming, func(i, j int) bool {
+		return stillWarming[i].NodeName < stillWarming[j].NodeName
+	})
+	// Keep the nodes that the images most recently warmed onto, and those
+	// that joined after the given time, so that we don't track and record
+	// them again.
+	sort.SliceStable(warmed, func(i, j int) bool {
+		return warmed[j].WarmTime.Before(warmed[i].WarmTime)
+	})
+	kept := warmed[:0]
+	for i, nn := range warmed {
+		if i < MaxNewNodes || nn.JoinTime.After(since.Time) {
+			kept = append(kept, nn)
+		}
+	}
+
+	newNodes := append(stillWarming, kept...)
+	if len(newNodes) == 0 {
+		newNodes = nil
+	}
+	wis.NewNodes = newNodes
+	return unrecorded
+}
+
+// MarkFailed records that the controller was unable to warm the images.
+func (wis *WarmImageStatus) MarkFailed(reason, messageFormat string, messageA ...interface{}) {
+	message := fmt.Sprintf(messageFormat, messageA...)
+	wis.setCondition(WarmImageConditionFailed, corev1.ConditionTrue, reason, message)
+	wis.setCondition(WarmImageConditionProgressing, corev1.ConditionFalse, reason, message)
+	wis.setCondition(WarmImageConditionReady, corev1.ConditionFalse, reason, message)
+}
+
+func (wis *WarmImageStatus) markProgressing(reason, messageFormat string, messageA ...interface{}) {
+	message := fmt.Sprintf(messageFormat, messageA...)
+	wis.setCondition(WarmImageConditionProgressing, corev1.ConditionTrue, reason, message)
+	wis.setCondition(WarmImageConditionReady, corev1.ConditionFalse, reason, message)
+}
+
+// setCondition updates the condition of the given type, only bumping its
+// LastTransitionTime when its status actually changes.
+func (wis *WarmImageStatus) setCondition(t WarmImageConditionType, status corev1.ConditionStatus, reason, message string) {
+	cond := WarmImageCondition{
+		Type:               t,
+		Status:             status,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            message,
+	}
+	if old := wis.GetCondition(t); old != nil {
+		if old.Status == status {
+			cond.LastTransitionTime = old.LastTransitionTime
+		}
+		*old = cond
+		return
+	}
+	wis.Conditions = append(wis.Conditions, cond)
+}
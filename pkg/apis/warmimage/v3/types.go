@@ -0,0 +1,580 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// WarmImage is a specification for a WarmImage resource
+type WarmImage struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WarmImageSpec   `json:"spec"`
+	Status WarmImageStatus `json:"status"`
+}
+
+// WarmImageSpec is the spec for a WarmImage resource
+type WarmImageSpec struct {
+	// Images are the images to warm. They are all warmed by a single pod
+	// on each node.
+	// +optional
+	Images []string `json:"images,omitempty"`
+
+	// Image is the single image that a v2 WarmImage warms.
+	// Deprecated: Use Images instead; SetDefaults folds Image into Images.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// ImagePullSecrets are the secrets with the credentials to pull the
+	// images. A v2 WarmImage's single secret is accepted too, see
+	// UnmarshalJSON.
+	// +optional
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+
+	// ServiceAccountName is the name of the ServiceAccount to run the warm
+	// pods as, which pull the images with the ServiceAccount's
+	// imagePullSecrets in addition to ImagePullSecrets.
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// NodeSelector restricts warming the images to the nodes with matching
+	// labels.
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// NodeAffinity restricts warming the images to the nodes that satisfy
+	// its scheduling constraints.
+	// +optional
+	NodeAffinity *corev1.NodeAffinity `json:"nodeAffinity,omitempty"`
+
+	// Tolerations allow warming the images onto tainted nodes.
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// RefreshInterval is how often to re-resolve the images' tags. When a
+	// tag has moved, the new image is warmed in place of the old one. When
+	// unset, the tags are only resolved when the spec changes.
+	// +optional
+	RefreshInterval *metav1.Duration `json:"refreshInterval,omitempty"`
+
+	// Rollout keeps the warm pods of older versions of the spec until
+	// those of the new version are warm on enough nodes. When unset, the
+	// older versions are removed as soon as the new version is created.
+	// +optional
+	Rollout *WarmImageRollout `json:"rollout,omitempty"`
+
+	// Strategy is how the images are kept warm on the nodes: Pinned,
+	// OneShot or NodeAgent. Defaults to Pinned.
+	// +optional
+	Strategy WarmImageStrategy `json:"strategy,omitempty"`
+
+	// CoolDown removes the images from the nodes when this is deleted,
+	// e.g. because they are being retired for security reasons, rather
+	// than leave them for the kubelet to garbage collect. When unset, the
+	// images are left on the nodes.
+	// +optional
+	CoolDown *WarmImageCoolDown `json:"coolDown,omitempty"`
+
+	// Priority is how urgently the images are warmed onto the nodes that
+	// join the cluster: Normal or High. The warm pods of High priority
+	// images get the controller's high PriorityClass, so that they are
+	// scheduled onto new nodes ahead of the workloads that need the images.
+	// Defaults to Normal.
+	// +optional
+	Priority WarmImagePriority `json:"priority,omitempty"`
+
+	// Required holds back the nodes that join the cluster with the startup
+	// taint configured in the config-warmimage ConfigMap, if any, until the
+	// images are warm on them: the controller only removes the taint from a
+	// node once the images of every Required WarmImage and ClusterWarmImage
+	// that targets it are warm there.
+	// +optional
+	Required bool `json:"required,omitempty"`
+}
+
+// WarmImageStrategy is how the images are kept warm on the nodes.
+type WarmImageStrategy string
+
+const (
+	// WarmImageStrategyPinned keeps a pod running the images on every
+	// node, so that the kubelet never garbage collects them.
+	WarmImageStrategyPinned WarmImageStrategy = "Pinned"
+
+	// WarmImageStrategyOneShot pulls the images onto every node once,
+	// with a pod that exits right away, so that warming them doesn't take
+	// up pod slots or memory. The kubelet may garbage collect the images
+	// once they are unused, and they aren't pulled again until the spec
+	// changes, but they are pulled onto the nodes that join later.
+	WarmImageStrategyOneShot WarmImageStrategy = "OneShot"
+
+	// WarmImageStrategyNodeAgent leaves pulling the images to the node
+	// agent on every node, see //cmd/nodeagent, which pulls them through
+	// the container runtime without running any pods, and pulls them again
+	// if the kubelet garbage collects them.
+	WarmImageStrategyNodeAgent WarmImageStrategy = "NodeAgent"
+)
+
+// WarmImagePriority is how urgently the images are warmed onto the nodes.
+type WarmImagePriority string
+
+const (
+	// WarmImagePriorityNormal gives the warm pods the priority class
+	// configured in the config-warmimage ConfigMap, if any.
+	WarmImagePriorityNormal WarmImagePriority = "Normal"
+
+	// WarmImagePriorityHigh gives the warm pods the controller's high
+	// PriorityClass, and warms the images onto the nodes that join the
+	// cluster before those of Normal priority.
+	WarmImagePriorityHigh WarmImagePriority = "High"
+)
+
+// WarmImageRollout configures how a new version of the warm pods replaces
+// the older ones, so that no node goes cold in between.
+type WarmImageRollout struct {
+	// ReadyPercent is the percentage of its nodes on which the new version
+	// must be warm before the older versions are removed. Defaults to 100.
+	// +optional
+	ReadyPercent *int32 `json:"readyPercent,omitempty"`
+
+	// Timeout is how long to wait for the new version to reach ReadyPercent
+	// before removing the older versions anyway. Defaults to 10m.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// WarmImageCoolDown configures removing the images from the nodes, through
+// the node agents or through a cleanup pod on each node, once the object that
+// warmed them is deleted.
+type WarmImageCoolDown struct {
+	// Timeout is how long to keep trying to remove the images before
+	// letting the deletion complete anyway. Defaults to 10m.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// WarmImageConditionType is the type of a condition on a WarmImage.
+type WarmImageConditionType string
+
+const (
+	// WarmImageConditionReady is set when the images have been warmed onto
+	// every node targeted by the WarmImage.
+	WarmImageConditionReady WarmImageConditionType = "Ready"
+	// WarmImageConditionProgressing is set while the images are still being
+	// pulled onto some of the targeted nodes.
+	WarmImageConditionProgressing WarmImageConditionType = "Progressing"
+	// WarmImageConditionFailed is set when the controller was unable to
+	// set up the resources that warm the images.
+	WarmImageConditionFailed WarmImageConditionType = "Failed"
+)
+
+// WarmImageCondition describes the state of a WarmImage at a point in time.
+type WarmImageCondition struct {
+	Type WarmImageConditionType `json:"type"`
+
+	Status corev1.ConditionStatus `json:"status"`
+
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// WarmImageStatus is the status for a WarmImage resource
+type WarmImageStatus struct {
+	// ObservedGeneration is the most recent generation of the WarmImage
+	// that the controller has acted upon.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// +optional
+	Conditions []WarmImageCondition `json:"conditions,omitempty"`
+
+	// Images holds the state of each of the images being warmed, in the
+	// order of spec.images.
+	// +optional
+	Images []WarmImageImageStatus `json:"images,omitempty"`
+
+	// LastResolvedTime is when the images' tags were last resolved.
+	// +optional
+	LastResolvedTime metav1.Time `json:"lastResolvedTime,omitempty"`
+
+	// ResolveFailure records the failures to resolve the images' tags since
+	// they last resolved, so that we back off from the registry.
+	// +optional
+	ResolveFailure *WarmImageResolveFailure `json:"resolveFailure,omitempty"`
+
+	// DaemonSetNames are the names of the DaemonSets currently warming the
+	// images, one per architecture of the targeted nodes.
+	// +optional
+	DaemonSetNames []string `json:"daemonSetNames,omitempty"`
+
+	// Rollout reports on the rollout of a new version while the warm pods
+	// of older versions are being kept.
+	// +optional
+	Rollout *WarmImageRolloutStatus `json:"rollout,omitempty"`
+
+	// DesiredNodes is the number of nodes that should have the images warm,
+	// which only counts the nodes targeted by the WarmImage's nodeSelector,
+	// nodeAffinity and tolerations.
+	DesiredNodes int32 `json:"desiredNodes"`
+
+	// ReadyNodes is the number of nodes on which all of the images are warm.
+	ReadyNodes int32 `json:"readyNodes"`
+
+	// UnavailableNodes is the number of nodes on which the images are not
+	// (yet) warm.
+	UnavailableNodes int32 `json:"unavailableNodes"`
+
+	// PresentNodes is the number of targeted nodes whose kubelet reports
+	// having all of the images on disk, whatever the state of the warm pods.
+	// +optional
+	PresentNodes int32 `json:"presentNodes,omitempty"`
+
+	// Nodes holds the warm state of the images on at most MaxNodeStatuses
+	// nodes, listing the nodes on which the images are not warm first.
+	// +optional
+	Nodes []WarmImageNodeStatus `json:"nodes,omitempty"`
+
+	// Failures groups the nodes on which an image failed to warm by reason.
+	// +optional
+	Failures []WarmImageFailure `json:"failures,omitempty"`
+
+	// ExcludedNodes is the number of targeted nodes that are ineligible for
+	// warming, e.g. because they are cordoned or under DiskPressure. These
+	// nodes are not counted in DesiredNodes.
+	// +optional
+	ExcludedNodes int32 `json:"excludedNodes,omitempty"`
+
+	// Exclusions groups the ineligible nodes by the reason why.
+	// +optional
+	Exclusions []WarmImageExclusion `json:"exclusions,omitempty"`
+
+	// SkippedPlatforms are the platforms of the nodes that the WarmImage
+	// targets, but that its images don't all support, so that they are not
+	// warmed there. These nodes are not counted in DesiredNodes.
+	// +optional
+	SkippedPlatforms []WarmImageSkippedPlatform `json:"skippedPlatforms,omitempty"`
+
+	// NewNodes reports how long the images took to warm onto the nodes that
+	// joined the cluster since this was created: those they are still
+	// warming onto, and the most recent MaxNewNodes that they are warm on,
+	// or more while they joined too recently to be forgotten.
+	// +optional
+	NewNodes []WarmImageNewNode `json:"newNodes,omitempty"`
+
+	// CoolDown reports on removing the images from the nodes while this is
+	// being deleted.
+	// +optional
+	CoolDown *WarmImageCoolDownStatus `json:"coolDown,omitempty"`
+}
+
+// WarmImageResolveFailure records the consecutive failures to resolve the
+// images' tags.
+type WarmImageResolveFailure struct {
+	// Generation is the generation of the spec whose images failed to
+	// resolve. A new generation is resolved right away.
+	Generation int64 `json:"generation"`
+
+	// Count is the number of consecutive failures.
+	Count int32 `json:"count"`
+
+	// Time is when the last failure happened.
+	Time metav1.Time `json:"time"`
+}
+
+// WarmImageCoolDownStatus is the progress of removing the images from the
+// nodes.
+type WarmImageCoolDownStatus struct {
+	// DesiredNodes is the number of nodes to remove the images from.
+	DesiredNodes int32 `json:"desiredNodes"`
+
+	// RemovedNodes is the number of nodes the images were removed from.
+	RemovedNodes int32 `json:"removedNodes"`
+
+	// Deadline is when we stop trying to remove the images.
+	Deadline metav1.Time `json:"deadline"`
+}
+
+// WarmImageNewNode is how long the images took to warm onto a node that
+// joined the cluster.
+type WarmImageNewNode struct {
+	NodeName string `json:"nodeName"`
+
+	// JoinTime is when the node joined the cluster.
+	JoinTime metav1.Time `json:"joinTime"`
+
+	// WarmTime is when the images were first warm on the node, unset while
+	// they are still warming.
+	// +optional
+	WarmTime *metav1.Time `json:"warmTime,omitempty"`
+
+	// TimeToWarm is how long the images took to warm, from JoinTime to
+	// WarmTime.
+	// +optional
+	TimeToWarm *metav1.Duration `json:"timeToWarm,omitempty"`
+
+	// RecordedTime is when the controller recorded TimeToWarm in its
+	// metrics, so that it records it once per node, across restarts of the
+	// controller and changes of its leader.
+	// +optional
+	RecordedTime *metav1.Time `json:"recordedTime,omitempty"`
+}
+
+// WarmImageExclusion summarizes the nodes that are ineligible for warming for
+// a particular reason.
+type WarmImageExclusion struct {
+	// Reason is why the nodes are ineligible, for example Unschedulable,
+	// NotReady or DiskPressure.
+	Reason string `json:"reason"`
+
+	// Count is the number of nodes that are ineligible for this reason.
+	Count int32 `json:"count"`
+
+	// Nodes is a sample of at most MaxFailureNodes of those nodes.
+	// +optional
+	Nodes []string `json:"nodes,omitempty"`
+}
+
+// WarmImageSkippedPlatform is a platform on which the images are not warmed.
+type WarmImageSkippedPlatform struct {
+	// Platform is the os/arch of the nodes.
+	Platform string `json:"platform"`
+
+	// Nodes is the number of targeted nodes with this platform.
+	Nodes int32 `json:"nodes"`
+}
+
+// WarmImageRolloutStatus is the progress of a rollout.
+type WarmImageRolloutStatus struct {
+	// OldDaemonSetNames are the DaemonSets of the older versions that are
+	// being kept until the rollout completes.
+	OldDaemonSetNames []string `json:"oldDaemonSetNames"`
+
+	// ReadyPercent is the percentage of its nodes on which the new version
+	// is warm.
+	ReadyPercent int32 `json:"readyPercent"`
+
+	// StartTime is when the new version was created.
+	StartTime metav1.Time `json:"startTime"`
+}
+
+// WarmImageImageStatus is the state of one of the images being warmed.
+type WarmImageImageStatus struct {
+	// Image is the image as it appears in spec.images.
+	Image string `json:"image"`
+
+	// Digest is the digest that the image's tag resolved to, which the
+	// warm pods are pinned to.
+	// +optional
+	Digest string `json:"digest,omitempty"`
+
+	// DigestHistory records the most recent MaxDigestHistory digests that
+	// the image's tag has resolved to, oldest first.
+	// +optional
+	DigestHistory []WarmImageDigestTransition `json:"digestHistory,omitempty"`
+
+	// Platforms are the platforms, as os/arch, that the image's digest
+	// supports.
+	// +optional
+	Platforms []string `json:"platforms,omitempty"`
+
+	// ReadyNodes is the number of nodes on which this image is warm.
+	ReadyNodes int32 `json:"readyNodes"`
+
+	// PresentNodes is the number of targeted nodes whose kubelet reports
+	// having this image on disk.
+	// +optional
+	PresentNodes int32 `json:"presentNodes,omitempty"`
+}
+
+// WarmImageDigestTransition records when an image's tag was first seen
+// resolving to a digest.
+type WarmImageDigestTransition struct {
+	Digest string `json:"digest"`
+
+	Time metav1.Time `json:"time"`
+}
+
+const (
+	// MaxDigestHistory bounds the number of entries in
+	// WarmImageImageStatus.DigestHistory.
+	MaxDigestHistory = 10
+
+	// MaxNodeStatuses bounds the number of entries in WarmImageStatus.Nodes,
+	// so that the status of a WarmImage stays small on large clusters.
+	MaxNodeStatuses = 50
+
+	// MaxFailureNodes bounds the number of sample nodes listed for each
+	// entry in WarmImageStatus.Failures.
+	MaxFailureNodes = 5
+
+	// MaxNewNodes bounds the number of nodes with warm images listed in
+	// WarmImageStatus.NewNodes.
+	MaxNewNodes = 10
+)
+
+// WarmImageNodeStatus is the warm state of the images on a single node.
+type WarmImageNodeStatus struct {
+	NodeName string `json:"nodeName"`
+
+	// Phase is the phase of the warm pod on the node, if any.
+	Phase corev1.PodPhase `json:"phase,omitempty"`
+
+	// Reason is why the images are not (yet) warm on the node, for example
+	// ErrImagePull or ImagePullBackOff, or ImageMissing when the warm pod is
+	// running but the node doesn't report having the image on disk.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// Image is the image that Reason applies to, if any.
+	// +optional
+	Image string `json:"image,omitempty"`
+}
+
+// WarmImageFailure summarizes the nodes on which an image failed to warm
+// for a particular reason.
+type WarmImageFailure struct {
+	Reason string `json:"reason"`
+
+	// Image is the image that failed to warm, if any.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Count is the number of nodes failing for this reason.
+	Count int32 `json:"count"`
+
+	// Nodes is a sample of at most MaxFailureNodes of the failing nodes.
+	// +optional
+	Nodes []string `json:"nodes,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// WarmImageList is a list of WarmImage resources
+type WarmImageList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []WarmImage `json:"items"`
+}
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ClusterWarmImage is a specification for a ClusterWarmImage resource, which
+// warms images cluster-wide from the controller's system namespace.
+type ClusterWarmImage struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterWarmImageSpec `json:"spec"`
+	Status WarmImageStatus      `json:"status"`
+}
+
+// ClusterWarmImageSpec is the spec for a ClusterWarmImage resource
+type ClusterWarmImageSpec struct {
+	// Images are the images to warm. They are all warmed by a single pod
+	// on each node.
+	Images []string `json:"images"`
+
+	// ImagePullSecrets are the secrets with the credentials to pull the
+	// images. Secrets outside of the system namespace are copied into it,
+	// and secrets without a namespace are taken from it.
+	// +optional
+	ImagePullSecrets []corev1.SecretReference `json:"imagePullSecrets,omitempty"`
+
+	// ServiceAccountName is the name of the ServiceAccount in the system
+	// namespace to run the warm pods as, which pull the images with the
+	// ServiceAccount's imagePullSecrets in addition to ImagePullSecrets.
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// NodeSelector restricts warming the images to the nodes with matching
+	// labels.
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// NodeAffinity restricts warming the images to the nodes that satisfy
+	// its scheduling constraints.
+	// +optional
+	NodeAffinity *corev1.NodeAffinity `json:"nodeAffinity,omitempty"`
+
+	// Tolerations allow warming the images onto tainted nodes.
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// RefreshInterval is how often to re-resolve the images' tags. When a
+	// tag has moved, the new image is warmed in place of the old one. When
+	// unset, the tags are only resolved when the spec changes.
+	// +optional
+	RefreshInterval *metav1.Duration `json:"refreshInterval,omitempty"`
+
+	// Rollout keeps the warm pods of older versions of the spec until
+	// those of the new version are warm on enough nodes. When unset, the
+	// older versions are removed as soon as the new version is created.
+	// +optional
+	Rollout *WarmImageRollout `json:"rollout,omitempty"`
+
+	// Strategy is how the images are kept warm on the nodes: Pinned,
+	// OneShot or NodeAgent. Defaults to Pinned.
+	// +optional
+	Strategy WarmImageStrategy `json:"strategy,omitempty"`
+
+	// CoolDown removes the images from the nodes when this is deleted,
+	// e.g. because they are being retired for security reasons, rather
+	// than leave them for the kubelet to garbage collect. When unset, the
+	// images are left on the nodes.
+	// +optional
+	CoolDown *WarmImageCoolDown `json:"coolDown,omitempty"`
+
+	// Priority is how urgently the images are warmed onto the nodes that
+	// join the cluster: Normal or High. The warm pods of High priority
+	// images get the controller's high PriorityClass, so that they are
+	// scheduled onto new nodes ahead of the workloads that need the images.
+	// Defaults to Normal.
+	// +optional
+	Priority WarmImagePriority `json:"priority,omitempty"`
+
+	// Required holds back the nodes that join the cluster with the startup
+	// taint configured in the config-warmimage ConfigMap, if any, until the
+	// images are warm on them: the controller only removes the taint from a
+	// node once the images of every Required WarmImage and ClusterWarmImage
+	// that targets it are warm there.
+	// +optional
+	Required bool `json:"required,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ClusterWarmImageList is a list of ClusterWarmImage resources
+type ClusterWarmImageList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []ClusterWarmImage `json:"items"`
+}
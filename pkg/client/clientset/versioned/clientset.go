@@ -20,6 +20,7 @@ package versioned
 
 import (
 	mattmoorv2 "github.com/mattmoor/warm-image/pkg/client/clientset/versioned/typed/warmimage/v2"
+	mattmoorv3 "github.com/mattmoor/warm-image/pkg/client/clientset/versioned/typed/warmimage/v3"
 	discovery "k8s.io/client-go/discovery"
 	rest "k8s.io/client-go/rest"
 	flowcontrol "k8s.io/client-go/util/flowcontrol"
@@ -28,8 +29,9 @@ import (
 type Interface interface {
 	Discovery() discovery.DiscoveryInterface
 	MattmoorV2() mattmoorv2.MattmoorV2Interface
+	MattmoorV3() mattmoorv3.MattmoorV3Interface
 	// Deprecated: please explicitly pick a version if possible.
-	Mattmoor() mattmoorv2.MattmoorV2Interface
+	Mattmoor() mattmoorv3.MattmoorV3Interface
 }
 
 // Clientset contains the clients for groups. Each group has exactly one
@@ -37,6 +39,7 @@ type Interface interface {
 type Clientset struct {
 	*discovery.DiscoveryClient
 	mattmoorV2 *mattmoorv2.MattmoorV2Client
+	mattmoorV3 *mattmoorv3.MattmoorV3Client
 }
 
 // MattmoorV2 retrieves the MattmoorV2Client
@@ -44,10 +47,15 @@ func (c *Clientset) MattmoorV2() mattmoorv2.MattmoorV2Interface {
 	return c.mattmoorV2
 }
 
+// MattmoorV3 retrieves the MattmoorV3Client
+func (c *Clientset) MattmoorV3() mattmoorv3.MattmoorV3Interface {
+	return c.mattmoorV3
+}
+
 // Deprecated: Mattmoor retrieves the default version of MattmoorClient.
 // Please explicitly pick a version.
-func (c *Clientset) Mattmoor() mattmoorv2.MattmoorV2Interface {
-	return c.mattmoorV2
+func (c *Clientset) Mattmoor() mattmoorv3.MattmoorV3Interface {
+	return c.mattmoorV3
 }
 
 // Discovery retrieves the DiscoveryClient
@@ -70,6 +78,10 @@ func NewForConfig(c *rest.Config) (*Clientset, error) {
 	if err != nil {
 		return nil, err
 	}
+	cs.mattmoorV3, err = mattmoorv3.NewForConfig(&configShallowCopy)
+	if err != nil {
+		return nil, err
+	}
 
 	cs.DiscoveryClient, err = discovery.NewDiscoveryClientForConfig(&configShallowCopy)
 	if err != nil {
@@ -83,6 +95,7 @@ func NewForConfig(c *rest.Config) (*Clientset, error) {
 func NewForConfigOrDie(c *rest.Config) *Clientset {
 	var cs Clientset
 	cs.mattmoorV2 = mattmoorv2.NewForConfigOrDie(c)
+	cs.mattmoorV3 = mattmoorv3.NewForConfigOrDie(c)
 
 	cs.DiscoveryClient = discovery.NewDiscoveryClientForConfigOrDie(c)
 	return &cs
@@ -92,6 +105,7 @@ func NewForConfigOrDie(c *rest.Config) *Clientset {
 func New(c rest.Interface) *Clientset {
 	var cs Clientset
 	cs.mattmoorV2 = mattmoorv2.New(c)
+	cs.mattmoorV3 = mattmoorv3.New(c)
 
 	cs.DiscoveryClient = discovery.NewDiscoveryClient(c)
 	return &cs
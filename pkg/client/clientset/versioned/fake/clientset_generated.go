@@ -22,6 +22,8 @@ import (
 	clientset "github.com/mattmoor/warm-image/pkg/client/clientset/versioned"
 	mattmoorv2 "github.com/mattmoor/warm-image/pkg/client/clientset/versioned/typed/warmimage/v2"
 	fakemattmoorv2 "github.com/mattmoor/warm-image/pkg/client/clientset/versioned/typed/warmimage/v2/fake"
+	mattmoorv3 "github.com/mattmoor/warm-image/pkg/client/clientset/versioned/typed/warmimage/v3"
+	fakemattmoorv3 "github.com/mattmoor/warm-image/pkg/client/clientset/versioned/typed/warmimage/v3/fake"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/discovery"
@@ -76,7 +78,12 @@ func (c *Clientset) MattmoorV2() mattmoorv2.MattmoorV2Interface {
 	return &fakemattmoorv2.FakeMattmoorV2{Fake: &c.Fake}
 }
 
-// Mattmoor retrieves the MattmoorV2Client
-func (c *Clientset) Mattmoor() mattmoorv2.MattmoorV2Interface {
-	return &fakemattmoorv2.FakeMattmoorV2{Fake: &c.Fake}
+// MattmoorV3 retrieves the MattmoorV3Client
+func (c *Clientset) MattmoorV3() mattmoorv3.MattmoorV3Interface {
+	return &fakemattmoorv3.FakeMattmoorV3{Fake: &c.Fake}
+}
+
+// Mattmoor retrieves the MattmoorV3Client
+func (c *Clientset) Mattmoor() mattmoorv3.MattmoorV3Interface {
+	return &fakemattmoorv3.FakeMattmoorV3{Fake: &c.Fake}
 }
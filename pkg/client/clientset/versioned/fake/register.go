@@ -20,6 +20,7 @@ package fake
 
 import (
 	mattmoorv2 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v2"
+	mattmoorv3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 	schema "k8s.io/apimachinery/pkg/runtime/schema"
@@ -51,4 +52,5 @@ func init() {
 // correctly.
 func AddToScheme(scheme *runtime.Scheme) {
 	mattmoorv2.AddToScheme(scheme)
+	mattmoorv3.AddToScheme(scheme)
 }
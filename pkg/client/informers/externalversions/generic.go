@@ -22,6 +22,7 @@ import (
 	"fmt"
 
 	v2 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v2"
+	v3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
 	schema "k8s.io/apimachinery/pkg/runtime/schema"
 	cache "k8s.io/client-go/tools/cache"
 )
@@ -56,6 +57,12 @@ func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource
 	case v2.SchemeGroupVersion.WithResource("warmimages"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Mattmoor().V2().WarmImages().Informer()}, nil
 
+		// Group=mattmoor.io, Version=v3
+	case v3.SchemeGroupVersion.WithResource("clusterwarmimages"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Mattmoor().V3().ClusterWarmImages().Informer()}, nil
+	case v3.SchemeGroupVersion.WithResource("warmimages"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Mattmoor().V3().WarmImages().Informer()}, nil
+
 	}
 
 	return nil, fmt.Errorf("no informer found for %v", resource)
@@ -21,12 +21,15 @@ package mattmoor
 import (
 	internalinterfaces "github.com/mattmoor/warm-image/pkg/client/informers/externalversions/internalinterfaces"
 	v2 "github.com/mattmoor/warm-image/pkg/client/informers/externalversions/warmimage/v2"
+	v3 "github.com/mattmoor/warm-image/pkg/client/informers/externalversions/warmimage/v3"
 )
 
 // Interface provides access to each of this group's versions.
 type Interface interface {
 	// V2 provides access to shared informers for resources in V2.
 	V2() v2.Interface
+	// V3 provides access to shared informers for resources in V3.
+	V3() v3.Interface
 }
 
 type group struct {
@@ -44,3 +47,8 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 func (g *group) V2() v2.Interface {
 	return v2.New(g.factory, g.namespace, g.tweakListOptions)
 }
+
+// V3 returns a new v3.Interface.
+func (g *group) V3() v3.Interface {
+	return v3.New(g.factory, g.namespace, g.tweakListOptions)
+}
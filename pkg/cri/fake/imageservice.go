@@ -0,0 +1,213 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fake serves an in-memory CRI image service over gRPC, with which to
+// test talking to a container runtime without one.
+package fake
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	runtimeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// ImageService is an in-memory CRI image service, served in-process on a
+// unix socket.
+type ImageService struct {
+	mu sync.Mutex
+	// images holds the images that the runtime has, by ID.
+	images map[string]*runtimeapi.Image
+	// pullErrors holds the errors with which pulling the images fails.
+	pullErrors map[string]error
+	// pulls holds the requests to pull images, in order.
+	pulls []*runtimeapi.PullImageRequest
+
+	dir    string
+	server *grpc.Server
+}
+
+// Check that we implement the CRI image service.
+var _ runtimeapi.ImageServiceServer = (*ImageService)(nil)
+
+// NewImageService starts serving an image service without any images on a
+// unix socket in a new temporary directory.
+func NewImageService() (*ImageService, error) {
+	dir, err := ioutil.TempDir("", "fake-cri")
+	if err != nil {
+		return nil, err
+	}
+	lis, err := net.Listen("unix", filepath.Join(dir, "cri.sock"))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	f := &ImageService{
+		images:     make(map[string]*runtimeapi.Image),
+		pullErrors: make(map[string]error),
+		dir:        dir,
+		server:     grpc.NewServer(),
+	}
+	runtimeapi.RegisterImageServiceServer(f.server, f)
+	go f.server.Serve(lis)
+	return f, nil
+}
+
+// Endpoint returns the endpoint on which the image service listens, which
+// cri.NewImageService connects to.
+func (f *ImageService) Endpoint() string {
+	return "unix://" + filepath.Join(f.dir, "cri.sock")
+}
+
+// Stop stops serving the image service and removes its socket.
+func (f *ImageService) Stop() {
+	f.server.Stop()
+	os.RemoveAll(f.dir)
+}
+
+// AddImage makes the runtime have the image, as though it was pulled.
+func (f *ImageService) AddImage(image string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.addImage(image)
+}
+
+// HasImage returns whether the runtime has the image.
+func (f *ImageService) HasImage(image string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.findImage(image) != nil
+}
+
+// SetPullError makes pulling the image fail with the given error, or succeed
+// again when it is nil.
+func (f *ImageService) SetPullError(image string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.pullErrors, image)
+	} else {
+		f.pullErrors[image] = err
+	}
+}
+
+// Pulls returns the requests to pull images, including those that failed, in
+// the order in which they were made.
+func (f *ImageService) Pulls() []*runtimeapi.PullImageRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*runtimeapi.PullImageRequest{}, f.pulls...)
+}
+
+// ListImages implements runtimeapi.ImageServiceServer
+func (f *ImageService) ListImages(ctx context.Context, req *runtimeapi.ListImagesRequest) (*runtimeapi.ListImagesResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp := &runtimeapi.ListImagesResponse{}
+	if image := req.GetFilter().GetImage().GetImage(); image != "" {
+		if img := f.findImage(image); img != nil {
+			resp.Images = append(resp.Images, img)
+		}
+		return resp, nil
+	}
+	for _, img := range f.images {
+		resp.Images = append(resp.Images, img)
+	}
+	return resp, nil
+}
+
+// ImageStatus implements runtimeapi.ImageServiceServer
+func (f *ImageService) ImageStatus(ctx context.Context, req *runtimeapi.ImageStatusRequest) (*runtimeapi.ImageStatusResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &runtimeapi.ImageStatusResponse{
+		Image: f.findImage(req.GetImage().GetImage()),
+	}, nil
+}
+
+// PullImage implements runtimeapi.ImageServiceServer
+func (f *ImageService) PullImage(ctx context.Context, req *runtimeapi.PullImageRequest) (*runtimeapi.PullImageResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	image := req.GetImage().GetImage()
+	f.pulls = append(f.pulls, req)
+	if err := f.pullErrors[image]; err != nil {
+		return nil, err
+	}
+	return &runtimeapi.PullImageResponse{
+		ImageRef: f.addImage(image).Id,
+	}, nil
+}
+
+// RemoveImage implements runtimeapi.ImageServiceServer
+func (f *ImageService) RemoveImage(ctx context.Context, req *runtimeapi.RemoveImageRequest) (*runtimeapi.RemoveImageResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if img := f.findImage(req.GetImage().GetImage()); img != nil {
+		delete(f.images, img.Id)
+	}
+	return &runtimeapi.RemoveImageResponse{}, nil
+}
+
+// ImageFsInfo implements runtimeapi.ImageServiceServer
+func (f *ImageService) ImageFsInfo(ctx context.Context, req *runtimeapi.ImageFsInfoRequest) (*runtimeapi.ImageFsInfoResponse, error) {
+	return &runtimeapi.ImageFsInfoResponse{}, nil
+}
+
+// addImage adds the image, identified by a hash of its reference, and returns
+// it. The caller must hold mu.
+func (f *ImageService) addImage(image string) *runtimeapi.Image {
+	if img := f.findImage(image); img != nil {
+		return img
+	}
+	sum := sha256.Sum256([]byte(image))
+	img := &runtimeapi.Image{
+		Id: "sha256:" + hex.EncodeToString(sum[:]),
+	}
+	if strings.Contains(image, "@") {
+		img.RepoDigests = []string{image}
+	} else {
+		img.RepoTags = []string{image}
+	}
+	f.images[img.Id] = img
+	return img
+}
+
+// findImage returns the image with the given reference or ID, if any. The
+// caller must hold mu.
+func (f *ImageService) findImage(image string) *runtimeapi.Image {
+	if img, ok := f.images[image]; ok {
+		return img
+	}
+	for _, img := range f.images {
+		for _, refs := range [][]string{img.RepoTags, img.RepoDigests} {
+			for _, ref := range refs {
+				if ref == image {
+					return img
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cri talks to the image service of a container runtime through the
+// Container Runtime Interface, the way that the kubelet does.
+package cri
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	runtimeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+
+	"github.com/mattmoor/warm-image/pkg/registry"
+)
+
+// maxMsgSize is the largest response we accept, like the kubelet does, since
+// listing the images of a busy node can take more than gRPC's default.
+const maxMsgSize = 16 * 1024 * 1024
+
+// ImageService pulls, inspects and removes the images of a container runtime.
+type ImageService struct {
+	conn   *grpc.ClientConn
+	client runtimeapi.ImageServiceClient
+
+	// timeout bounds the calls other than pulling images, which take as
+	// long as they take.
+	timeout time.Duration
+}
+
+// NewImageService connects to the CRI image service listening on the given
+// unix socket, e.g. unix:///var/run/dockershim.sock, giving up on connecting
+// and on each call other than PullImage after the given timeout.
+func NewImageService(endpoint string, timeout time.Duration) (*ImageService, error) {
+	addr := strings.TrimPrefix(endpoint, "unix://")
+	conn, err := grpc.Dial(addr,
+		grpc.WithInsecure(),
+		grpc.WithBlock(),
+		grpc.WithTimeout(timeout),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("unix", addr, timeout)
+		}),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxMsgSize)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %q: %v", endpoint, err)
+	}
+	return &ImageService{
+		conn:    conn,
+		client:  runtimeapi.NewImageServiceClient(conn),
+		timeout: timeout,
+	}, nil
+}
+
+// Close closes the connection to the image service.
+func (is *ImageService) Close() error {
+	return is.conn.Close()
+}
+
+// PullImage pulls the image with the given credential, if any, and returns
+// the reference of the image that the runtime pulled.
+func (is *ImageService) PullImage(ctx context.Context, image string, cred *registry.Credential) (string, error) {
+	req := &runtimeapi.PullImageRequest{
+		Image: &runtimeapi.ImageSpec{Image: image},
+	}
+	if cred != nil {
+		req.Auth = &runtimeapi.AuthConfig{
+			Username: cred.Username,
+			Password: cred.Password,
+		}
+	}
+	resp, err := is.client.PullImage(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	return resp.ImageRef, nil
+}
+
+// ImageStatus returns the image, or nil if the runtime doesn't have it.
+func (is *ImageService) ImageStatus(ctx context.Context, image string) (*runtimeapi.Image, error) {
+	ctx, cancel := context.WithTimeout(ctx, is.timeout)
+	defer cancel()
+	resp, err := is.client.ImageStatus(ctx, &runtimeapi.ImageStatusRequest{
+		Image: &runtimeapi.ImageSpec{Image: image},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Image, nil
+}
+
+// ListImages returns all of the images that the runtime has.
+func (is *ImageService) ListImages(ctx context.Context) ([]*runtimeapi.Image, error) {
+	ctx, cancel := context.WithTimeout(ctx, is.timeout)
+	defer cancel()
+	resp, err := is.client.ListImages(ctx, &runtimeapi.ListImagesRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Images, nil
+}
+
+// RemoveImage removes the image, if the runtime has it.
+func (is *ImageService) RemoveImage(ctx context.Context, image string) error {
+	ctx, cancel := context.WithTimeout(ctx, is.timeout)
+	defer cancel()
+	_, err := is.client.RemoveImage(ctx, &runtimeapi.RemoveImageRequest{
+		Image: &runtimeapi.ImageSpec{Image: image},
+	})
+	return err
+}
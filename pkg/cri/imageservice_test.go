@@ -0,0 +1,150 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cri
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattmoor/warm-image/pkg/cri/fake"
+	"github.com/mattmoor/warm-image/pkg/registry"
+)
+
+// newImageService connects to a new fake image service, which the test must
+// stop.
+func newImageService(t *testing.T) (*ImageService, *fake.ImageService) {
+	t.Helper()
+	f, err := fake.NewImageService()
+	if err != nil {
+		t.Fatalf("fake.NewImageService() = %v", err)
+	}
+	is, err := NewImageService(f.Endpoint(), 10*time.Second)
+	if err != nil {
+		f.Stop()
+		t.Fatalf("NewImageService() = %v", err)
+	}
+	return is, f
+}
+
+func TestPullImage(t *testing.T) {
+	is, f := newImageService(t)
+	defer f.Stop()
+	defer is.Close()
+	ctx := context.Background()
+
+	const image = "gcr.io/foo/bar@sha256:deadbeef"
+	ref, err := is.PullImage(ctx, image, &registry.Credential{Username: "user", Password: "hunter2"})
+	if err != nil {
+		t.Fatalf("PullImage() = %v", err)
+	}
+	if !strings.HasPrefix(ref, "sha256:") {
+		t.Errorf("PullImage() = %q, wanted an image ID", ref)
+	}
+	if !f.HasImage(image) {
+		t.Errorf("HasImage(%q) = false, wanted true", image)
+	}
+	pulls := f.Pulls()
+	if len(pulls) != 1 {
+		t.Fatalf("len(Pulls()) = %d, wanted 1", len(pulls))
+	}
+	if auth := pulls[0].GetAuth(); auth.GetUsername() != "user" || auth.GetPassword() != "hunter2" {
+		t.Errorf("Pulls()[0].Auth = %v, wanted user/hunter2", auth)
+	}
+
+	// Anonymous pulls send no credentials.
+	if _, err := is.PullImage(ctx, "gcr.io/foo/baz:latest", nil); err != nil {
+		t.Fatalf("PullImage() = %v", err)
+	}
+	if auth := f.Pulls()[1].GetAuth(); auth != nil {
+		t.Errorf("Pulls()[1].Auth = %v, wanted nil", auth)
+	}
+}
+
+func TestPullImageError(t *testing.T) {
+	is, f := newImageService(t)
+	defer f.Stop()
+	defer is.Close()
+
+	const image = "gcr.io/foo/bar:latest"
+	f.SetPullError(image, errors.New("manifest unknown"))
+	if _, err := is.PullImage(context.Background(), image, nil); err == nil || !strings.Contains(err.Error(), "manifest unknown") {
+		t.Errorf("PullImage() = %v, wanted manifest unknown", err)
+	}
+	if f.HasImage(image) {
+		t.Errorf("HasImage(%q) = true, wanted false", image)
+	}
+}
+
+func TestImageStatus(t *testing.T) {
+	is, f := newImageService(t)
+	defer f.Stop()
+	defer is.Close()
+	ctx := context.Background()
+
+	const image = "gcr.io/foo/bar:latest"
+	if img, err := is.ImageStatus(ctx, image); err != nil {
+		t.Fatalf("ImageStatus() = %v", err)
+	} else if img != nil {
+		t.Errorf("ImageStatus() = %v, wanted nil", img)
+	}
+
+	f.AddImage(image)
+	img, err := is.ImageStatus(ctx, image)
+	if err != nil {
+		t.Fatalf("ImageStatus() = %v", err)
+	} else if img == nil {
+		t.Fatal("ImageStatus() = nil, wanted the image")
+	}
+	if got := img.GetRepoTags(); len(got) != 1 || got[0] != image {
+		t.Errorf("ImageStatus().RepoTags = %v, wanted [%s]", got, image)
+	}
+}
+
+func TestListAndRemoveImages(t *testing.T) {
+	is, f := newImageService(t)
+	defer f.Stop()
+	defer is.Close()
+	ctx := context.Background()
+
+	images := []string{"gcr.io/foo/bar:latest", "gcr.io/foo/baz@sha256:deadbeef"}
+	for _, image := range images {
+		f.AddImage(image)
+	}
+	got, err := is.ListImages(ctx)
+	if err != nil {
+		t.Fatalf("ListImages() = %v", err)
+	} else if len(got) != len(images) {
+		t.Errorf("len(ListImages()) = %d, wanted %d", len(got), len(images))
+	}
+
+	if err := is.RemoveImage(ctx, images[0]); err != nil {
+		t.Fatalf("RemoveImage() = %v", err)
+	}
+	if f.HasImage(images[0]) {
+		t.Errorf("HasImage(%q) = true after RemoveImage()", images[0])
+	}
+	if !f.HasImage(images[1]) {
+		t.Errorf("HasImage(%q) = false, wanted true", images[1])
+	}
+	// Removing an image that the runtime doesn't have succeeds.
+	if err := is.RemoveImage(ctx, images[0]); err != nil {
+		t.Errorf("RemoveImage() = %v, wanted nil", err)
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics holds the Prometheus metrics that the controller exports.
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/knative/pkg/controller"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/util/workqueue"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+	listers "github.com/mattmoor/warm-image/pkg/client/listers/warmimage/v3"
+)
+
+// namespace prefixes the names of all of our metrics.
+const namespace = "warmimage"
+
+const (
+	// OutcomeSuccess labels reconciles that returned no error.
+	OutcomeSuccess = "success"
+	// OutcomeError labels reconciles that returned an error.
+	OutcomeError = "error"
+)
+
+// Metrics records what the controllers do. A nil *Metrics records nothing.
+type Metrics struct {
+	registerer prometheus.Registerer
+
+	reconcileCount   *prometheus.CounterVec
+	reconcileLatency *prometheus.HistogramVec
+	timeToWarm       *prometheus.HistogramVec
+	nodeTimeToWarm   *prometheus.HistogramVec
+}
+
+// New creates the controller's metrics and registers them with the given
+// Registerer. Tests pass their own prometheus.NewRegistry(), so that they can
+// Gather it to assert the values of the metrics.
+func New(registerer prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		registerer: registerer,
+		reconcileCount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "reconcile_count",
+			Help:      "The number of reconciles by each controller, by outcome.",
+		}, []string{"controller", "outcome"}),
+		reconcileLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "reconcile_latency_seconds",
+			Help:      "How long reconciles by each controller took, by outcome.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"controller", "outcome"}),
+		timeToWarm: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "time_to_warm_seconds",
+			Help:      "How long images took to become warm on all of their nodes after a change, e.g. to the spec, left them cold on some.",
+			// From 5s up to about 6h.
+			Buckets: prometheus.ExponentialBuckets(5, 2, 13),
+		}, []string{"kind"}),
+		nodeTimeToWarm: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "node_time_to_warm_seconds",
+			Help:      "How long images took to become warm on the nodes that joined the cluster, from when they joined, by priority.",
+			// From 1s up to about 2h.
+			Buckets: prometheus.ExponentialBuckets(1, 2, 14),
+		}, []string{"kind", "priority"}),
+	}
+	for _, c := range []prometheus.Collector{m.reconcileCount, m.reconcileLatency, m.timeToWarm, m.nodeTimeToWarm} {
+		if err := registerer.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Reconciler wraps the given controller's Reconciler to record the outcome
+// and latency of each reconcile.
+func (m *Metrics) Reconciler(name string, r controller.Reconciler) controller.Reconciler {
+	if m == nil {
+		return r
+	}
+	return &reconciler{metrics: m, name: name, inner: r}
+}
+
+type reconciler struct {
+	metrics *Metrics
+	name    string
+	inner   controller.Reconciler
+}
+
+// Reconcile implements controller.Reconciler
+func (r *reconciler) Reconcile(ctx context.Context, key string) error {
+	start := time.Now()
+	err := r.inner.Reconcile(ctx, key)
+	outcome := OutcomeSuccess
+	if err != nil {
+		outcome = OutcomeError
+	}
+	r.metrics.reconcileCount.WithLabelValues(r.name, outcome).Inc()
+	r.metrics.reconcileLatency.WithLabelValues(r.name, outcome).Observe(time.Since(start).Seconds())
+	return err
+}
+
+// ObserveTimeToWarm records how long an image of the given kind took to
+// become warm on all of its nodes.
+func (m *Metrics) ObserveTimeToWarm(kind string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.timeToWarm.WithLabelValues(kind).Observe(d.Seconds())
+}
+
+// ObserveNodeTimeToWarm records how long the images of the given kind and
+// priority took to become warm on a node that joined the cluster.
+func (m *Metrics) ObserveNodeTimeToWarm(kind, priority string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.nodeTimeToWarm.WithLabelValues(kind, priority).Observe(d.Seconds())
+}
+
+// RegisterWorkQueue exports the depth of the given controller's work queue.
+func (m *Metrics) RegisterWorkQueue(name string, queue workqueue.Interface) error {
+	if m == nil {
+		return nil
+	}
+	return m.registerer.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "workqueue_depth",
+		Help:        "The number of keys waiting in the work queue of each controller.",
+		ConstLabels: prometheus.Labels{"controller": name},
+	}, func() float64 {
+		return float64(queue.Len())
+	}))
+}
+
+// RegisterWarmImages exports the number of WarmImages and ClusterWarmImages,
+// and how many nodes each of them should be and is warm on.
+func (m *Metrics) RegisterWarmImages(warmimagesLister listers.WarmImageLister, clusterwarmimagesLister listers.ClusterWarmImageLister) error {
+	if m == nil {
+		return nil
+	}
+	return m.registerer.Register(&coverageCollector{
+		warmimagesLister:        warmimagesLister,
+		clusterwarmimagesLister: clusterwarmimagesLister,
+	})
+}
+
+var (
+	countDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "count"),
+		"The number of WarmImages and ClusterWarmImages, by kind.",
+		[]string{"kind"}, nil)
+	desiredNodesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "desired_nodes"),
+		"The number of nodes on which the images of each WarmImage and ClusterWarmImage should be warm.",
+		[]string{"kind", "namespace", "name"}, nil)
+	readyNodesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "ready_nodes"),
+		"The number of nodes on which the images of each WarmImage and ClusterWarmImage are warm.",
+		[]string{"kind", "namespace", "name"}, nil)
+)
+
+// coverageCollector reads the status of the WarmImages and ClusterWarmImages
+// from the informers' caches when the metrics are scraped, so that deleted
+// objects don't linger.
+type coverageCollector struct {
+	warmimagesLister        listers.WarmImageLister
+	clusterwarmimagesLister listers.ClusterWarmImageLister
+}
+
+// Describe implements prometheus.Collector
+func (c *coverageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- countDesc
+	ch <- desiredNodesDesc
+	ch <- readyNodesDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *coverageCollector) Collect(ch chan<- prometheus.Metric) {
+	if wis, err := c.warmimagesLister.List(labels.Everything()); err != nil {
+		ch <- prometheus.NewInvalidMetric(countDesc, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(countDesc, prometheus.GaugeValue, float64(len(wis)), "WarmImage")
+		for _, wi := range wis {
+			collectStatus(ch, "WarmImage", wi.Namespace, wi.Name, &wi.Status)
+		}
+	}
+	if cwis, err := c.clusterwarmimagesLister.List(labels.Everything()); err != nil {
+		ch <- prometheus.NewInvalidMetric(countDesc, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(countDesc, prometheus.GaugeValue, float64(len(cwis)), "ClusterWarmImage")
+		for _, cwi := range cwis {
+			collectStatus(ch, "ClusterWarmImage", "", cwi.Name, &cwi.Status)
+		}
+	}
+}
+
+func collectStatus(ch chan<- prometheus.Metric, kind, ns, name string, status *warmimagev3.WarmImageStatus) {
+	ch <- prometheus.MustNewConstMetric(desiredNodesDesc, prometheus.GaugeValue, float64(status.DesiredNodes), kind, ns, name)
+	ch <- prometheus.MustNewConstMetric(readyNodesDesc, prometheus.GaugeValue, float64(status.ReadyNodes), kind, ns, name)
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+	listers "github.com/mattmoor/warm-image/pkg/client/listers/warmimage/v3"
+)
+
+// fakeReconciler returns the error for each key, if any.
+type fakeReconciler map[string]error
+
+// Reconcile implements controller.Reconciler
+func (r fakeReconciler) Reconcile(ctx context.Context, key string) error {
+	return r[key]
+}
+
+// gather returns the metrics in the registry, keyed by their name and then
+// by their labels, e.g. {controller="warmimage",outcome="success"}.
+func gather(t *testing.T, registry *prometheus.Registry) map[string]map[string]*dto.Metric {
+	t.Helper()
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() = %v", err)
+	}
+	got := make(map[string]map[string]*dto.Metric, len(mfs))
+	for _, mf := range mfs {
+		metrics := make(map[string]*dto.Metric, len(mf.GetMetric()))
+		for _, m := range mf.GetMetric() {
+			metrics[labelString(m)] = m
+		}
+		got[mf.GetName()] = metrics
+	}
+	return got
+}
+
+func labelString(m *dto.Metric) string {
+	s := "{"
+	for i, lp := range m.GetLabel() {
+		if i > 0 {
+			s += ","
+		}
+		s += fmt.Sprintf("%s=%q", lp.GetName(), lp.GetValue())
+	}
+	return s + "}"
+}
+
+func TestReconciler(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := New(registry)
+	if err != nil {
+		t.Fatalf("New() = %v", err)
+	}
+	wantErr := errors.New("boom")
+	r := m.Reconciler("warmimage", fakeReconciler{"default/bad": wantErr})
+
+	for _, key := range []string{"default/good", "default/good", "default/bad"} {
+		want := fakeReconciler{"default/bad": wantErr}[key]
+		if err := r.Reconcile(context.Background(), key); err != want {
+			t.Errorf("Reconcile(%q) = %v, wanted %v", key, err, want)
+		}
+	}
+
+	got := gather(t, registry)
+	for labels, want := range map[string]uint64{
+		`{controller="warmimage",outcome="success"}`: 2,
+		`{controller="warmimage",outcome="error"}`:   1,
+	} {
+		count, ok := got["warmimage_reconcile_count"][labels]
+		if !ok {
+			t.Errorf("warmimage_reconcile_count%s is missing", labels)
+		} else if v := count.GetCounter().GetValue(); v != float64(want) {
+			t.Errorf("warmimage_reconcile_count%s = %v, wanted %v", labels, v, want)
+		}
+		latency, ok := got["warmimage_reconcile_latency_seconds"][labels]
+		if !ok {
+			t.Errorf("warmimage_reconcile_latency_seconds%s is missing", labels)
+		} else if n := latency.GetHistogram().GetSampleCount(); n != want {
+			t.Errorf("warmimage_reconcile_latency_seconds%s has %d samples, wanted %d", labels, n, want)
+		}
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	inner := fakeReconciler{}
+	if r := m.Reconciler("warmimage", inner); r == nil {
+		t.Error("Reconciler() = nil, wanted the inner Reconciler")
+	}
+	// These must not panic.
+	m.ObserveTimeToWarm("WarmImage", 0)
+	m.ObserveNodeTimeToWarm("WarmImage", "Normal", 0)
+	if err := m.RegisterWorkQueue("warmimage", nil); err != nil {
+		t.Errorf("RegisterWorkQueue() = %v", err)
+	}
+	if err := m.RegisterWarmImages(nil, nil); err != nil {
+		t.Errorf("RegisterWarmImages() = %v", err)
+	}
+}
+
+func TestCoverageCollector(t *testing.T) {
+	wis := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, wi := range []*warmimagev3.WarmImage{{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "foo"},
+		Status:     warmimagev3.WarmImageStatus{DesiredNodes: 3, ReadyNodes: 2},
+	}, {
+		ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "bar"},
+		Status:     warmimagev3.WarmImageStatus{DesiredNodes: 1, ReadyNodes: 1},
+	}} {
+		if err := wis.Add(wi); err != nil {
+			t.Fatalf("Add() = %v", err)
+		}
+	}
+	cwis := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	if err := cwis.Add(&warmimagev3.ClusterWarmImage{
+		ObjectMeta: metav1.ObjectMeta{Name: "baz"},
+		Status:     warmimagev3.WarmImageStatus{DesiredNodes: 5, ReadyNodes: 4},
+	}); err != nil {
+		t.Fatalf("Add() = %v", err)
+	}
+
+	registry := prometheus.NewRegistry()
+	m, err := New(registry)
+	if err != nil {
+		t.Fatalf("New() = %v", err)
+	}
+	if err := m.RegisterWarmImages(listers.NewWarmImageLister(wis), listers.NewClusterWarmImageLister(cwis)); err != nil {
+		t.Fatalf("RegisterWarmImages() = %v", err)
+	}
+
+	got := gather(t, registry)
+	for _, test := range []struct {
+		name   string
+		labels string
+		want   float64
+	}{
+		{"warmimage_count", `{kind="WarmImage"}`, 2},
+		{"warmimage_count", `{kind="ClusterWarmImage"}`, 1},
+		{"warmimage_desired_nodes", `{kind="WarmImage",name="foo",namespace="default"}`, 3},
+		{"warmimage_ready_nodes", `{kind="WarmImage",name="foo",namespace="default"}`, 2},
+		{"warmimage_desired_nodes", `{kind="WarmImage",name="bar",namespace="other"}`, 1},
+		{"warmimage_ready_nodes", `{kind="WarmImage",name="bar",namespace="other"}`, 1},
+		{"warmimage_desired_nodes", `{kind="ClusterWarmImage",name="baz",namespace=""}`, 5},
+		{"warmimage_ready_nodes", `{kind="ClusterWarmImage",name="baz",namespace=""}`, 4},
+	} {
+		metric, ok := got[test.name][test.labels]
+		if !ok {
+			t.Errorf("%s%s is missing, got %v", test.name, test.labels, got[test.name])
+		} else if v := metric.GetGauge().GetValue(); v != test.want {
+			t.Errorf("%s%s = %v, wanted %v", test.name, test.labels, v, test.want)
+		}
+	}
+	if n := len(got["warmimage_desired_nodes"]); n != 3 {
+		t.Errorf("len(warmimage_desired_nodes) = %d, wanted 3", n)
+	}
+}
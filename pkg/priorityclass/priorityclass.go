@@ -0,0 +1,110 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package priorityclass is a client for the PriorityClasses of
+// scheduling.k8s.io/v1, which our vendored client-go predates. The API server
+// stopped serving scheduling.k8s.io/v1beta1 in Kubernetes 1.22, but its schema
+// is the same, so we reuse its types.
+package priorityclass
+
+import (
+	"time"
+
+	schedulingv1beta1 "k8s.io/api/scheduling/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	schedulingv1beta1listers "k8s.io/client-go/listers/scheduling/v1beta1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// SchemeGroupVersion is the group version that we talk to.
+var SchemeGroupVersion = schema.GroupVersion{Group: "scheduling.k8s.io", Version: "v1"}
+
+const resource = "priorityclasses"
+
+// Interface creates and watches PriorityClasses.
+type Interface interface {
+	Create(*schedulingv1beta1.PriorityClass) (*schedulingv1beta1.PriorityClass, error)
+	cache.ListerWatcher
+}
+
+type client struct {
+	rest.Interface
+	*cache.ListWatch
+}
+
+// NewForConfig returns a client of the PriorityClasses of the API server of
+// the given config.
+func NewForConfig(c *rest.Config) (Interface, error) {
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypes(SchemeGroupVersion, &schedulingv1beta1.PriorityClass{}, &schedulingv1beta1.PriorityClassList{})
+	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
+
+	config := *c
+	config.GroupVersion = &SchemeGroupVersion
+	config.APIPath = "/apis"
+	config.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: serializer.NewCodecFactory(scheme)}
+	if config.UserAgent == "" {
+		config.UserAgent = rest.DefaultKubernetesUserAgent()
+	}
+	rc, err := rest.RESTClientFor(&config)
+	if err != nil {
+		return nil, err
+	}
+	return &client{
+		Interface: rc,
+		ListWatch: cache.NewListWatchFromClient(rc, resource, metav1.NamespaceAll, fields.Everything()),
+	}, nil
+}
+
+// Create creates the PriorityClass, and returns what the API server made of
+// it.
+func (c *client) Create(pc *schedulingv1beta1.PriorityClass) (*schedulingv1beta1.PriorityClass, error) {
+	result := &schedulingv1beta1.PriorityClass{}
+	err := c.Post().Resource(resource).Body(pc).Do().Into(result)
+	return result, err
+}
+
+// Informer is a shared informer of the PriorityClasses, and a lister of what
+// it has seen.
+type Informer interface {
+	Informer() cache.SharedIndexInformer
+	Lister() schedulingv1beta1listers.PriorityClassLister
+}
+
+type informer struct {
+	cache.SharedIndexInformer
+}
+
+// NewInformer returns an informer of the PriorityClasses that the client
+// watches, which resyncs on the given period.
+func NewInformer(c Interface, resyncPeriod time.Duration) Informer {
+	return &informer{
+		SharedIndexInformer: cache.NewSharedIndexInformer(c, &schedulingv1beta1.PriorityClass{}, resyncPeriod, cache.Indexers{}),
+	}
+}
+
+func (i *informer) Informer() cache.SharedIndexInformer {
+	return i.SharedIndexInformer
+}
+
+func (i *informer) Lister() schedulingv1beta1listers.PriorityClassLister {
+	return schedulingv1beta1listers.NewPriorityClassLister(i.GetIndexer())
+}
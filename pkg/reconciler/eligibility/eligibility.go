@@ -0,0 +1,158 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package eligibility labels the nodes that the images are not warmed onto,
+// such as cordoned nodes, so that the warm pods stay off them.
+package eligibility
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/knative/pkg/controller"
+	"github.com/knative/pkg/logging/logkey"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/config"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/resources"
+)
+
+const controllerAgentName = "eligibility-controller"
+
+// Reconciler implements controller.Reconciler for Nodes, which it labels
+// with resources.IneligibleLabel while they are ineligible.
+type Reconciler struct {
+	// kubeclientset is a standard kubernetes clientset
+	kubeclientset kubernetes.Interface
+
+	nodesLister corev1listers.NodeLister
+
+	// configStore holds the configuration, whose IneligibleNodes we apply.
+	configStore *config.Store
+
+	// Sugared logger is easier to use but is not as performant as the
+	// raw logger. In performance critical paths, call logger.Desugar()
+	// and use the returned raw logger instead. In addition to the
+	// performance benefits, raw logger also preserves type-safety at
+	// the expense of slightly greater verbosity.
+	Logger *zap.SugaredLogger
+}
+
+// Check that we implement the controller.Reconciler interface.
+var _ controller.Reconciler = (*Reconciler)(nil)
+
+// NewController returns a new eligibility controller, which labels the nodes
+// that are ineligible according to the given Store's configuration.
+func NewController(
+	logger *zap.SugaredLogger,
+	kubeclientset kubernetes.Interface,
+	nodeInformer corev1informers.NodeInformer,
+	configStore *config.Store,
+) *controller.Impl {
+
+	// Enrich the logs with controller name
+	logger = logger.Named(controllerAgentName).With(zap.String(logkey.ControllerType, controllerAgentName))
+
+	r := &Reconciler{
+		kubeclientset: kubeclientset,
+		nodesLister:   nodeInformer.Lister(),
+		configStore:   configStore,
+		Logger:        logger,
+	}
+	impl := controller.NewImpl(r, logger, "Eligibility")
+
+	logger.Info("Setting up event handlers")
+	// Only enqueue the nodes whose label is out of date, since their status
+	// is updated often.
+	enqueueStale := func(obj interface{}) {
+		if node, ok := obj.(*corev1.Node); ok && r.isStale(node) {
+			impl.Enqueue(node)
+		}
+	}
+	nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueueStale,
+		UpdateFunc: controller.PassNew(enqueueStale),
+	})
+
+	// Revisit every node when our configuration changes.
+	configStore.OnChange(func(*config.Config) {
+		nodes, err := r.nodesLister.List(labels.Everything())
+		if err != nil {
+			logger.Errorw("Failed to list Nodes", zap.Error(err))
+			return
+		}
+		for _, node := range nodes {
+			enqueueStale(node)
+		}
+	})
+
+	return impl
+}
+
+// isStale returns whether the node's label doesn't match its eligibility.
+func (c *Reconciler) isStale(node *corev1.Node) bool {
+	return node.Labels[resources.IneligibleLabel] != resources.IneligibleReason(node, c.configStore.Get())
+}
+
+// Reconcile implements controller.Reconciler
+func (c *Reconciler) Reconcile(ctx context.Context, key string) error {
+	node, err := c.nodesLister.Get(key)
+	if errors.IsNotFound(err) {
+		runtime.HandleError(fmt.Errorf("node %q in work queue no longer exists", key))
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	reason := resources.IneligibleReason(node, c.configStore.Get())
+	if node.Labels[resources.IneligibleLabel] == reason {
+		return nil
+	}
+	// A JSON merge patch of the labels, in which null removes ours.
+	var value interface{}
+	if reason != "" {
+		value = reason
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]interface{}{
+				resources.IneligibleLabel: value,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := c.kubeclientset.CoreV1().Nodes().Patch(node.Name, types.MergePatchType, b); err != nil {
+		return err
+	}
+	if reason != "" {
+		c.Logger.Infof("Node %q is ineligible: %s", node.Name, reason)
+	} else {
+		c.Logger.Infof("Node %q is eligible again", node.Name)
+	}
+	return nil
+}
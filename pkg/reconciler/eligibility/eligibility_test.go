@@ -0,0 +1,250 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package eligibility
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/config"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/resources"
+)
+
+// fakeKubeClient records the node patches that the controller makes, which
+// is all of the API that it uses besides its informers.
+type fakeKubeClient struct {
+	kubernetes.Interface
+
+	// patches holds the merge patches by the name of the node.
+	patches map[string][]byte
+}
+
+func (c *fakeKubeClient) CoreV1() typedcorev1.CoreV1Interface {
+	return &fakeCoreV1{c: c}
+}
+
+type fakeCoreV1 struct {
+	typedcorev1.CoreV1Interface
+	c *fakeKubeClient
+}
+
+func (c *fakeCoreV1) Nodes() typedcorev1.NodeInterface {
+	return &fakeNodes{c: c.c}
+}
+
+type fakeNodes struct {
+	typedcorev1.NodeInterface
+	c *fakeKubeClient
+}
+
+func (n *fakeNodes) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (*corev1.Node, error) {
+	n.c.patches[name] = data
+	return &corev1.Node{}, nil
+}
+
+// patchedLabels returns the labels in the merge patch, in which nil removes
+// one.
+func patchedLabels(t *testing.T, patch []byte) map[string]*string {
+	t.Helper()
+	var p struct {
+		Metadata struct {
+			Labels map[string]*string `json:"labels"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		t.Fatalf("Unmarshal(%s) = %v", patch, err)
+	}
+	return p.Metadata.Labels
+}
+
+// makeNode returns the node with the given label, if any, and conditions.
+func makeNode(label string, unschedulable bool, conditions ...corev1.NodeCondition) *corev1.Node {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "the-node",
+		},
+		Spec: corev1.NodeSpec{
+			Unschedulable: unschedulable,
+		},
+		Status: corev1.NodeStatus{
+			Conditions: conditions,
+		},
+	}
+	if label != "" {
+		node.Labels = map[string]string{resources.IneligibleLabel: label}
+	}
+	return node
+}
+
+var (
+	ready = corev1.NodeCondition{
+		Type:   corev1.NodeReady,
+		Status: corev1.ConditionTrue,
+	}
+	notReady = corev1.NodeCondition{
+		Type:   corev1.NodeReady,
+		Status: corev1.ConditionFalse,
+	}
+	unknown = corev1.NodeCondition{
+		Type:   corev1.NodeReady,
+		Status: corev1.ConditionUnknown,
+	}
+	diskPressure = corev1.NodeCondition{
+		Type:   corev1.NodeDiskPressure,
+		Status: corev1.ConditionTrue,
+	}
+	noDiskPressure = corev1.NodeCondition{
+		Type:   corev1.NodeDiskPressure,
+		Status: corev1.ConditionFalse,
+	}
+)
+
+func TestReconcile(t *testing.T) {
+	reason := func(s string) *string { return &s }
+
+	tests := []struct {
+		name            string
+		node            *corev1.Node
+		ineligibleNodes []string
+		// wantPatch is whether we patch the node's label, with wantLabel,
+		// in which nil removes it.
+		wantPatch bool
+		wantLabel *string
+	}{{
+		name: "eligible",
+		node: makeNode("", false, ready),
+	}, {
+		name:      "cordoned",
+		node:      makeNode("", true, ready),
+		wantPatch: true,
+		wantLabel: reason(config.IneligibleUnschedulable),
+	}, {
+		name:      "not ready",
+		node:      makeNode("", false, notReady),
+		wantPatch: true,
+		wantLabel: reason(config.IneligibleNotReady),
+	}, {
+		name:      "unreachable",
+		node:      makeNode("", false, unknown),
+		wantPatch: true,
+		wantLabel: reason(config.IneligibleNotReady),
+	}, {
+		name:      "no conditions yet",
+		node:      makeNode("", false),
+		wantPatch: true,
+		wantLabel: reason(config.IneligibleNotReady),
+	}, {
+		name:      "cordoned takes precedence over not ready",
+		node:      makeNode("", true, notReady),
+		wantPatch: true,
+		wantLabel: reason(config.IneligibleUnschedulable),
+	}, {
+		name:      "disk pressure",
+		node:      makeNode("", false, ready, diskPressure),
+		wantPatch: true,
+		wantLabel: reason(string(corev1.NodeDiskPressure)),
+	}, {
+		name: "no disk pressure",
+		node: makeNode("", false, ready, noDiskPressure),
+	}, {
+		name:            "disk pressure, when configured eligible",
+		node:            makeNode("", false, ready, diskPressure),
+		ineligibleNodes: []string{config.IneligibleUnschedulable, config.IneligibleNotReady},
+	}, {
+		name:            "cordoned nodes are eligible when configured so",
+		node:            makeNode("", true, ready),
+		ineligibleNodes: []string{},
+	}, {
+		name: "already labeled",
+		node: makeNode(config.IneligibleUnschedulable, true, ready),
+	}, {
+		name:      "uncordoned",
+		node:      makeNode(config.IneligibleUnschedulable, false, ready),
+		wantPatch: true,
+	}, {
+		name:      "no longer cordoned, but not ready",
+		node:      makeNode(config.IneligibleUnschedulable, false, notReady),
+		wantPatch: true,
+		wantLabel: reason(config.IneligibleNotReady),
+	}, {
+		name:      "disk pressure relieved",
+		node:      makeNode(string(corev1.NodeDiskPressure), false, ready, noDiskPressure),
+		wantPatch: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := config.New("", "")
+			if test.ineligibleNodes != nil {
+				cfg.IneligibleNodes = test.ineligibleNodes
+			}
+			nodes := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			nodes.Add(test.node)
+			kube := &fakeKubeClient{patches: map[string][]byte{}}
+			r := &Reconciler{
+				kubeclientset: kube,
+				nodesLister:   corev1listers.NewNodeLister(nodes),
+				configStore:   config.NewStore(zap.NewNop().Sugar(), "warmimage-system", cfg),
+				Logger:        zap.NewNop().Sugar(),
+			}
+
+			if got := r.isStale(test.node); got != test.wantPatch {
+				t.Errorf("isStale() = %v, wanted %v", got, test.wantPatch)
+			}
+			if err := r.Reconcile(context.Background(), test.node.Name); err != nil {
+				t.Fatalf("Reconcile() = %v", err)
+			}
+			patch, patched := kube.patches[test.node.Name]
+			if patched != test.wantPatch {
+				t.Fatalf("patched %s, wanted a patch %v", patch, test.wantPatch)
+			} else if !patched {
+				return
+			}
+			want := map[string]*string{resources.IneligibleLabel: test.wantLabel}
+			if got := patchedLabels(t, patch); !reflect.DeepEqual(got, want) {
+				t.Errorf("patched labels %v, wanted %v", got, want)
+			}
+		})
+	}
+}
+
+func TestReconcileDeletedNode(t *testing.T) {
+	kube := &fakeKubeClient{patches: map[string][]byte{}}
+	r := &Reconciler{
+		kubeclientset: kube,
+		nodesLister:   corev1listers.NewNodeLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})),
+		configStore:   config.NewStore(zap.NewNop().Sugar(), "warmimage-system", config.New("", "")),
+		Logger:        zap.NewNop().Sugar(),
+	}
+	if err := r.Reconcile(context.Background(), "the-node"); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+	if len(kube.patches) != 0 {
+		t.Errorf("patches = %v, wanted none", kube.patches)
+	}
+}
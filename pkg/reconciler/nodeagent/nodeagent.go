@@ -0,0 +1,412 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package nodeagent implements the node agent, which pulls the images of the
+// WarmImages and ClusterWarmImages with the NodeAgent strategy onto the node
+// it runs on through the container runtime, rather than with pods, and removes
+// them again when they are deleted with a coolDown.
+package nodeagent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/knative/pkg/controller"
+	"github.com/knative/pkg/logging/logkey"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+	informers "github.com/mattmoor/warm-image/pkg/client/informers/externalversions/warmimage/v3"
+	listers "github.com/mattmoor/warm-image/pkg/client/listers/warmimage/v3"
+	"github.com/mattmoor/warm-image/pkg/cri"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/config"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/resources"
+	"github.com/mattmoor/warm-image/pkg/registry"
+)
+
+const controllerAgentName = "nodeagent"
+
+// Reconciler is the node agent's implementation. It has a single key: the
+// name of the node that it pulls the images onto.
+type Reconciler struct {
+	// kubeclientset is a standard kubernetes clientset
+	kubeclientset kubernetes.Interface
+
+	nodesLister             corev1listers.NodeLister
+	warmimagesLister        listers.WarmImageLister
+	clusterwarmimagesLister listers.ClusterWarmImageLister
+
+	// images is the image service of the node's container runtime.
+	images *cri.ImageService
+
+	// nodeName is the name of the node that we pull the images onto.
+	nodeName string
+
+	// systemNamespace is the namespace in which ClusterWarmImages are warmed.
+	systemNamespace string
+	// agentNamespace is the namespace in which the controller hands us the
+	// secrets to pull with.
+	agentNamespace string
+
+	// configStore holds the configuration of the warm pods, whose
+	// tolerations we honor.
+	configStore *config.Store
+
+	// Sugared logger is easier to use but is not as performant as the
+	// raw logger. In performance critical paths, call logger.Desugar()
+	// and use the returned raw logger instead. In addition to the
+	// performance benefits, raw logger also preserves type-safety at
+	// the expense of slightly greater verbosity.
+	Logger *zap.SugaredLogger
+}
+
+// Check that we implement the controller.Reconciler interface.
+var _ controller.Reconciler = (*Reconciler)(nil)
+
+// NewController returns a new node agent, which pulls images onto the named
+// node through the given image service. The node informer need only watch
+// that node.
+func NewController(
+	logger *zap.SugaredLogger,
+	kubeclientset kubernetes.Interface,
+	nodeInformer corev1informers.NodeInformer,
+	warmimageInformer informers.WarmImageInformer,
+	clusterwarmimageInformer informers.ClusterWarmImageInformer,
+	images *cri.ImageService,
+	nodeName string,
+	configStore *config.Store,
+	systemNamespace string,
+	agentNamespace string,
+) *controller.Impl {
+
+	// Enrich the logs with controller name
+	logger = logger.Named(controllerAgentName).With(zap.String(logkey.ControllerType, controllerAgentName))
+
+	r := &Reconciler{
+		kubeclientset:           kubeclientset,
+		nodesLister:             nodeInformer.Lister(),
+		warmimagesLister:        warmimageInformer.Lister(),
+		clusterwarmimagesLister: clusterwarmimageInformer.Lister(),
+		images:                  images,
+		nodeName:                nodeName,
+		systemNamespace:         systemNamespace,
+		agentNamespace:          agentNamespace,
+		configStore:             configStore,
+		Logger:                  logger,
+	}
+	impl := controller.NewImpl(r, logger, "NodeAgent")
+	enqueue := func(interface{}) {
+		impl.EnqueueKey(nodeName)
+	}
+
+	logger.Info("Setting up event handlers")
+	// Every change is a reason to revisit what to pull onto the node. The
+	// periodic resyncs of the WarmImages are how we notice that the kubelet
+	// garbage collected an image, and pull it again.
+	for _, informer := range []cache.SharedIndexInformer{warmimageInformer.Informer(), clusterwarmimageInformer.Informer()} {
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    enqueue,
+			UpdateFunc: controller.PassNew(enqueue),
+			DeleteFunc: enqueue,
+		})
+	}
+
+	// The node's labels and taints decide which images belong on it, and
+	// the controller labels it when it becomes ineligible, so ignore the
+	// frequent updates to its status, our own reports, and the labels with
+	// which the controller marks the images that are warm on it.
+	nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: enqueue,
+		UpdateFunc: func(old, new interface{}) {
+			oldNode, ok := old.(*corev1.Node)
+			if !ok {
+				return
+			}
+			newNode, ok := new.(*corev1.Node)
+			if !ok {
+				return
+			}
+			if !reflect.DeepEqual(resources.TargetingLabels(oldNode), resources.TargetingLabels(newNode)) ||
+				!reflect.DeepEqual(oldNode.Spec.Taints, newNode.Spec.Taints) {
+				enqueue(new)
+			}
+		},
+	})
+
+	// The tolerations in our configuration decide which images belong on
+	// the node too.
+	configStore.OnChange(func(*config.Config) { enqueue(nil) })
+
+	return impl
+}
+
+// Reconcile implements controller.Reconciler
+func (c *Reconciler) Reconcile(ctx context.Context, key string) error {
+	if key != c.nodeName {
+		runtime.HandleError(fmt.Errorf("unexpected key %q, we only pull onto %q", key, c.nodeName))
+		return nil
+	}
+	node, err := c.nodesLister.Get(c.nodeName)
+	if errors.IsNotFound(err) {
+		// The node may not exist yet, or anymore, in which case we
+		// have nothing to report on.
+		runtime.HandleError(fmt.Errorf("node %q in work queue no longer exists", key))
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	wis, err := c.assignments()
+	if err != nil {
+		return err
+	}
+	cfg := c.configStore.Get()
+	// Don't make matters worse on a node that is e.g. low on disk, but
+	// still remove the images of the WarmImages that are cooling down.
+	ineligible := resources.IneligibleReason(node, cfg)
+	if ineligible != "" {
+		c.Logger.Infof("Not pulling onto node %q, which is ineligible: %s", node.Name, ineligible)
+	}
+	reports := make(map[string]*resources.AgentReport, len(wis))
+	for _, wi := range wis {
+		if !resources.TargetsNode(wi, node, cfg) {
+			continue
+		}
+		if wi.DeletionTimestamp != nil {
+			report, err := c.remove(ctx, wi)
+			if err != nil {
+				return err
+			}
+			reports[resources.MakeAgentReportKey(wi.UID)] = report
+			continue
+		}
+		if ineligible != "" {
+			continue
+		}
+		if !isResolved(wi) {
+			// Wait for the controller to pin the images to digests, so
+			// that every node pulls the same ones.
+			continue
+		}
+		report, err := c.pull(ctx, wi)
+		if err != nil {
+			// Retry once we can talk to the container runtime, and leave
+			// our reports be until then.
+			return err
+		}
+		reports[resources.MakeAgentReportKey(wi.UID)] = report
+	}
+	return c.updateReports(node, reports)
+}
+
+// assignments returns the WarmImages whose images the node agents pull, or
+// remove once they are deleted with a coolDown, including the
+// ClusterWarmImages as the WarmImages that they are warmed as. Those of High
+// priority come first, so that we pull their images onto a new node first.
+func (c *Reconciler) assignments() ([]*warmimagev3.WarmImage, error) {
+	var assigned []*warmimagev3.WarmImage
+	wis, err := c.warmimagesLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, original := range wis {
+		// Don't modify the informer's copy.
+		wi := original.DeepCopy()
+		wi.SetDefaults()
+		if isAssigned(&wi.ObjectMeta, &wi.Spec) {
+			assigned = append(assigned, wi)
+		}
+	}
+
+	cwis, err := c.clusterwarmimagesLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, cwi := range cwis {
+		view := resources.MakeWarmImageView(cwi.DeepCopy(), c.systemNamespace)
+		if isAssigned(&view.ObjectMeta, &view.Spec) {
+			assigned = append(assigned, view)
+		}
+	}
+	sort.SliceStable(assigned, func(i, j int) bool {
+		return isHighPriority(assigned[i]) && !isHighPriority(assigned[j])
+	})
+	return assigned, nil
+}
+
+// isAssigned returns whether the node agents pull the images of the WarmImage
+// with the given metadata and spec, or remove them.
+func isAssigned(meta *metav1.ObjectMeta, spec *warmimagev3.WarmImageSpec) bool {
+	if spec.Strategy != warmimagev3.WarmImageStrategyNodeAgent {
+		return false
+	}
+	return meta.DeletionTimestamp == nil || spec.CoolDown != nil
+}
+
+func isHighPriority(wi *warmimagev3.WarmImage) bool {
+	return wi.Spec.Priority == warmimagev3.WarmImagePriorityHigh
+}
+
+// isResolved returns whether the controller has resolved the images of this
+// generation of the WarmImage to digests.
+func isResolved(wi *warmimagev3.WarmImage) bool {
+	if wi.Status.ObservedGeneration != wi.Generation {
+		return false
+	}
+	for _, image := range wi.Spec.Images {
+		if is := wi.Status.GetImage(image); is == nil || is.Digest == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// pull pulls the WarmImage's images onto the node in order, unless the node
+// already has them, and reports on how that went. It stops at the first
+// image that fails to pull, and only returns an error when it is unable to
+// talk to the container runtime.
+func (c *Reconciler) pull(ctx context.Context, wi *warmimagev3.WarmImage) (*resources.AgentReport, error) {
+	var kc registry.Keychain
+	for _, image := range wi.Spec.Images {
+		ref := resources.UserImage(wi, image)
+		img, err := c.images.ImageStatus(ctx, ref)
+		if err != nil {
+			return nil, fmt.Errorf("error getting the status of %q: %v", ref, err)
+		} else if img != nil {
+			continue
+		}
+
+		if kc == nil {
+			// Pull with the credentials that the warm pods would, which
+			// the controller hands us in the agent namespace.
+			secret, err := c.kubeclientset.CoreV1().Secrets(c.agentNamespace).Get(resources.MakeAgentSecretName(wi), metav1.GetOptions{})
+			if err != nil {
+				return resources.MakeAgentReport(wi, "SecretsFailed", image, err.Error()), nil
+			}
+			if kc, err = registry.NewKeychain(secret); err != nil {
+				return resources.MakeAgentReport(wi, "SecretsFailed", image, err.Error()), nil
+			}
+		}
+		var cred *registry.Credential
+		if parsed, err := registry.ParseReference(ref); err != nil {
+			return resources.MakeAgentReport(wi, "InvalidImageName", image, err.Error()), nil
+		} else if found, ok := kc.Lookup(parsed.Registry); ok {
+			cred = &found
+		}
+
+		c.Logger.Infof("Pulling %q", ref)
+		if _, err := c.images.PullImage(ctx, ref, cred); err != nil {
+			c.Logger.Errorw(fmt.Sprintf("Failed to pull %q", ref), zap.Error(err))
+			return resources.MakeAgentReport(wi, "ErrImagePull", image, err.Error()), nil
+		}
+		c.Logger.Infof("Pulled %q", ref)
+	}
+	return resources.MakeAgentReport(wi, "", "", ""), nil
+}
+
+// referencedImages returns the images, and the digests they resolved to, of
+// the WarmImages and ClusterWarmImages other than the given one that aren't
+// being deleted, which removing its images must leave on the node.
+func (c *Reconciler) referencedImages(wi *warmimagev3.WarmImage) (sets.String, error) {
+	wis, err := c.warmimagesLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	cwis, err := c.clusterwarmimagesLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, cwi := range cwis {
+		wis = append(wis, resources.MakeWarmImageView(cwi, c.systemNamespace))
+	}
+	return resources.ReferencedImages(wis, wi.UID), nil
+}
+
+// remove removes the images of the WarmImage, which is being deleted, from
+// the node, other than those that other WarmImages or ClusterWarmImages use,
+// and reports on how that went. It only returns an error when it is unable to
+// talk to the container runtime.
+func (c *Reconciler) remove(ctx context.Context, wi *warmimagev3.WarmImage) (*resources.AgentReport, error) {
+	referenced, err := c.referencedImages(wi)
+	if err != nil {
+		return nil, err
+	}
+	for _, image := range resources.RemovableImages(wi, referenced) {
+		ref := resources.UserImage(wi, image)
+		img, err := c.images.ImageStatus(ctx, ref)
+		if err != nil {
+			return nil, fmt.Errorf("error getting the status of %q: %v", ref, err)
+		} else if img == nil {
+			continue
+		}
+		if err := c.images.RemoveImage(ctx, ref); err != nil {
+			c.Logger.Errorw(fmt.Sprintf("Failed to remove %q", ref), zap.Error(err))
+			return resources.MakeAgentRemovedReport(wi, "RemoveFailed", image, err.Error()), nil
+		}
+		c.Logger.Infof("Removed %q", ref)
+	}
+	return resources.MakeAgentRemovedReport(wi, "", "", ""), nil
+}
+
+// updateReports makes the node's annotations report the given reports, keyed
+// by annotation, removing our reports on anything else.
+func (c *Reconciler) updateReports(node *corev1.Node, reports map[string]*resources.AgentReport) error {
+	// A JSON merge patch of the annotations, in which null removes one.
+	patch := make(map[string]interface{})
+	for key := range node.Annotations {
+		if _, ok := reports[key]; !ok && strings.HasPrefix(key, resources.AgentReportPrefix) {
+			patch[key] = nil
+		}
+	}
+	for key, report := range reports {
+		b, err := json.Marshal(report)
+		if err != nil {
+			return err
+		}
+		if node.Annotations[key] != string(b) {
+			patch[key] = string(b)
+		}
+	}
+	if len(patch) == 0 {
+		return nil
+	}
+
+	b, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": patch,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.kubeclientset.CoreV1().Nodes().Patch(node.Name, types.MergePatchType, b)
+	return err
+}
@@ -0,0 +1,376 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+	listers "github.com/mattmoor/warm-image/pkg/client/listers/warmimage/v3"
+	"github.com/mattmoor/warm-image/pkg/cri"
+	"github.com/mattmoor/warm-image/pkg/cri/fake"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/config"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/resources"
+	"github.com/mattmoor/warm-image/pkg/registry"
+)
+
+const (
+	testNode            = "the-node"
+	testSystemNamespace = "warmimage-system"
+	testAgentNamespace  = "warmimage-agents"
+	testImage           = "gcr.io/foo/bar:latest"
+	testDigest          = "sha256:deadbeef"
+	testPinned          = "gcr.io/foo/bar@sha256:deadbeef"
+)
+
+// fakeKubeClient serves the secrets and records the node patches that the
+// node agent makes, which is all of the API that it uses besides its
+// informers.
+type fakeKubeClient struct {
+	kubernetes.Interface
+
+	// secrets holds the secrets, by namespace/name.
+	secrets map[string]*corev1.Secret
+	// patches holds the merge patches of our node, in order.
+	patches [][]byte
+}
+
+func (c *fakeKubeClient) CoreV1() typedcorev1.CoreV1Interface {
+	return &fakeCoreV1{c: c}
+}
+
+type fakeCoreV1 struct {
+	typedcorev1.CoreV1Interface
+	c *fakeKubeClient
+}
+
+func (c *fakeCoreV1) Secrets(namespace string) typedcorev1.SecretInterface {
+	return &fakeSecrets{c: c.c, namespace: namespace}
+}
+
+func (c *fakeCoreV1) Nodes() typedcorev1.NodeInterface {
+	return &fakeNodes{c: c.c}
+}
+
+type fakeSecrets struct {
+	typedcorev1.SecretInterface
+	c         *fakeKubeClient
+	namespace string
+}
+
+func (s *fakeSecrets) Get(name string, opts metav1.GetOptions) (*corev1.Secret, error) {
+	secret, ok := s.c.secrets[s.namespace+"/"+name]
+	if !ok {
+		return nil, apierrors.NewNotFound(corev1.Resource("secrets"), name)
+	}
+	return secret, nil
+}
+
+type fakeNodes struct {
+	typedcorev1.NodeInterface
+	c *fakeKubeClient
+}
+
+func (n *fakeNodes) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (*corev1.Node, error) {
+	if name != testNode {
+		return nil, apierrors.NewForbidden(corev1.Resource("nodes"), name, errors.New("not our node"))
+	}
+	n.c.patches = append(n.c.patches, data)
+	return &corev1.Node{}, nil
+}
+
+// patchedAnnotations returns the annotations in the merge patch, in which
+// nil removes one.
+func patchedAnnotations(t *testing.T, patch []byte) map[string]*string {
+	t.Helper()
+	var p struct {
+		Metadata struct {
+			Annotations map[string]*string `json:"annotations"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		t.Fatalf("Unmarshal(%s) = %v", patch, err)
+	}
+	return p.Metadata.Annotations
+}
+
+func readyNode(annotations map[string]string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        testNode,
+			Annotations: annotations,
+		},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{
+				Type:   corev1.NodeReady,
+				Status: corev1.ConditionTrue,
+			}},
+		},
+	}
+}
+
+// resolvedWarmImage returns a WarmImage with the NodeAgent strategy, whose
+// image the controller resolved to testDigest.
+func resolvedWarmImage() *warmimagev3.WarmImage {
+	wi := &warmimagev3.WarmImage{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:  "default",
+			Name:       "foo",
+			UID:        "the-uid",
+			Generation: 1,
+		},
+		Spec: warmimagev3.WarmImageSpec{
+			Images:   []string{testImage},
+			Strategy: warmimagev3.WarmImageStrategyNodeAgent,
+		},
+	}
+	wi.SetDefaults()
+	wi.Status.InitializeImages(wi.Spec.Images)
+	wi.Status.MarkResolved(testImage, testDigest, nil)
+	wi.Status.ObservedGeneration = wi.Generation
+	return wi
+}
+
+// agentSecret returns the secret with the credentials for gcr.io that the
+// controller hands the node agents for the WarmImage.
+func agentSecret(t *testing.T, wi *warmimagev3.WarmImage) *corev1.Secret {
+	t.Helper()
+	secret, err := resources.MakeAgentSecret(wi, testAgentNamespace, registry.Keychain{
+		"gcr.io": {Username: "user", Password: "hunter2"},
+	})
+	if err != nil {
+		t.Fatalf("MakeAgentSecret() = %v", err)
+	}
+	return secret
+}
+
+func reportJSON(t *testing.T, report *resources.AgentReport) string {
+	t.Helper()
+	b, err := json.Marshal(report)
+	if err != nil {
+		t.Fatalf("Marshal() = %v", err)
+	}
+	return string(b)
+}
+
+func TestReconcile(t *testing.T) {
+	staleKey := resources.MakeAgentReportKey("deleted-uid")
+	deleted := resolvedWarmImage()
+	deleted.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	deleted.Spec.CoolDown = &warmimagev3.WarmImageCoolDown{}
+	unresolved := resolvedWarmImage()
+	unresolved.Generation = 2
+	pinned := resolvedWarmImage()
+	pinned.Spec.Strategy = warmimagev3.WarmImageStrategyPinned
+	// Another WarmImage of the same digest, under another tag.
+	sharing := resolvedWarmImage()
+	sharing.Name, sharing.UID = "bar", "other-uid"
+	sharing.Spec.Strategy = warmimagev3.WarmImageStrategyPinned
+	sharing.Spec.Images = []string{"gcr.io/foo/bar:v1"}
+	sharing.Status.InitializeImages(sharing.Spec.Images)
+	sharing.Status.MarkResolved("gcr.io/foo/bar:v1", testDigest, nil)
+
+	tests := []struct {
+		name        string
+		wi          *warmimagev3.WarmImage
+		others      []*warmimagev3.WarmImage
+		annotations map[string]string
+		present     bool
+		pullErr     error
+		noSecret    bool
+		wantPulled  bool
+		wantAuth    bool
+		wantPresent bool
+		// wantReport is the report we expect on the WarmImage, if any.
+		wantReport *resources.AgentReport
+		// wantRemoved are the annotations we expect to be removed.
+		wantRemoved []string
+	}{{
+		name:        "pulls the pinned image with the controller's credentials",
+		wi:          resolvedWarmImage(),
+		wantPulled:  true,
+		wantAuth:    true,
+		wantPresent: true,
+		wantReport:  resources.MakeAgentReport(resolvedWarmImage(), "", "", ""),
+	}, {
+		name:        "leaves an image the node has be",
+		wi:          resolvedWarmImage(),
+		present:     true,
+		wantPresent: true,
+		wantReport:  resources.MakeAgentReport(resolvedWarmImage(), "", "", ""),
+	}, {
+		name:       "reports a failed pull",
+		wi:         resolvedWarmImage(),
+		pullErr:    errors.New("manifest unknown"),
+		wantPulled: true,
+		wantAuth:   true,
+		wantReport: resources.MakeAgentReport(resolvedWarmImage(), "ErrImagePull", testImage,
+			"rpc error: code = Unknown desc = manifest unknown"),
+	}, {
+		name:       "reports missing credentials",
+		wi:         resolvedWarmImage(),
+		noSecret:   true,
+		wantReport: resources.MakeAgentReport(resolvedWarmImage(), "SecretsFailed", testImage, `secrets "`+resources.MakeAgentSecretName(resolvedWarmImage())+`" not found`),
+	}, {
+		name: "waits for the images to be resolved",
+		wi:   unresolved,
+	}, {
+		name: "ignores the other strategies",
+		wi:   pinned,
+	}, {
+		name:        "removes the images of a WarmImage that is cooling down",
+		wi:          deleted,
+		present:     true,
+		wantReport:  resources.MakeAgentRemovedReport(deleted, "", "", ""),
+		wantPresent: false,
+	}, {
+		name:        "leaves the images that other WarmImages use",
+		wi:          deleted,
+		others:      []*warmimagev3.WarmImage{sharing},
+		present:     true,
+		wantReport:  resources.MakeAgentRemovedReport(deleted, "", "", ""),
+		wantPresent: true,
+	}, {
+		name:        "removes stale reports",
+		wi:          pinned,
+		annotations: map[string]string{staleKey: "{}", "other": "annotation"},
+		wantRemoved: []string{staleKey},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := fake.NewImageService()
+			if err != nil {
+				t.Fatalf("fake.NewImageService() = %v", err)
+			}
+			defer f.Stop()
+			images, err := cri.NewImageService(f.Endpoint(), 10*time.Second)
+			if err != nil {
+				t.Fatalf("cri.NewImageService() = %v", err)
+			}
+			defer images.Close()
+			if test.present {
+				f.AddImage(testPinned)
+			}
+			if test.pullErr != nil {
+				f.SetPullError(testPinned, test.pullErr)
+			}
+
+			kube := &fakeKubeClient{secrets: map[string]*corev1.Secret{}}
+			if !test.noSecret {
+				secret := agentSecret(t, test.wi)
+				kube.secrets[secret.Namespace+"/"+secret.Name] = secret
+			}
+			nodes := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			nodes.Add(readyNode(test.annotations))
+			wis := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			wis.Add(test.wi)
+			for _, wi := range test.others {
+				wis.Add(wi)
+			}
+			cwis := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+
+			r := &Reconciler{
+				kubeclientset:           kube,
+				nodesLister:             corev1listers.NewNodeLister(nodes),
+				warmimagesLister:        listers.NewWarmImageLister(wis),
+				clusterwarmimagesLister: listers.NewClusterWarmImageLister(cwis),
+				images:                  images,
+				nodeName:                testNode,
+				systemNamespace:         testSystemNamespace,
+				agentNamespace:          testAgentNamespace,
+				configStore:             config.NewStore(zap.NewNop().Sugar(), testSystemNamespace, config.New("", "")),
+				Logger:                  zap.NewNop().Sugar(),
+			}
+			if err := r.Reconcile(context.Background(), testNode); err != nil {
+				t.Fatalf("Reconcile() = %v", err)
+			}
+
+			pulls := f.Pulls()
+			if got := len(pulls) > 0; got != test.wantPulled {
+				t.Errorf("pulled = %v, wanted %v", got, test.wantPulled)
+			}
+			for _, pull := range pulls {
+				if got := pull.GetImage().GetImage(); got != testPinned {
+					t.Errorf("pulled %q, wanted %q", got, testPinned)
+				}
+				if got := pull.GetAuth() != nil; got != test.wantAuth {
+					t.Errorf("pulled with credentials = %v, wanted %v", got, test.wantAuth)
+				}
+			}
+			if got := f.HasImage(testPinned); got != test.wantPresent {
+				t.Errorf("HasImage() = %v, wanted %v", got, test.wantPresent)
+			}
+
+			want := map[string]*string{}
+			if test.wantReport != nil {
+				report := reportJSON(t, test.wantReport)
+				want[resources.MakeAgentReportKey(test.wi.UID)] = &report
+			}
+			for _, key := range test.wantRemoved {
+				want[key] = nil
+			}
+			got := map[string]*string{}
+			switch len(kube.patches) {
+			case 0:
+			case 1:
+				got = patchedAnnotations(t, kube.patches[0])
+			default:
+				t.Fatalf("patched the node %d times, wanted at most once", len(kube.patches))
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("patched annotations = %s, wanted %s", toJSON(got), toJSON(want))
+			}
+		})
+	}
+}
+
+func TestReconcileOtherNode(t *testing.T) {
+	kube := &fakeKubeClient{}
+	r := &Reconciler{
+		kubeclientset: kube,
+		nodeName:      testNode,
+		Logger:        zap.NewNop().Sugar(),
+	}
+	// We only ever look at, and patch, our own node.
+	if err := r.Reconcile(context.Background(), "another-node"); err != nil {
+		t.Errorf("Reconcile() = %v", err)
+	}
+	if len(kube.patches) != 0 {
+		t.Errorf("patched %d times, wanted none", len(kube.patches))
+	}
+}
+
+func toJSON(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,262 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package startuptaint removes the startup taint from the nodes that join the
+// cluster with it, once the images of the Required WarmImages and
+// ClusterWarmImages are warm on them, so that workloads only land on nodes
+// that already hold their images, or once the configured timeout passes.
+package startuptaint
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/knative/pkg/controller"
+	"github.com/knative/pkg/logging/logkey"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+	informers "github.com/mattmoor/warm-image/pkg/client/informers/externalversions/warmimage/v3"
+	listers "github.com/mattmoor/warm-image/pkg/client/listers/warmimage/v3"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/config"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/resources"
+)
+
+const controllerAgentName = "startuptaint-controller"
+
+// Reconciler implements controller.Reconciler for Nodes, from which it
+// removes the startup taint configured in the config-warmimage ConfigMap once
+// the images of the Required WarmImages and ClusterWarmImages that target
+// them are warm there, as their ready labels tell.
+type Reconciler struct {
+	// kubeclientset is a standard kubernetes clientset
+	kubeclientset kubernetes.Interface
+
+	nodesLister             corev1listers.NodeLister
+	warmimagesLister        listers.WarmImageLister
+	clusterwarmimagesLister listers.ClusterWarmImageLister
+
+	// systemNamespace is the namespace in which the ClusterWarmImages are
+	// warmed.
+	systemNamespace string
+
+	// configStore holds the configuration, whose StartupTaint we remove.
+	configStore *config.Store
+
+	// enqueueAfter schedules the node with the given key to be reconciled
+	// again after a delay, once its startup taint times out.
+	enqueueAfter func(key string, delay time.Duration)
+
+	// Sugared logger is easier to use but is not as performant as the
+	// raw logger. In performance critical paths, call logger.Desugar()
+	// and use the returned raw logger instead. In addition to the
+	// performance benefits, raw logger also preserves type-safety at
+	// the expense of slightly greater verbosity.
+	Logger *zap.SugaredLogger
+}
+
+// Check that we implement the controller.Reconciler interface.
+var _ controller.Reconciler = (*Reconciler)(nil)
+
+// NewController returns a new startup taint controller, which removes the
+// startup taint of the given Store's configuration. It does nothing unless
+// one is configured.
+func NewController(
+	logger *zap.SugaredLogger,
+	kubeclientset kubernetes.Interface,
+	nodeInformer corev1informers.NodeInformer,
+	warmimageInformer informers.WarmImageInformer,
+	clusterwarmimageInformer informers.ClusterWarmImageInformer,
+	configStore *config.Store,
+	systemNamespace string,
+) *controller.Impl {
+
+	// Enrich the logs with controller name
+	logger = logger.Named(controllerAgentName).With(zap.String(logkey.ControllerType, controllerAgentName))
+
+	r := &Reconciler{
+		kubeclientset:           kubeclientset,
+		nodesLister:             nodeInformer.Lister(),
+		warmimagesLister:        warmimageInformer.Lister(),
+		clusterwarmimagesLister: clusterwarmimageInformer.Lister(),
+		systemNamespace:         systemNamespace,
+		configStore:             configStore,
+		Logger:                  logger,
+	}
+	impl := controller.NewImpl(r, logger, "StartupTaint")
+	r.enqueueAfter = func(key string, delay time.Duration) {
+		impl.WorkQueue.AddAfter(key, delay)
+	}
+
+	logger.Info("Setting up event handlers")
+	// Only enqueue the nodes that still have the startup taint, since the
+	// status of every node is updated often. Their ready labels change as
+	// the images warm onto them.
+	enqueueTainted := func(obj interface{}) {
+		if node, ok := obj.(*corev1.Node); ok && hasTaint(node, r.configStore.Get().StartupTaint) {
+			impl.Enqueue(node)
+		}
+	}
+	nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueueTainted,
+		UpdateFunc: controller.PassNew(enqueueTainted),
+	})
+
+	// Revisit the tainted nodes when the WarmImages change, e.g. when one
+	// is no longer Required, or no longer targets them.
+	resync := func(interface{}) {
+		nodes, err := r.nodesLister.List(labels.Everything())
+		if err != nil {
+			logger.Errorw("Failed to list Nodes", zap.Error(err))
+			return
+		}
+		for _, node := range nodes {
+			enqueueTainted(node)
+		}
+	}
+	for _, informer := range []cache.SharedIndexInformer{warmimageInformer.Informer(), clusterwarmimageInformer.Informer()} {
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    resync,
+			UpdateFunc: controller.PassNew(resync),
+			DeleteFunc: resync,
+		})
+	}
+
+	// And when our configuration changes.
+	configStore.OnChange(func(*config.Config) { resync(nil) })
+
+	return impl
+}
+
+// hasTaint returns whether the node has a taint with the given key.
+func hasTaint(node *corev1.Node, key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Reconcile implements controller.Reconciler
+func (c *Reconciler) Reconcile(ctx context.Context, key string) error {
+	node, err := c.nodesLister.Get(key)
+	if errors.IsNotFound(err) {
+		runtime.HandleError(fmt.Errorf("node %q in work queue no longer exists", key))
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	cfg := c.configStore.Get()
+	if !hasTaint(node, cfg.StartupTaint) {
+		return nil
+	}
+	if timeout := cfg.StartupTaintTimeout; timeout > 0 {
+		if remaining := timeout - time.Since(node.CreationTimestamp.Time); remaining > 0 {
+			// Come back once it times out, in case the images never warm.
+			c.enqueueAfter(key, remaining)
+		} else {
+			c.Logger.Warnf("Removing the startup taint %q from node %q, which joined over %v ago, whether or not its required images are warm", cfg.StartupTaint, node.Name, timeout)
+			return c.removeTaint(node, cfg)
+		}
+	}
+
+	switch reason := resources.IneligibleReason(node, cfg); reason {
+	case "":
+		waiting, err := c.waitingOn(node, cfg)
+		if err != nil {
+			return err
+		} else if len(waiting) > 0 {
+			c.Logger.Debugf("Keeping the startup taint of node %q until %q are warm on it", node.Name, waiting)
+			return nil
+		}
+		c.Logger.Infof("Removing the startup taint %q from node %q, whose required images are warm", cfg.StartupTaint, node.Name)
+	case config.IneligibleNotReady:
+		// The nodes join the cluster NotReady, and we warm the images onto
+		// them once they are Ready, which updates them.
+		c.Logger.Debugf("Keeping the startup taint of node %q until it is Ready", node.Name)
+		return nil
+	default:
+		// We don't warm the images onto the node, so they would never
+		// be warm there.
+		c.Logger.Infof("Removing the startup taint %q from node %q, which is ineligible for warming: %s", cfg.StartupTaint, node.Name, reason)
+	}
+	return c.removeTaint(node, cfg)
+}
+
+// removeTaint removes the configured startup taint from the node.
+func (c *Reconciler) removeTaint(node *corev1.Node, cfg *config.Config) error {
+	// Don't modify the informer's copy.
+	want := node.DeepCopy()
+	want.Spec.Taints = nil
+	for _, taint := range node.Spec.Taints {
+		if taint.Key != cfg.StartupTaint {
+			want.Spec.Taints = append(want.Spec.Taints, taint)
+		}
+	}
+	// A conflict gets us reconciled again, with the latest node.
+	_, err := c.kubeclientset.CoreV1().Nodes().Update(want)
+	return err
+}
+
+// waitingOn returns the ready labels of the Required WarmImages and
+// ClusterWarmImages that target the node, but whose images aren't warm on it
+// yet. Those whose images don't support the node's platform don't target it,
+// so we don't wait on them.
+func (c *Reconciler) waitingOn(node *corev1.Node, cfg *config.Config) ([]string, error) {
+	var waiting []string
+	check := func(wi *warmimagev3.WarmImage, label string) {
+		if !wi.Spec.Required || wi.DeletionTimestamp != nil || !resources.TargetsNode(wi, node, cfg) {
+			return
+		}
+		if label == "" {
+			c.Logger.Warnf("Not waiting on %s/%s, whose name is too long for a ready label", wi.Namespace, wi.Name)
+			return
+		}
+		if node.Labels[label] != resources.ReadyLabelValue {
+			waiting = append(waiting, label)
+		}
+	}
+
+	wis, err := c.warmimagesLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, wi := range wis {
+		check(wi, resources.ReadyLabel(wi.Namespace, wi.Name))
+	}
+	cwis, err := c.clusterwarmimagesLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, cwi := range cwis {
+		check(resources.MakeWarmImageView(cwi, c.systemNamespace), resources.ReadyLabel("", cwi.Name))
+	}
+	return waiting, nil
+}
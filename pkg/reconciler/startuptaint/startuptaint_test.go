@@ -0,0 +1,400 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package startuptaint
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+	listers "github.com/mattmoor/warm-image/pkg/client/listers/warmimage/v3"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/config"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/resources"
+)
+
+const (
+	testNamespace = "default"
+	testTaint     = "warmimage.mattmoor.io/startup"
+)
+
+// fakeKubeClient records the node updates that the controller makes, which
+// is all of the API that it uses besides its informers.
+type fakeKubeClient struct {
+	kubernetes.Interface
+
+	updated []*corev1.Node
+}
+
+func (c *fakeKubeClient) CoreV1() typedcorev1.CoreV1Interface {
+	return &fakeCoreV1{c: c}
+}
+
+type fakeCoreV1 struct {
+	typedcorev1.CoreV1Interface
+	c *fakeKubeClient
+}
+
+func (c *fakeCoreV1) Nodes() typedcorev1.NodeInterface {
+	return &fakeNodes{c: c.c}
+}
+
+type fakeNodes struct {
+	typedcorev1.NodeInterface
+	c *fakeKubeClient
+}
+
+func (n *fakeNodes) Update(node *corev1.Node) (*corev1.Node, error) {
+	n.c.updated = append(n.c.updated, node)
+	return node, nil
+}
+
+var (
+	startupTaint = corev1.Taint{
+		Key:    testTaint,
+		Effect: corev1.TaintEffectNoSchedule,
+	}
+	otherTaint = corev1.Taint{
+		Key:    "dedicated",
+		Value:  "gpu",
+		Effect: corev1.TaintEffectNoSchedule,
+	}
+)
+
+// makeNode returns an amd64 node that joined the cluster the given time ago,
+// with the given taints, which is Ready unless told otherwise.
+func makeNode(age time.Duration, taints []corev1.Taint, opts ...func(*corev1.Node)) *corev1.Node {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "the-node",
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-age)),
+			Labels: map[string]string{
+				resources.OSLabel:   "linux",
+				resources.ArchLabel: "amd64",
+			},
+		},
+		Spec: corev1.NodeSpec{
+			Taints: taints,
+		},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{
+				Type:   corev1.NodeReady,
+				Status: corev1.ConditionTrue,
+			}},
+		},
+	}
+	for _, opt := range opts {
+		opt(node)
+	}
+	return node
+}
+
+func withLabel(key, value string) func(*corev1.Node) {
+	return func(node *corev1.Node) {
+		node.Labels[key] = value
+	}
+}
+
+func withCondition(t corev1.NodeConditionType, status corev1.ConditionStatus) func(*corev1.Node) {
+	return func(node *corev1.Node) {
+		for i, c := range node.Status.Conditions {
+			if c.Type == t {
+				node.Status.Conditions[i].Status = status
+				return
+			}
+		}
+		node.Status.Conditions = append(node.Status.Conditions, corev1.NodeCondition{
+			Type:   t,
+			Status: status,
+		})
+	}
+}
+
+func cordoned(node *corev1.Node) {
+	node.Spec.Unschedulable = true
+}
+
+// makeWarmImage returns a Required WarmImage named "foo" of the ubuntu image.
+func makeWarmImage(opts ...func(*warmimagev3.WarmImage)) *warmimagev3.WarmImage {
+	wi := &warmimagev3.WarmImage{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: testNamespace,
+			Name:      "foo",
+		},
+		Spec: warmimagev3.WarmImageSpec{
+			Images:   []string{"ubuntu"},
+			Required: true,
+		},
+	}
+	for _, opt := range opts {
+		opt(wi)
+	}
+	return wi
+}
+
+func notRequired(wi *warmimagev3.WarmImage) {
+	wi.Spec.Required = false
+}
+
+func beingDeleted(wi *warmimagev3.WarmImage) {
+	now := metav1.Now()
+	wi.DeletionTimestamp = &now
+}
+
+func withNodeSelector(selector map[string]string) func(*warmimagev3.WarmImage) {
+	return func(wi *warmimagev3.WarmImage) {
+		wi.Spec.NodeSelector = selector
+	}
+}
+
+func withPlatforms(platforms ...string) func(*warmimagev3.WarmImage) {
+	return func(wi *warmimagev3.WarmImage) {
+		wi.Status.InitializeImages(wi.Spec.Images)
+		for _, image := range wi.Spec.Images {
+			wi.Status.MarkResolved(image, "sha256:deadbeef", platforms)
+		}
+	}
+}
+
+// makeClusterWarmImage returns a Required ClusterWarmImage named "bar" of the
+// ubuntu image.
+func makeClusterWarmImage() *warmimagev3.ClusterWarmImage {
+	return &warmimagev3.ClusterWarmImage{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "bar",
+		},
+		Spec: warmimagev3.ClusterWarmImageSpec{
+			Images:   []string{"ubuntu"},
+			Required: true,
+		},
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	fooReady := resources.ReadyLabel(testNamespace, "foo")
+	barReady := resources.ReadyLabel("", "bar")
+
+	tests := []struct {
+		name string
+		node *corev1.Node
+		wis  []*warmimagev3.WarmImage
+		cwis []*warmimagev3.ClusterWarmImage
+		// noTimeout disables the startup taint timeout.
+		noTimeout bool
+		// wantTaints are the taints with which we update the node, if we
+		// update it at all.
+		wantUpdate bool
+		wantTaints []corev1.Taint
+		// wantEnqueue is whether we come back for the node once it times
+		// out.
+		wantEnqueue bool
+	}{{
+		name: "no startup taint",
+		node: makeNode(time.Minute, []corev1.Taint{otherTaint}),
+		wis:  []*warmimagev3.WarmImage{makeWarmImage()},
+	}, {
+		name:        "nothing required",
+		node:        makeNode(time.Minute, []corev1.Taint{startupTaint}),
+		wantUpdate:  true,
+		wantEnqueue: true,
+	}, {
+		name:        "waiting on a warmimage",
+		node:        makeNode(time.Minute, []corev1.Taint{startupTaint}),
+		wis:         []*warmimagev3.WarmImage{makeWarmImage()},
+		wantEnqueue: true,
+	}, {
+		name:        "warmimage is warm",
+		node:        makeNode(time.Minute, []corev1.Taint{startupTaint}, withLabel(fooReady, resources.ReadyLabelValue)),
+		wis:         []*warmimagev3.WarmImage{makeWarmImage()},
+		wantUpdate:  true,
+		wantEnqueue: true,
+	}, {
+		name:        "keeps the other taints",
+		node:        makeNode(time.Minute, []corev1.Taint{otherTaint, startupTaint}),
+		wantUpdate:  true,
+		wantTaints:  []corev1.Taint{otherTaint},
+		wantEnqueue: true,
+	}, {
+		name:        "warmimage isn't required",
+		node:        makeNode(time.Minute, []corev1.Taint{startupTaint}),
+		wis:         []*warmimagev3.WarmImage{makeWarmImage(notRequired)},
+		wantUpdate:  true,
+		wantEnqueue: true,
+	}, {
+		name:        "warmimage is being deleted",
+		node:        makeNode(time.Minute, []corev1.Taint{startupTaint}),
+		wis:         []*warmimagev3.WarmImage{makeWarmImage(beingDeleted)},
+		wantUpdate:  true,
+		wantEnqueue: true,
+	}, {
+		name:        "warmimage selects other nodes",
+		node:        makeNode(time.Minute, []corev1.Taint{startupTaint}),
+		wis:         []*warmimagev3.WarmImage{makeWarmImage(withNodeSelector(map[string]string{"gpu": "true"}))},
+		wantUpdate:  true,
+		wantEnqueue: true,
+	}, {
+		name:        "warmimage won't warm on the node's platform",
+		node:        makeNode(time.Minute, []corev1.Taint{startupTaint}),
+		wis:         []*warmimagev3.WarmImage{makeWarmImage(withPlatforms("linux/arm64"))},
+		wantUpdate:  true,
+		wantEnqueue: true,
+	}, {
+		name:        "warmimage supports the node's platform",
+		node:        makeNode(time.Minute, []corev1.Taint{startupTaint}),
+		wis:         []*warmimagev3.WarmImage{makeWarmImage(withPlatforms("linux/amd64", "linux/arm64"))},
+		wantEnqueue: true,
+	}, {
+		name:        "waiting on a clusterwarmimage",
+		node:        makeNode(time.Minute, []corev1.Taint{startupTaint}, withLabel(fooReady, resources.ReadyLabelValue)),
+		wis:         []*warmimagev3.WarmImage{makeWarmImage()},
+		cwis:        []*warmimagev3.ClusterWarmImage{makeClusterWarmImage()},
+		wantEnqueue: true,
+	}, {
+		name: "everything is warm",
+		node: makeNode(time.Minute, []corev1.Taint{startupTaint},
+			withLabel(fooReady, resources.ReadyLabelValue), withLabel(barReady, resources.ReadyLabelValue)),
+		wis:         []*warmimagev3.WarmImage{makeWarmImage()},
+		cwis:        []*warmimagev3.ClusterWarmImage{makeClusterWarmImage()},
+		wantUpdate:  true,
+		wantEnqueue: true,
+	}, {
+		name:        "not ready yet",
+		node:        makeNode(time.Minute, []corev1.Taint{startupTaint}, withCondition(corev1.NodeReady, corev1.ConditionFalse)),
+		wantEnqueue: true,
+	}, {
+		name:        "cordoned, so the images won't warm",
+		node:        makeNode(time.Minute, []corev1.Taint{startupTaint}, cordoned),
+		wis:         []*warmimagev3.WarmImage{makeWarmImage()},
+		wantUpdate:  true,
+		wantEnqueue: true,
+	}, {
+		name:        "disk pressure, so the images won't warm",
+		node:        makeNode(time.Minute, []corev1.Taint{startupTaint}, withCondition(corev1.NodeDiskPressure, corev1.ConditionTrue)),
+		wis:         []*warmimagev3.WarmImage{makeWarmImage()},
+		wantUpdate:  true,
+		wantEnqueue: true,
+	}, {
+		name:       "timed out waiting",
+		node:       makeNode(time.Hour, []corev1.Taint{startupTaint}),
+		wis:        []*warmimagev3.WarmImage{makeWarmImage()},
+		wantUpdate: true,
+	}, {
+		name:       "timed out while not ready",
+		node:       makeNode(time.Hour, []corev1.Taint{startupTaint}, withCondition(corev1.NodeReady, corev1.ConditionFalse)),
+		wantUpdate: true,
+	}, {
+		name:      "no timeout",
+		node:      makeNode(time.Hour, []corev1.Taint{startupTaint}),
+		wis:       []*warmimagev3.WarmImage{makeWarmImage()},
+		noTimeout: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := config.New("", "")
+			cfg.StartupTaint = testTaint
+			if test.noTimeout {
+				cfg.StartupTaintTimeout = 0
+			}
+
+			nodes := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			nodes.Add(test.node)
+			wis := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			for _, wi := range test.wis {
+				wis.Add(wi)
+			}
+			cwis := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			for _, cwi := range test.cwis {
+				cwis.Add(cwi)
+			}
+			kube := &fakeKubeClient{}
+			var enqueued []time.Duration
+			r := &Reconciler{
+				kubeclientset:           kube,
+				nodesLister:             corev1listers.NewNodeLister(nodes),
+				warmimagesLister:        listers.NewWarmImageLister(wis),
+				clusterwarmimagesLister: listers.NewClusterWarmImageLister(cwis),
+				systemNamespace:         "warmimage-system",
+				configStore:             config.NewStore(zap.NewNop().Sugar(), "warmimage-system", cfg),
+				enqueueAfter: func(key string, delay time.Duration) {
+					if key != test.node.Name {
+						t.Errorf("enqueueAfter(%q) for node %q", key, test.node.Name)
+					}
+					enqueued = append(enqueued, delay)
+				},
+				Logger: zap.NewNop().Sugar(),
+			}
+
+			if err := r.Reconcile(context.Background(), test.node.Name); err != nil {
+				t.Fatalf("Reconcile() = %v", err)
+			}
+
+			if got := len(kube.updated) > 0; got != test.wantUpdate {
+				t.Fatalf("updated %v, wanted an update %v", kube.updated, test.wantUpdate)
+			} else if got {
+				if got := kube.updated[0].Spec.Taints; !reflect.DeepEqual(got, test.wantTaints) {
+					t.Errorf("updated taints = %v, wanted %v", got, test.wantTaints)
+				}
+				if !hasTaint(test.node, testTaint) {
+					t.Error("Reconcile() modified the informer's copy of the node")
+				}
+			}
+
+			if got := len(enqueued) > 0; got != test.wantEnqueue {
+				t.Fatalf("enqueueAfter() = %v, wanted a call %v", enqueued, test.wantEnqueue)
+			} else if got {
+				// The node joined a minute ago.
+				timeout := cfg.StartupTaintTimeout
+				if delay := enqueued[0]; delay <= timeout-2*time.Minute || delay > timeout-time.Minute {
+					t.Errorf("enqueueAfter() delay = %v, wanted about %v", delay, timeout-time.Minute)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileDeletedNode(t *testing.T) {
+	kube := &fakeKubeClient{}
+	cfg := config.New("", "")
+	cfg.StartupTaint = testTaint
+	r := &Reconciler{
+		kubeclientset:           kube,
+		nodesLister:             corev1listers.NewNodeLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})),
+		warmimagesLister:        listers.NewWarmImageLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})),
+		clusterwarmimagesLister: listers.NewClusterWarmImageLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})),
+		configStore:             config.NewStore(zap.NewNop().Sugar(), "warmimage-system", cfg),
+		enqueueAfter: func(key string, delay time.Duration) {
+			t.Errorf("enqueueAfter(%q, %v), wanted no call", key, delay)
+		},
+		Logger: zap.NewNop().Sugar(),
+	}
+	if err := r.Reconcile(context.Background(), "the-node"); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+	if len(kube.updated) != 0 {
+		t.Errorf("updated = %v, wanted none", kube.updated)
+	}
+}
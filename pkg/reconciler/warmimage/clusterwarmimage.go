@@ -0,0 +1,487 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package warmimage
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/knative/pkg/controller"
+	"github.com/knative/pkg/logging/logkey"
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	appsv1informers "k8s.io/client-go/informers/apps/v1"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+	clientset "github.com/mattmoor/warm-image/pkg/client/clientset/versioned"
+	informers "github.com/mattmoor/warm-image/pkg/client/informers/externalversions/warmimage/v3"
+	"github.com/mattmoor/warm-image/pkg/metrics"
+	"github.com/mattmoor/warm-image/pkg/priorityclass"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/config"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/resources"
+	"github.com/mattmoor/warm-image/pkg/registry"
+)
+
+const (
+	clusterControllerAgentName = "clusterwarmimage-controller"
+
+	// clusterFinalizer is the finalizer through which we clean up after a
+	// ClusterWarmImage. Its DaemonSets live in the system namespace, and
+	// we don't rely on the garbage collector to delete them.
+	clusterFinalizer = "clusterwarmimages.mattmoor.io"
+)
+
+// ClusterReconciler is the controller implementation for ClusterWarmImage
+// resources
+type ClusterReconciler struct {
+	warmer
+
+	// warmimageclientset is a clientset for our own API group
+	warmimageclientset clientset.Interface
+
+	// enqueueAfter schedules the ClusterWarmImage with the given key to be
+	// reconciled again after a delay.
+	enqueueAfter func(key string, delay time.Duration)
+}
+
+// Check that we implement the controller.Reconciler interface.
+var _ controller.Reconciler = (*ClusterReconciler)(nil)
+
+// NewClusterController returns a new clusterwarmimage controller
+func NewClusterController(
+	logger *zap.SugaredLogger,
+	kubeclientset kubernetes.Interface,
+	warmimageclientset clientset.Interface,
+	daemonsetInformer appsv1informers.DaemonSetInformer,
+	podInformer corev1informers.PodInformer,
+	nodeInformer corev1informers.NodeInformer,
+	secretInformer corev1informers.SecretInformer,
+	priorityclassclient priorityclass.Interface,
+	priorityClassInformer priorityclass.Informer,
+	warmimageInformer informers.WarmImageInformer,
+	clusterwarmimageInformer informers.ClusterWarmImageInformer,
+	resolver registry.Resolver,
+	configStore *config.Store,
+	systemNamespace string,
+	agentNamespace string,
+	m *metrics.Metrics,
+) *controller.Impl {
+
+	// Enrich the logs with controller name
+	logger = logger.Named(clusterControllerAgentName).With(zap.String(logkey.ControllerType, clusterControllerAgentName))
+
+	r := &ClusterReconciler{
+		warmer: warmer{
+			kubeclientset:           kubeclientset,
+			daemonsetsLister:        daemonsetInformer.Lister(),
+			podsLister:              podInformer.Lister(),
+			nodesLister:             nodeInformer.Lister(),
+			secretsLister:           secretInformer.Lister(),
+			priorityclassclient:     priorityclassclient,
+			priorityClassesLister:   priorityClassInformer.Lister(),
+			warmimagesLister:        warmimageInformer.Lister(),
+			clusterwarmimagesLister: clusterwarmimageInformer.Lister(),
+			resolver:                resolver,
+			systemNamespace:         systemNamespace,
+			agentNamespace:          agentNamespace,
+			configStore:             configStore,
+			kind:                    "ClusterWarmImage",
+			recorder:                newRecorder(logger, kubeclientset, clusterControllerAgentName),
+			metrics:                 m,
+			Logger:                  logger,
+		},
+		warmimageclientset: warmimageclientset,
+	}
+	impl := controller.NewImpl(m.Reconciler("ClusterWarmImages", r), logger, "ClusterWarmImages")
+	if err := m.RegisterWorkQueue("ClusterWarmImages", impl.WorkQueue); err != nil {
+		logger.Errorw("Failed to export the work queue depth", zap.Error(err))
+	}
+	r.enqueueAfter = func(key string, delay time.Duration) {
+		impl.WorkQueue.AddAfter(key, delay)
+	}
+
+	logger.Info("Setting up event handlers")
+	resync := func() {
+		objs, err := clusterwarmimageInformer.Lister().List(labels.Everything())
+		if err != nil {
+			logger.Errorw("Failed to list ClusterWarmImages", zap.Error(err))
+			return
+		}
+		for _, obj := range objs {
+			impl.Enqueue(obj)
+		}
+	}
+	// Reconcile all of the ClusterWarmImages when a change to our configuration
+	// changes the warm pods.
+	configStore.OnChange(func(*config.Config) { resync() })
+
+	// Reconcile the ClusterWarmImages that target a node as soon as it joins, so
+	// that we warm their images onto it, all of them when the nodes' labels
+	// or taints change, so that we warm their images with a DaemonSet for
+	// each of the nodes' architectures, and those whose images the nodes
+	// report or whose node agents' reports change, so that we pick those up.
+	watchNodes(nodeInformer, func(node *corev1.Node) {
+		objs, err := clusterwarmimageInformer.Lister().List(labels.Everything())
+		if err != nil {
+			logger.Errorw("Failed to list ClusterWarmImages", zap.Error(err))
+			return
+		}
+		wis := make(map[string]*warmimagev3.WarmImage, len(objs))
+		for _, obj := range objs {
+			wis[obj.Name] = resources.MakeWarmImageView(obj, systemNamespace)
+		}
+		r.enqueueTargeting(impl.WorkQueue, node, wis)
+	}, func(nc *nodeChange) {
+		objs, err := clusterwarmimageInformer.Lister().List(labels.Everything())
+		if err != nil {
+			logger.Errorw("Failed to list ClusterWarmImages", zap.Error(err))
+			return
+		}
+		for _, obj := range objs {
+			if nc.affects(resources.MakeWarmImageView(obj, systemNamespace)) {
+				impl.Enqueue(obj)
+			}
+		}
+	}, resync)
+
+	// Recreate the PriorityClass of the warm pods of High priority images
+	// if it is deleted.
+	watchPriorityClass(priorityClassInformer, resync)
+
+	// Set up an event handler for when ClusterWarmImage resources change
+	clusterwarmimageInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    impl.Enqueue,
+		UpdateFunc: controller.PassNew(impl.Enqueue),
+	})
+
+	// Set up an event handler for when the warm pods change, so that we
+	// keep the per-node status of their ClusterWarmImage up to date.
+	podInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: r.inSystemNamespace,
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc:    r.enqueueClusterWarmImageOfPod(impl),
+			UpdateFunc: controller.PassNew(r.enqueueClusterWarmImageOfPod(impl)),
+			DeleteFunc: r.enqueueClusterWarmImageOfPod(impl),
+		},
+	})
+
+	// Set up an event handler for when the DaemonSets that we warm images
+	// with change, so that we recreate them when deleted and repair them
+	// when edited.
+	daemonsetInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: r.inSystemNamespace,
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc:    r.enqueueClusterWarmImageOfDaemonSet(impl),
+			UpdateFunc: controller.PassNew(r.enqueueClusterWarmImageOfDaemonSet(impl)),
+			DeleteFunc: r.enqueueClusterWarmImageOfDaemonSet(impl),
+		},
+	})
+
+	return impl
+}
+
+func (c *ClusterReconciler) inSystemNamespace(obj interface{}) bool {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	object, ok := obj.(metav1.Object)
+	return ok && object.GetNamespace() == c.systemNamespace
+}
+
+// enqueueClusterWarmImageOfPod returns a handler that enqueues the
+// ClusterWarmImage whose DaemonSet controls a warm pod, or whose one-shot pod
+// it is. These have no owner, so we find the ClusterWarmImage through their
+// labels.
+func (c *ClusterReconciler) enqueueClusterWarmImageOfPod(impl *controller.Impl) func(interface{}) {
+	return func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		pod, ok := obj.(*corev1.Pod)
+		if !ok {
+			return
+		}
+		owner := metav1.GetControllerOf(pod)
+		if owner == nil {
+			// A one-shot pod.
+			c.enqueueClusterWarmImageOf(impl, pod)
+			return
+		} else if owner.Kind != "DaemonSet" {
+			return
+		}
+		ds, err := c.daemonsetsLister.DaemonSets(pod.Namespace).Get(owner.Name)
+		if err != nil {
+			// The DaemonSet is gone, so there is nothing to report on.
+			return
+		}
+		c.enqueueClusterWarmImageOf(impl, ds)
+	}
+}
+
+// enqueueClusterWarmImageOfDaemonSet returns a handler that enqueues the
+// ClusterWarmImage that a DaemonSet warms images for.
+func (c *ClusterReconciler) enqueueClusterWarmImageOfDaemonSet(impl *controller.Impl) func(interface{}) {
+	return func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		if ds, ok := obj.(*appsv1.DaemonSet); ok {
+			c.enqueueClusterWarmImageOf(impl, ds)
+		}
+	}
+}
+
+// enqueueClusterWarmImageOf enqueues the ClusterWarmImage that the given
+// DaemonSet or one-shot pod warms images for, if any.
+func (c *ClusterReconciler) enqueueClusterWarmImageOf(impl *controller.Impl, obj metav1.Object) {
+	if metav1.GetControllerOf(obj) != nil {
+		// This belongs to a WarmImage.
+		return
+	}
+	uid := obj.GetLabels()["controller"]
+	cwis, err := c.clusterwarmimagesLister.List(labels.Everything())
+	if err != nil {
+		c.Logger.Errorw("Failed to list ClusterWarmImages", zap.Error(err))
+		return
+	}
+	for _, cwi := range cwis {
+		if string(cwi.UID) == uid {
+			impl.Enqueue(cwi)
+			return
+		}
+	}
+}
+
+// Reconcile implements controller.Reconciler
+func (c *ClusterReconciler) Reconcile(ctx context.Context, key string) error {
+	// ClusterWarmImages are cluster-scoped, so the key is just the name.
+	_, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("invalid resource key: %s", key))
+		return nil
+	}
+
+	// Get the ClusterWarmImage resource with this name
+	original, err := c.clusterwarmimagesLister.Get(name)
+	if errors.IsNotFound(err) {
+		// The ClusterWarmImage resource may no longer exist, in which case
+		// we stop processing. Our finalizer made sure we cleaned up.
+		runtime.HandleError(fmt.Errorf("clusterwarmimage '%s' in work queue no longer exists", key))
+		return nil
+	} else if err != nil {
+		return err
+	}
+	// Don't modify the informer's copy.
+	cwi := original.DeepCopy()
+
+	if cwi.DeletionTimestamp != nil {
+		return c.finalize(ctx, key, cwi)
+	}
+	if !sets.NewString(cwi.Finalizers...).Has(clusterFinalizer) {
+		// Make sure we get the chance to clean up before it is deleted.
+		cwi.Finalizers = append(cwi.Finalizers, clusterFinalizer)
+		if cwi, err = c.warmimageclientset.MattmoorV3().ClusterWarmImages().Update(cwi); err != nil {
+			return err
+		}
+		original = cwi.DeepCopy()
+	}
+
+	// Reconcile this copy of the ClusterWarmImage and then write back any
+	// status updates regardless of whether the reconciliation errored out.
+	err = c.reconcile(ctx, cwi)
+	view := &warmimagev3.WarmImage{
+		ObjectMeta: metav1.ObjectMeta{
+			Generation: cwi.Generation,
+		},
+		Spec: warmimagev3.WarmImageSpec{
+			RefreshInterval: cwi.Spec.RefreshInterval,
+			Rollout:         cwi.Spec.Rollout,
+		},
+		Status: cwi.Status,
+	}
+	if delay, ok := nextRefresh(view); ok {
+		c.enqueueAfter(key, delay)
+	}
+	if delay, ok := nextResolveRetry(view); ok {
+		c.enqueueAfter(key, delay)
+	}
+	if delay, ok := nextRolloutCheck(view); ok {
+		c.enqueueAfter(key, delay)
+	}
+	if reflect.DeepEqual(original.Status, cwi.Status) {
+		// If we didn't change anything then don't call updateStatus.
+	} else if _, uErr := c.updateStatus(cwi); uErr != nil {
+		c.Logger.Warnw("Failed to update clusterwarmimage status", zap.Error(uErr))
+		return uErr
+	}
+	return err
+}
+
+func (c *ClusterReconciler) reconcile(ctx context.Context, cwi *warmimagev3.ClusterWarmImage) error {
+	if err := c.reconcileSecrets(cwi); err != nil {
+		cwi.Status.MarkFailed("SecretsFailed", "Unable to copy image pull secrets: %v", err)
+		return err
+	}
+
+	// Warm the images as though a WarmImage in the system namespace asked
+	// for them, without an owner for the DaemonSet.
+	wi := resources.MakeWarmImageView(cwi, c.systemNamespace)
+	err := c.warm(ctx, wi, nil)
+	cwi.Status = wi.Status
+	return err
+}
+
+// reconcileSecrets copies the image pull secrets of the ClusterWarmImage into
+// the system namespace, where its WarmImage view refers to them.
+func (c *ClusterReconciler) reconcileSecrets(cwi *warmimagev3.ClusterWarmImage) error {
+	copies := sets.NewString()
+	for _, ref := range cwi.Spec.ImagePullSecrets {
+		if ref.Namespace == "" || ref.Namespace == c.systemNamespace {
+			// There's no need to copy these.
+			continue
+		}
+
+		secret, err := c.kubeclientset.CoreV1().Secrets(ref.Namespace).Get(ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		desired := resources.MakeSecret(cwi, c.systemNamespace, secret)
+		existing, err := c.kubeclientset.CoreV1().Secrets(c.systemNamespace).Get(desired.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			if _, err := c.kubeclientset.CoreV1().Secrets(c.systemNamespace).Create(desired); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		} else if !reflect.DeepEqual(existing.Data, desired.Data) {
+			existing = existing.DeepCopy()
+			existing.Data = desired.Data
+			if _, err := c.kubeclientset.CoreV1().Secrets(c.systemNamespace).Update(existing); err != nil {
+				return err
+			}
+		}
+		copies.Insert(desired.Name)
+	}
+
+	// Delete the copies of secrets that we no longer reference.
+	existing, err := c.kubeclientset.CoreV1().Secrets(c.systemNamespace).List(metav1.ListOptions{
+		LabelSelector: resources.MakeControllerLabelSelector(cwi.UID).String(),
+	})
+	if err != nil {
+		return err
+	}
+	for _, secret := range existing.Items {
+		if copies.Has(secret.Name) {
+			continue
+		}
+		err := c.kubeclientset.CoreV1().Secrets(c.systemNamespace).Delete(secret.Name, &metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// finalize deletes the resources of a ClusterWarmImage that is being
+// deleted, and then releases it. With a coolDown, it first removes the images
+// from the nodes, or gives up on that.
+func (c *ClusterReconciler) finalize(ctx context.Context, key string, cwi *warmimagev3.ClusterWarmImage) error {
+	if !sets.NewString(cwi.Finalizers...).Has(clusterFinalizer) {
+		return nil
+	}
+	// Its images are no longer kept warm.
+	if err := c.labelNodes(resources.ReadyLabel("", cwi.Name), nil); err != nil {
+		return err
+	}
+
+	if cwi.Spec.CoolDown != nil {
+		// The view shares the status, so tell the changes apart.
+		wi := resources.MakeWarmImageView(cwi.DeepCopy(), c.systemNamespace)
+		done, err := c.coolDown(ctx, wi)
+		if !reflect.DeepEqual(cwi.Status, wi.Status) {
+			cwi.Status = wi.Status
+			updated, uErr := c.updateStatus(cwi)
+			if uErr != nil {
+				c.Logger.Warnw("Failed to update clusterwarmimage status", zap.Error(uErr))
+				return uErr
+			}
+			cwi = updated
+		}
+		if err != nil {
+			return err
+		} else if !done {
+			if delay, ok := nextCoolDownCheck(wi); ok {
+				c.enqueueAfter(key, delay)
+			}
+			return nil
+		}
+	}
+
+	opts := metav1.ListOptions{
+		LabelSelector: resources.MakeControllerLabelSelector(cwi.UID).String(),
+	}
+	propPolicy := metav1.DeletePropagationForeground
+	err := c.kubeclientset.AppsV1().DaemonSets(c.systemNamespace).DeleteCollection(
+		&metav1.DeleteOptions{PropagationPolicy: &propPolicy}, opts)
+	if err != nil {
+		return err
+	}
+	oneShotOpts := metav1.ListOptions{
+		LabelSelector: resources.MakeOneShotLabelSelector(cwi.UID).String(),
+	}
+	if err := c.kubeclientset.CoreV1().Pods(c.systemNamespace).DeleteCollection(&metav1.DeleteOptions{}, oneShotOpts); err != nil {
+		return err
+	}
+	cleanupOpts := metav1.ListOptions{
+		LabelSelector: resources.MakeCleanupLabelSelector(cwi.UID).String(),
+	}
+	if err := c.kubeclientset.CoreV1().Pods(c.systemNamespace).DeleteCollection(&metav1.DeleteOptions{}, cleanupOpts); err != nil {
+		return err
+	}
+	if err := c.kubeclientset.CoreV1().Secrets(c.systemNamespace).DeleteCollection(&metav1.DeleteOptions{}, opts); err != nil {
+		return err
+	}
+	c.Logger.Infof("Cleaned up after ClusterWarmImage %q", cwi.Name)
+
+	finalizers := sets.NewString(cwi.Finalizers...)
+	finalizers.Delete(clusterFinalizer)
+	cwi.Finalizers = finalizers.List()
+	_, err = c.warmimageclientset.MattmoorV3().ClusterWarmImages().Update(cwi)
+	return err
+}
+
+func (c *ClusterReconciler) updateStatus(desired *warmimagev3.ClusterWarmImage) (*warmimagev3.ClusterWarmImage, error) {
+	cwi, err := c.clusterwarmimagesLister.Get(desired.Name)
+	if err != nil {
+		return nil, err
+	}
+	// Don't modify the informer's copy.
+	existing := cwi.DeepCopy()
+	existing.Status = desired.Status
+	return c.warmimageclientset.MattmoorV3().ClusterWarmImages().UpdateStatus(existing)
+}
@@ -0,0 +1,273 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// ConfigName is the name of the ConfigMap in the system namespace that
+	// configures the controller.
+	ConfigName = "config-warmimage"
+
+	sleeperImageKey        = "sleeper-image"
+	resourcesKey           = "resources"
+	priorityClassNameKey   = "priority-class-name"
+	tolerationsKey         = "tolerations"
+	nodeAgentImageKey      = "node-agent-image"
+	runtimeEndpointKey     = "runtime-endpoint"
+	ineligibleNodesKey     = "ineligible-nodes"
+	startupTaintKey        = "startup-taint"
+	startupTaintTimeoutKey = "startup-taint-timeout"
+
+	// DefaultRuntimeEndpoint is the socket of the container runtime's CRI
+	// image service on the nodes, unless configured otherwise.
+	DefaultRuntimeEndpoint = "unix:///run/containerd/containerd.sock"
+
+	// DefaultStartupTaintTimeout is how long after a node joins the cluster
+	// we remove its startup taint, whether or not the required images are
+	// warm on it, unless configured otherwise.
+	DefaultStartupTaintTimeout = 15 * time.Minute
+)
+
+// DefaultIneligibleNodes are the nodes that we don't warm images onto unless
+// configured otherwise: those that are cordoned, not ready, or low on disk,
+// where pulling more images only makes things worse.
+var DefaultIneligibleNodes = []string{
+	IneligibleUnschedulable,
+	IneligibleNotReady,
+	string(corev1.NodeDiskPressure),
+}
+
+const (
+	// IneligibleUnschedulable makes the cordoned nodes ineligible.
+	IneligibleUnschedulable = "Unschedulable"
+
+	// IneligibleNotReady makes the nodes that aren't Ready ineligible. The
+	// other entries of IneligibleNodes are node conditions, such as
+	// DiskPressure, that make a node ineligible while they are true.
+	IneligibleNotReady = "NotReady"
+)
+
+// archSeparator separates the architecture from the key of an image that is
+// configured per architecture, e.g. sleeper-image.arm64.
+const archSeparator = "."
+
+// Config is the configuration of the warm pods, as read from the
+// config-warmimage ConfigMap. Its logging configuration is read through
+// github.com/knative/pkg/logging.
+type Config struct {
+	// SleeperImage is the image that drops the sleeper binary into the
+	// warm pods, see //cmd/sleeper.
+	SleeperImage string
+
+	// SleeperImages are the sleeper images for the nodes of particular
+	// architectures, by their kubernetes.io/arch label, which take
+	// precedence over SleeperImage.
+	SleeperImages map[string]string
+
+	// Resources are the resources of each container in the warm pods.
+	Resources corev1.ResourceRequirements
+
+	// PriorityClassName is the priority class of the warm pods, if any.
+	PriorityClassName string
+
+	// Tolerations are added to the tolerations of every WarmImage.
+	Tolerations []corev1.Toleration
+
+	// NodeAgentImage is the image of the node agent, see //cmd/nodeagent,
+	// which the cleanup pods run to remove images from the nodes.
+	NodeAgentImage string
+
+	// NodeAgentImages are the node agent images for the nodes of particular
+	// architectures, which take precedence over NodeAgentImage.
+	NodeAgentImages map[string]string
+
+	// RuntimeEndpoint is the socket of the container runtime's CRI image
+	// service on the nodes, through which the cleanup pods remove images.
+	RuntimeEndpoint string
+
+	// IneligibleNodes are the states of the nodes that we don't warm images
+	// onto: IneligibleUnschedulable, IneligibleNotReady, or the types of
+	// node conditions that make a node ineligible while they are true.
+	IneligibleNodes []string
+
+	// StartupTaint is the key of the taint with which the nodes join the
+	// cluster, if any, which the warm pods tolerate, and which we remove
+	// from a node once the images of the Required WarmImages are warm on
+	// it.
+	StartupTaint string
+
+	// StartupTaintTimeout is how long after a node joins the cluster we
+	// remove its startup taint anyway, so that a WarmImage that can't warm
+	// doesn't keep the node from ever taking workloads. Zero disables it.
+	StartupTaintTimeout time.Duration
+}
+
+// New returns the configuration to use until the ConfigMap is read, with the
+// given sleeper and node agent images.
+func New(sleeperImage, nodeAgentImage string) *Config {
+	return &Config{
+		SleeperImage:        sleeperImage,
+		NodeAgentImage:      nodeAgentImage,
+		RuntimeEndpoint:     DefaultRuntimeEndpoint,
+		IneligibleNodes:     DefaultIneligibleNodes,
+		StartupTaintTimeout: DefaultStartupTaintTimeout,
+		Resources: corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1m"),
+				corev1.ResourceMemory: resource.MustParse("20M"),
+			},
+		},
+	}
+}
+
+// NewConfigFromMap creates a Config from the supplied map, using the fields
+// of the given defaults for the keys that it lacks.
+func NewConfigFromMap(data map[string]string, defaults *Config) (*Config, error) {
+	c := defaults.DeepCopy()
+	if v, ok := data[sleeperImageKey]; ok && v != "" {
+		c.SleeperImage = v
+	}
+	if v, ok := data[resourcesKey]; ok {
+		var r corev1.ResourceRequirements
+		if err := yaml.Unmarshal([]byte(v), &r); err != nil {
+			return nil, fmt.Errorf("failed to parse %q: %v", resourcesKey, err)
+		}
+		c.Resources = r
+	}
+	if v, ok := data[priorityClassNameKey]; ok {
+		c.PriorityClassName = v
+	}
+	if v, ok := data[tolerationsKey]; ok {
+		var t []corev1.Toleration
+		if err := yaml.Unmarshal([]byte(v), &t); err != nil {
+			return nil, fmt.Errorf("failed to parse %q: %v", tolerationsKey, err)
+		}
+		c.Tolerations = t
+	}
+	if v, ok := data[nodeAgentImageKey]; ok && v != "" {
+		c.NodeAgentImage = v
+	}
+	if v, ok := data[runtimeEndpointKey]; ok && v != "" {
+		c.RuntimeEndpoint = v
+	}
+	if v, ok := data[ineligibleNodesKey]; ok {
+		c.IneligibleNodes = []string{}
+		for _, state := range strings.Split(v, ",") {
+			if state = strings.TrimSpace(state); state != "" {
+				c.IneligibleNodes = append(c.IneligibleNodes, state)
+			}
+		}
+	}
+	if v, ok := data[startupTaintKey]; ok {
+		c.StartupTaint = strings.TrimSpace(v)
+	}
+	if v, ok := data[startupTaintTimeoutKey]; ok && v != "" {
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %q: %v", startupTaintTimeoutKey, err)
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("%q must not be negative, got %v", startupTaintTimeoutKey, d)
+		}
+		c.StartupTaintTimeout = d
+	}
+	c.SleeperImages = imagesByArch(data, sleeperImageKey, c.SleeperImages)
+	c.NodeAgentImages = imagesByArch(data, nodeAgentImageKey, c.NodeAgentImages)
+	return c, nil
+}
+
+// imagesByArch returns the images configured per architecture under keys of
+// the form <key>.<arch>, which replace the given defaults if there are any.
+func imagesByArch(data map[string]string, key string, defaults map[string]string) map[string]string {
+	var images map[string]string
+	for k, v := range data {
+		arch := strings.TrimPrefix(k, key+archSeparator)
+		if arch == k || arch == "" || v == "" {
+			continue
+		}
+		if images == nil {
+			images = make(map[string]string)
+		}
+		images[arch] = v
+	}
+	if images == nil {
+		return defaults
+	}
+	return images
+}
+
+// SleeperImageFor returns the sleeper image for the nodes of the given
+// architecture.
+func (c *Config) SleeperImageFor(arch string) string {
+	if image, ok := c.SleeperImages[arch]; ok {
+		return image
+	}
+	return c.SleeperImage
+}
+
+// NodeAgentImageFor returns the node agent image for the nodes of the given
+// architecture.
+func (c *Config) NodeAgentImageFor(arch string) string {
+	if image, ok := c.NodeAgentImages[arch]; ok {
+		return image
+	}
+	return c.NodeAgentImage
+}
+
+// NewConfigFromConfigMap creates a Config from the supplied ConfigMap, using
+// the fields of the given defaults for the keys that it lacks.
+func NewConfigFromConfigMap(configMap *corev1.ConfigMap, defaults *Config) (*Config, error) {
+	return NewConfigFromMap(configMap.Data, defaults)
+}
+
+// DeepCopy returns a deep copy of the Config.
+func (c *Config) DeepCopy() *Config {
+	out := *c
+	c.Resources.DeepCopyInto(&out.Resources)
+	out.SleeperImages = copyImages(c.SleeperImages)
+	out.NodeAgentImages = copyImages(c.NodeAgentImages)
+	if c.IneligibleNodes != nil {
+		out.IneligibleNodes = append([]string{}, c.IneligibleNodes...)
+	}
+	if c.Tolerations != nil {
+		out.Tolerations = make([]corev1.Toleration, len(c.Tolerations))
+		for i := range c.Tolerations {
+			c.Tolerations[i].DeepCopyInto(&out.Tolerations[i])
+		}
+	}
+	return &out
+}
+
+func copyImages(images map[string]string) map[string]string {
+	if images == nil {
+		return nil
+	}
+	out := make(map[string]string, len(images))
+	for k, v := range images {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/knative/pkg/controller"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Store holds the current Config, which it keeps up to date with the
+// config-warmimage ConfigMap in the system namespace once it watches it. Every
+// controller in a process shares the one Store.
+type Store struct {
+	logger    *zap.SugaredLogger
+	namespace string
+	defaults  *Config
+
+	// mu guards config and onChange.
+	mu       sync.RWMutex
+	config   *Config
+	onChange []func(*Config)
+}
+
+// NewStore returns a Store of the config-warmimage ConfigMap in the given
+// namespace, which holds the given defaults until that is read, and over
+// which its keys apply.
+func NewStore(logger *zap.SugaredLogger, namespace string, defaults *Config) *Store {
+	return &Store{
+		logger:    logger,
+		namespace: namespace,
+		defaults:  defaults,
+		config:    defaults,
+	}
+}
+
+// Get returns the current Config, which callers must not modify.
+func (s *Store) Get() *Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config
+}
+
+// OnChange adds a function to call with the new Config when it changes,
+// e.g. to reconcile everything that it configures.
+func (s *Store) OnChange(f func(*Config)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = append(s.onChange, f)
+}
+
+// Watch keeps the Store up to date with the ConfigMap, as the given informer
+// sees it.
+func (s *Store) Watch(configMapInformer corev1informers.ConfigMapInformer) {
+	configMapInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    s.update,
+		UpdateFunc: controller.PassNew(s.update),
+	})
+}
+
+// update sets the Config from the ConfigMap, if that is what the given object
+// is, and calls the OnChange functions if that changed it.
+func (s *Store) update(obj interface{}) {
+	cm, ok := obj.(*corev1.ConfigMap)
+	if !ok || cm.Namespace != s.namespace || cm.Name != ConfigName {
+		return
+	}
+	cfg, err := NewConfigFromConfigMap(cm, s.defaults)
+	if err != nil {
+		s.logger.Errorw("Failed to parse the config map. Previous config will be used.", zap.Error(err))
+		return
+	}
+
+	s.mu.Lock()
+	changed := !reflect.DeepEqual(s.config, cfg)
+	s.config = cfg
+	onChange := s.onChange
+	s.mu.Unlock()
+	if !changed {
+		return
+	}
+	s.logger.Infof("Configuration changed to %+v", *cfg)
+	for _, f := range onChange {
+		f(cfg)
+	}
+}
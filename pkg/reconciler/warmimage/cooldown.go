@@ -0,0 +1,215 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package warmimage
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/resources"
+)
+
+// defaultCoolDownTimeout is how long we keep trying to remove the images of
+// a deleted WarmImage from its nodes.
+const defaultCoolDownTimeout = 10 * time.Minute
+
+// coolDownDeadline returns when we give up on removing the images of the
+// WarmImage, which is being deleted, from its nodes.
+func coolDownDeadline(wi *warmimagev3.WarmImage) metav1.Time {
+	timeout := defaultCoolDownTimeout
+	if t := wi.Spec.CoolDown.Timeout; t != nil {
+		timeout = t.Duration
+	}
+	return metav1.NewTime(wi.DeletionTimestamp.Add(timeout))
+}
+
+// nextCoolDownCheck returns how long until we give up on removing the images
+// of the WarmImage from its nodes, if it is being deleted with a cool-down.
+func nextCoolDownCheck(wi *warmimagev3.WarmImage) (time.Duration, bool) {
+	if wi.DeletionTimestamp == nil || wi.Spec.CoolDown == nil {
+		return 0, false
+	}
+	return coolDownDeadline(wi).Sub(time.Now()), true
+}
+
+// coolDown removes the images of the WarmImage, which is being deleted, from
+// the targeted nodes, through the node agents or with a cleanup pod on each
+// node in the system namespace. It returns whether it is done: either the
+// images are gone, or we gave up on them.
+func (c *warmer) coolDown(ctx context.Context, wi *warmimagev3.WarmImage) (bool, error) {
+	nodes, err := c.targetedNodes(wi)
+	if err != nil {
+		return false, err
+	}
+	var removed int32
+	if wi.Spec.Strategy == warmimagev3.WarmImageStrategyNodeAgent {
+		// The node agents remove the images once they see the deletion.
+		for _, node := range nodes {
+			if report, ok := resources.GetAgentReport(wi, node); ok && report.Removed {
+				removed++
+			}
+		}
+	} else {
+		if c.configStore.Get().NodeAgentImage == "" {
+			c.eventf(wi, corev1.EventTypeWarning, "CoolDownFailed", "No node agent image is configured to remove the images with")
+			return true, nil
+		}
+		removed, err = c.reconcileCleanupPods(wi, nodes)
+		if err != nil {
+			wi.Status.MarkFailed("PodsFailed", "Unable to reconcile cleanup pods: %v", err)
+			c.eventf(wi, corev1.EventTypeWarning, "PodsFailed", "Unable to reconcile cleanup pods: %v", err)
+			return false, err
+		}
+	}
+
+	desired, deadline := int32(len(nodes)), coolDownDeadline(wi)
+	wi.Status.MarkCoolingDown(desired, removed, deadline)
+	switch {
+	case removed == desired:
+		c.Logger.Infof("Removed %q from %d nodes", wi.Spec.Images, desired)
+		c.eventf(wi, corev1.EventTypeNormal, "CooledDown", "Removed the images from all %d nodes", desired)
+		return true, nil
+	case !time.Now().Before(deadline.Time):
+		c.Logger.Warnf("Gave up removing %q from %d of %d nodes", wi.Spec.Images, desired-removed, desired)
+		c.eventf(wi, corev1.EventTypeWarning, "CoolDownTimedOut", "Gave up removing the images from %d of %d nodes", desired-removed, desired)
+		return true, nil
+	}
+	return false, nil
+}
+
+// referencedImages returns the images, and the digests they resolved to, of
+// the WarmImages and ClusterWarmImages other than the given one that aren't
+// being deleted, which cooling it down must leave on the nodes.
+func (c *warmer) referencedImages(wi *warmimagev3.WarmImage) (sets.String, error) {
+	wis, err := c.warmimagesLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	cwis, err := c.clusterwarmimagesLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, cwi := range cwis {
+		wis = append(wis, resources.MakeWarmImageView(cwi, c.systemNamespace))
+	}
+	return resources.ReferencedImages(wis, wi.UID), nil
+}
+
+// reconcileCleanupPods removes the images from the given nodes with a cleanup
+// pod on each, once the warm pods that use the images are gone, and returns
+// the number of nodes the images were removed from. It leaves the images that
+// other WarmImages or ClusterWarmImages use on the nodes.
+func (c *warmer) reconcileCleanupPods(wi *warmimagev3.WarmImage, nodes []*corev1.Node) (int32, error) {
+	// The container runtime won't remove the images while the warm pods
+	// use them.
+	if err := c.deleteDaemonSets(wi); err != nil {
+		return 0, err
+	}
+	if err := c.deleteOneShotPods(wi); err != nil {
+		return 0, err
+	}
+	pods, err := c.podsLister.Pods(wi.Namespace).List(resources.MakeControllerLabelSelector(wi.UID))
+	if err != nil {
+		return 0, err
+	}
+	for _, pod := range pods {
+		if pod.Labels["cleanup"] == "" {
+			// Our informer will tell us when it is gone.
+			return 0, nil
+		}
+	}
+
+	referenced, err := c.referencedImages(wi)
+	if err != nil {
+		return 0, err
+	}
+	images := resources.RemovableImages(wi, referenced)
+	if len(images) == 0 {
+		// There is nothing that we may remove.
+		return int32(len(nodes)), nil
+	}
+
+	pods, err = c.podsLister.Pods(c.systemNamespace).List(resources.MakeCleanupLabelSelector(wi.UID))
+	if err != nil {
+		return 0, err
+	}
+	cleaning := make(map[string]bool, len(pods))
+	cleaned := make(map[string]bool, len(pods))
+	for _, pod := range pods {
+		switch {
+		case pod.DeletionTimestamp != nil:
+			// Its replacement has the same name, so we create it once
+			// this one is gone.
+			cleaning[pod.Spec.NodeName] = true
+		case pod.Status.Phase == corev1.PodFailed:
+			// Start over, e.g. if it was evicted.
+			err := c.kubeclientset.CoreV1().Pods(c.systemNamespace).Delete(pod.Name, &metav1.DeleteOptions{})
+			if err != nil && !errors.IsNotFound(err) {
+				return 0, err
+			}
+			cleaning[pod.Spec.NodeName] = true
+		case pod.Status.Phase == corev1.PodSucceeded:
+			cleaning[pod.Spec.NodeName] = true
+			cleaned[pod.Spec.NodeName] = true
+		default:
+			cleaning[pod.Spec.NodeName] = true
+		}
+	}
+
+	var removed int32
+	var created int
+	cfg := c.configStore.Get()
+	for _, node := range nodes {
+		if cleaned[node.Name] {
+			removed++
+		}
+		if cleaning[node.Name] {
+			continue
+		}
+		_, arch := resources.NodePlatform(node)
+		pod := resources.MakeCleanupPod(wi, images, node.Name, arch, c.systemNamespace, cfg)
+		_, err := c.kubeclientset.CoreV1().Pods(c.systemNamespace).Create(pod)
+		if errors.IsAlreadyExists(err) {
+			// Our informer cache is stale.
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		created++
+	}
+	if created > 0 {
+		c.Logger.Infof("Removing %q from %d nodes", images, created)
+		c.eventf(wi, corev1.EventTypeNormal, "Created", "Created %d cleanup pods to remove the images from the nodes", created)
+	}
+	return removed, nil
+}
+
+// deleteCleanupPods deletes the pods that removed the WarmImage's images from
+// the nodes, which have no owner to be garbage collected with.
+func (c *warmer) deleteCleanupPods(wi *warmimagev3.WarmImage) error {
+	opts := metav1.ListOptions{
+		LabelSelector: resources.MakeCleanupLabelSelector(wi.UID).String(),
+	}
+	return c.kubeclientset.CoreV1().Pods(c.systemNamespace).DeleteCollection(&metav1.DeleteOptions{}, opts)
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package warmimage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/resources"
+	"github.com/mattmoor/warm-image/pkg/registry"
+)
+
+// refreshInterval returns how often the WarmImage's tags should be
+// re-resolved, if at all.
+func refreshInterval(wi *warmimagev3.WarmImage) (time.Duration, bool) {
+	if wi.Spec.RefreshInterval == nil || wi.Spec.RefreshInterval.Duration <= 0 {
+		return 0, false
+	}
+	if d := wi.Spec.RefreshInterval.Duration; d > minRefreshInterval {
+		return d, true
+	}
+	return minRefreshInterval, true
+}
+
+// nextRefresh returns how long until the WarmImage's tags are due to be
+// re-resolved, if they should be at all.
+func nextRefresh(wi *warmimagev3.WarmImage) (time.Duration, bool) {
+	interval, ok := refreshInterval(wi)
+	if !ok || wi.Status.LastResolvedTime.IsZero() {
+		return 0, false
+	}
+	return wi.Status.LastResolvedTime.Add(interval).Sub(time.Now()), true
+}
+
+const (
+	// minResolveRetry is how long we wait to resolve the images again after
+	// a first failure, which doubles with each consecutive failure.
+	minResolveRetry = 10 * time.Second
+
+	// maxResolveRetry caps how long we wait to resolve the images again
+	// when the WarmImage has no refreshInterval, which caps it otherwise.
+	maxResolveRetry = 5 * time.Minute
+)
+
+// nextResolveRetry returns how long until we may try again to resolve the
+// images of this generation of the WarmImage, if the last try failed, so that
+// a registry outage doesn't have every event about the WarmImage hit the
+// registry.
+func nextResolveRetry(wi *warmimagev3.WarmImage) (time.Duration, bool) {
+	f := wi.Status.ResolveFailure
+	if f == nil || f.Generation != wi.Generation {
+		return 0, false
+	}
+	limit := maxResolveRetry
+	if interval, ok := refreshInterval(wi); ok {
+		limit = interval
+	}
+	delay := minResolveRetry
+	for i := int32(1); i < f.Count && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	return f.Time.Add(delay).Sub(time.Now()), true
+}
+
+// reconcileDigests resolves the images' tags to digests, so that every node
+// warms the same images even if a tag moves in the middle of a rollout, and
+// records which platforms each digest supports. When the WarmImage has a
+// refreshInterval, this periodically re-resolves the tags, and a new digest
+// rolls a new DaemonSet.
+func (c *warmer) reconcileDigests(ctx context.Context, wi *warmimagev3.WarmImage) error {
+	if isResolved(wi) && hasPlatforms(wi) && wi.Status.ObservedGeneration == wi.Generation {
+		// We have already resolved this generation of the spec, so only
+		// re-resolve it if it is due for a refresh.
+		if delay, ok := nextRefresh(wi); !ok || delay > 0 {
+			return nil
+		}
+	}
+
+	// Resolve the tags with the credentials that the warm pods pull with.
+	secrets, err := registry.PullSecrets(c.kubeclientset, wi.Namespace, wi.Spec.ImagePullSecrets, wi.Spec.ServiceAccountName, c.Logger)
+	if err != nil {
+		return err
+	}
+	kc, err := registry.NewKeychain(secrets...)
+	if err != nil {
+		return err
+	}
+
+	for _, image := range wi.Spec.Images {
+		digest, err := c.resolver.Resolve(image, kc)
+		if err != nil {
+			return fmt.Errorf("resolving %q: %v", image, err)
+		}
+		is := wi.Status.GetImage(image)
+		platforms := is.Platforms
+		if is.Digest != digest || len(platforms) == 0 {
+			ref, err := registry.ParseReference(image)
+			if err != nil {
+				return err
+			}
+			platforms, err = c.resolver.Platforms(ref.WithDigest(digest), kc)
+			if err != nil {
+				return fmt.Errorf("finding the platforms of %q: %v", image, err)
+			}
+		}
+		switch old := is.Digest; {
+		case old == "":
+			c.Logger.Infof("Resolved %q to %q", image, digest)
+			c.eventf(wi, corev1.EventTypeNormal, "Resolved", "Resolved %q to %q", image, digest)
+		case old != digest:
+			c.Logger.Infof("Resolved %q to %q, which was %q", image, digest, old)
+			c.eventf(wi, corev1.EventTypeNormal, "DigestChanged", "Resolved %q to %q, which was %q", image, digest, old)
+		}
+		wi.Status.MarkResolved(image, digest, platforms)
+	}
+	wi.Status.ResolveFailure = nil
+	return nil
+}
+
+// isResolved returns whether we know the digest of each of the images.
+func isResolved(wi *warmimagev3.WarmImage) bool {
+	for _, image := range wi.Spec.Images {
+		if is := wi.Status.GetImage(image); is == nil || is.Digest == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// hasPlatforms returns whether we know which platforms each of the images
+// supports, which we didn't record for the images resolved by older releases.
+func hasPlatforms(wi *warmimagev3.WarmImage) bool {
+	_, ok := resources.SupportedPlatforms(wi)
+	return ok
+}
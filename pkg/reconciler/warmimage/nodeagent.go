@@ -0,0 +1,128 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package warmimage
+
+import (
+	"context"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/resources"
+	"github.com/mattmoor/warm-image/pkg/registry"
+)
+
+// delegate leaves pulling the images onto the eligible targeted nodes to the
+// node agents, see //cmd/nodeagent, and reports on their progress from what they
+// report on the nodes.
+func (c *warmer) delegate(ctx context.Context, wi *warmimagev3.WarmImage, owner *metav1.OwnerReference, images map[string]*nodeImages) error {
+	// Remove the pods from before the node agents took over.
+	if err := c.deleteDaemonSets(wi); err != nil {
+		wi.Status.MarkFailed("DaemonSetFailed", "Unable to delete DaemonSets: %v", err)
+		c.eventf(wi, corev1.EventTypeWarning, "DaemonSetFailed", "Unable to delete DaemonSets: %v", err)
+		return err
+	}
+	if err := c.deleteOneShotPods(wi); err != nil {
+		wi.Status.MarkFailed("PodsFailed", "Unable to delete one-shot pods: %v", err)
+		c.eventf(wi, corev1.EventTypeWarning, "PodsFailed", "Unable to delete one-shot pods: %v", err)
+		return err
+	}
+	wi.Status.MarkRolledOut()
+	if err := c.reconcileAgentSecret(wi); err != nil {
+		wi.Status.MarkFailed("SecretsFailed", "Unable to reconcile the node agents' secret: %v", err)
+		c.eventf(wi, corev1.EventTypeWarning, "SecretsFailed", "Unable to reconcile the node agents' secret: %v", err)
+		return err
+	}
+
+	nodes, _, err := c.eligibleNodes(wi)
+	if err != nil {
+		return err
+	}
+	var statuses []warmimagev3.WarmImageNodeStatus
+	ready := make([]int32, len(wi.Spec.Images))
+	var completed int32
+	for _, node := range nodes {
+		ns, pulled := makeAgentNodeStatus(wi, node)
+		statuses = append(statuses, ns)
+		for i := 0; i < pulled; i++ {
+			ready[i]++
+		}
+		if pulled == len(wi.Spec.Images) {
+			completed++
+		}
+	}
+	wi.Status.PropagateCompletions(int32(len(statuses)), completed)
+	if err := c.propagateNodeStatuses(wi, statuses); err != nil {
+		return err
+	}
+	for i, image := range wi.Spec.Images {
+		wi.Status.GetImage(image).ReadyNodes = ready[i]
+	}
+	return nil
+}
+
+// reconcileAgentSecret hands the node agents the credentials to pull the
+// WarmImage's images with, through a secret in the agent namespace, so that
+// they need not read the image pull secrets of every namespace.
+func (c *warmer) reconcileAgentSecret(wi *warmimagev3.WarmImage) error {
+	secrets, err := registry.PullSecrets(c.kubeclientset, wi.Namespace, wi.Spec.ImagePullSecrets, wi.Spec.ServiceAccountName, c.Logger)
+	if err != nil {
+		return err
+	}
+	kc, err := registry.NewKeychain(secrets...)
+	if err != nil {
+		return err
+	}
+	desired, err := resources.MakeAgentSecret(wi, c.agentNamespace, kc)
+	if err != nil {
+		return err
+	}
+
+	existing, err := c.secretsLister.Secrets(c.agentNamespace).Get(desired.Name)
+	if errors.IsNotFound(err) {
+		if _, err := c.kubeclientset.CoreV1().Secrets(c.agentNamespace).Create(desired); err != nil {
+			return err
+		}
+		c.Logger.Infof("Created the node agents' secret %q", desired.Name)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if reflect.DeepEqual(existing.Data, desired.Data) {
+		return nil
+	}
+	existing = existing.DeepCopy()
+	existing.Data = desired.Data
+	if _, err := c.kubeclientset.CoreV1().Secrets(c.agentNamespace).Update(existing); err != nil {
+		return err
+	}
+	c.Logger.Infof("Updated the node agents' secret %q", desired.Name)
+	return nil
+}
+
+// deleteAgentSecret deletes the secret with which the node agents pull the
+// WarmImage's images, if any.
+func (c *warmer) deleteAgentSecret(wi *warmimagev3.WarmImage) error {
+	err := c.kubeclientset.CoreV1().Secrets(c.agentNamespace).Delete(resources.MakeAgentSecretName(wi), &metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
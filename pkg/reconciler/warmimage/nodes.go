@@ -0,0 +1,270 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package warmimage
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/resources"
+)
+
+// targetedNodes returns the nodes that the WarmImage's images belong on.
+func (c *warmer) targetedNodes(wi *warmimagev3.WarmImage) ([]*corev1.Node, error) {
+	nodes, err := c.nodesLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	cfg := c.configStore.Get()
+	var targeted []*corev1.Node
+	for _, node := range nodes {
+		if resources.TargetsNode(wi, node, cfg) {
+			targeted = append(targeted, node)
+		}
+	}
+	return targeted, nil
+}
+
+// eligibleNodes returns the targeted nodes that are eligible for warming the
+// WarmImage's images onto, and the names of the ineligible ones by the reason
+// why.
+func (c *warmer) eligibleNodes(wi *warmimagev3.WarmImage) ([]*corev1.Node, map[string][]string, error) {
+	nodes, err := c.targetedNodes(wi)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg := c.configStore.Get()
+	var eligible []*corev1.Node
+	excluded := make(map[string][]string)
+	for _, node := range nodes {
+		if reason := resources.IneligibleReason(node, cfg); reason != "" {
+			excluded[reason] = append(excluded[reason], node.Name)
+		} else {
+			eligible = append(eligible, node)
+		}
+	}
+	return eligible, excluded, nil
+}
+
+// reconcileEligibility records the targeted nodes that are ineligible for
+// warming, which we leave out of the nodes that should have the images warm.
+func (c *warmer) reconcileEligibility(wi *warmimagev3.WarmImage) error {
+	_, excluded, err := c.eligibleNodes(wi)
+	if err != nil {
+		return err
+	}
+	wi.Status.PropagateExclusions(excluded)
+	return nil
+}
+
+// propagatePods records the warm state of the images on each node, and the
+// number of nodes on which each image is warm, from the warm pods and the
+// images that their nodes report.
+func (c *warmer) propagatePods(wi *warmimagev3.WarmImage, pods []*corev1.Pod, images map[string]*nodeImages) error {
+	if err := c.propagateNodeStatuses(wi, makeNodeStatuses(wi, pods, images)); err != nil {
+		return err
+	}
+	for i, image := range wi.Spec.Images {
+		wi.Status.GetImage(image).ReadyNodes = countReady(pods, resources.UserContainerName(i))
+	}
+	return nil
+}
+
+// propagateNodeStatuses records the warm state of the images on each node,
+// and how long the images took to warm onto the nodes that joined the
+// cluster since the WarmImage was created, and marks the nodes on which the
+// images are warm with the WarmImage's ready label.
+func (c *warmer) propagateNodeStatuses(wi *warmimagev3.WarmImage, statuses []warmimagev3.WarmImageNodeStatus) error {
+	nodes, _, err := c.eligibleNodes(wi)
+	if err != nil {
+		return err
+	}
+	joined := make(map[string]metav1.Time)
+	for _, node := range nodes {
+		if node.CreationTimestamp.After(wi.CreationTimestamp.Time) {
+			joined[node.Name] = node.CreationTimestamp
+		}
+	}
+	warm := make(map[string]bool, len(statuses))
+	for _, ns := range statuses {
+		if ns.Reason == "" {
+			warm[ns.NodeName] = true
+		}
+	}
+
+	// The status marks the nodes whose time to warm we record, so that we
+	// record it once per node, even if we restart or lose our lease.
+	now := time.Now()
+	for _, nn := range wi.Status.PropagateNewNodes(joined, warm, metav1.NewTime(now.Add(-newNodeWindow)), metav1.NewTime(now)) {
+		c.metrics.ObserveNodeTimeToWarm(c.kind, string(priorityOf(wi)), nn.TimeToWarm.Duration)
+	}
+	wi.Status.PropagateNodeStatuses(statuses)
+	return c.labelNodes(c.readyLabel(wi), warm)
+}
+
+// makeNodeStatuses determines the warm state of the images on each node
+// from the warm pods scheduled there, and the images that the nodes report.
+func makeNodeStatuses(wi *warmimagev3.WarmImage, pods []*corev1.Pod, images map[string]*nodeImages) []warmimagev3.WarmImageNodeStatus {
+	nodes := make([]warmimagev3.WarmImageNodeStatus, 0, len(pods))
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" {
+			// We only report on nodes, so skip pods that haven't landed.
+			continue
+		}
+		ns := makeNodeStatus(wi, pod)
+		if ni, ok := images[pod.Spec.NodeName]; ok && ns.Reason == "" {
+			ns.Reason, ns.Image = findMissingImage(wi, pod, ni)
+		}
+		nodes = append(nodes, ns)
+	}
+	return nodes
+}
+
+// findMissingImage returns ImageMissing and the first image whose container
+// is ready in the warm pod, but which the node doesn't report having, if
+// any. This happens when something removes the images from under the warm
+// pods.
+func findMissingImage(wi *warmimagev3.WarmImage, pod *corev1.Pod, ni *nodeImages) (string, string) {
+	if pod.Status.Phase != corev1.PodRunning {
+		return "", ""
+	}
+	for i, image := range wi.Spec.Images {
+		cs := findContainerStatus(pod.Status.ContainerStatuses, resources.UserContainerName(i))
+		if cs != nil && cs.Ready && ni.isMissing(resources.UserImage(wi, image)) {
+			return "ImageMissing", image
+		}
+	}
+	return "", ""
+}
+
+func makeNodeStatus(wi *warmimagev3.WarmImage, pod *corev1.Pod) warmimagev3.WarmImageNodeStatus {
+	ns := warmimagev3.WarmImageNodeStatus{
+		NodeName: pod.Spec.NodeName,
+		Phase:    pod.Status.Phase,
+	}
+	if pod.Status.Phase == corev1.PodSucceeded {
+		// A one-shot pod that has pulled the images.
+		return ns
+	}
+	// Report on the first image that isn't warm.
+	for i, image := range wi.Spec.Images {
+		cs := findContainerStatus(pod.Status.ContainerStatuses, resources.UserContainerName(i))
+		if cs == nil || cs.Ready {
+			continue
+		}
+		if reason := containerReason(cs); reason != "" {
+			ns.Reason, ns.Image = reason, image
+			break
+		}
+	}
+	if ns.Reason == "" || ns.Reason == "PodInitializing" {
+		// If the images are waiting on the sleeper, then surface any
+		// problem that the sleeper is having.
+		if cs := findContainerStatus(pod.Status.InitContainerStatuses, resources.SleeperContainerName); cs != nil {
+			if reason := containerReason(cs); reason != "" {
+				ns.Reason, ns.Image = reason, ""
+			}
+		}
+	}
+	if ns.Reason == "" {
+		for _, cond := range pod.Status.Conditions {
+			if cond.Status != corev1.ConditionTrue && cond.Reason != "" {
+				ns.Reason = cond.Reason
+				break
+			}
+		}
+	}
+	if ns.Reason == "" && pod.Status.Phase != corev1.PodRunning {
+		ns.Reason = string(pod.Status.Phase)
+	}
+	return ns
+}
+
+// makeAgentNodeStatus determines the warm state of the images on the node
+// from the node agent's report, and how many of the images it has pulled. The
+// agent pulls the images in order, so these are the first ones.
+func makeAgentNodeStatus(wi *warmimagev3.WarmImage, node *corev1.Node) (warmimagev3.WarmImageNodeStatus, int) {
+	ns := warmimagev3.WarmImageNodeStatus{
+		NodeName: node.Name,
+	}
+	report, ok := resources.GetAgentReport(wi, node)
+	switch {
+	case !ok:
+		// The agent hasn't got to this version yet.
+		ns.Reason = "Pulling"
+		return ns, 0
+	case report.Reason == "":
+		return ns, len(wi.Spec.Images)
+	}
+	ns.Reason, ns.Image = report.Reason, report.Image
+	for i, image := range wi.Spec.Images {
+		if image == report.Image {
+			return ns, i
+		}
+	}
+	return ns, 0
+}
+
+// countReady counts the pods in which the named container is ready, or for
+// one-shot pods, has run to completion.
+func countReady(pods []*corev1.Pod, container string) int32 {
+	var ready int32
+	for _, pod := range pods {
+		cs := findContainerStatus(pod.Status.ContainerStatuses, container)
+		if cs == nil {
+			continue
+		}
+		if cs.Ready || (cs.State.Terminated != nil && cs.State.Terminated.ExitCode == 0) {
+			ready++
+		}
+	}
+	return ready
+}
+
+// countCompleted counts the one-shot pods that have pulled the images.
+func countCompleted(pods []*corev1.Pod) int32 {
+	var completed int32
+	for _, pod := range pods {
+		if pod.Status.Phase == corev1.PodSucceeded {
+			completed++
+		}
+	}
+	return completed
+}
+
+func findContainerStatus(statuses []corev1.ContainerStatus, name string) *corev1.ContainerStatus {
+	for i := range statuses {
+		if statuses[i].Name == name {
+			return &statuses[i]
+		}
+	}
+	return nil
+}
+
+func containerReason(cs *corev1.ContainerStatus) string {
+	switch {
+	case cs.State.Waiting != nil:
+		return cs.State.Waiting.Reason
+	case cs.State.Terminated != nil && cs.State.Terminated.ExitCode != 0:
+		return cs.State.Terminated.Reason
+	}
+	return ""
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package warmimage
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/resources"
+)
+
+// pullOnce pulls the images onto every targeted node once, with one-shot pods,
+// and reports on the pods of the current version.
+func (c *warmer) pullOnce(ctx context.Context, wi *warmimagev3.WarmImage, owner *metav1.OwnerReference, images map[string]*nodeImages) error {
+	pods, desired, err := c.reconcileOneShotPods(ctx, wi, owner)
+	if err != nil {
+		wi.Status.MarkFailed("PodsFailed", "Unable to reconcile one-shot pods: %v", err)
+		c.eventf(wi, corev1.EventTypeWarning, "PodsFailed", "Unable to reconcile one-shot pods: %v", err)
+		return err
+	}
+	wi.Status.PropagateCompletions(desired, countCompleted(pods))
+	return c.propagatePods(wi, pods, images)
+}
+
+// reconcileOneShotPods pulls the images onto each of the eligible targeted
+// nodes once, with a pod that exits as soon as it has. It returns the pods of
+// the current version on those nodes, and the number of those nodes.
+func (c *warmer) reconcileOneShotPods(ctx context.Context, wi *warmimagev3.WarmImage, owner *metav1.OwnerReference) ([]*corev1.Pod, int32, error) {
+	// Remove any DaemonSets from when the images were pinned.
+	if err := c.deleteDaemonSets(wi); err != nil {
+		return nil, 0, err
+	}
+
+	nodes, _, err := c.eligibleNodes(wi)
+	if err != nil {
+		return nil, 0, err
+	}
+	targeted := make(map[string]*corev1.Node, len(nodes))
+	for _, node := range nodes {
+		targeted[node.Name] = node
+	}
+
+	pods, err := c.podsLister.Pods(wi.Namespace).List(resources.MakeOneShotLabelSelector(wi.UID))
+	if err != nil {
+		return nil, 0, err
+	}
+	version := resources.MakeOneShotLabels(wi)["version"]
+	var current, stale []*corev1.Pod
+	pulled := make(map[string]bool, len(pods))
+	for _, pod := range pods {
+		switch {
+		case pod.DeletionTimestamp != nil:
+			// It is already on its way out.
+		case pod.Labels["version"] != version || targeted[pod.Spec.NodeName] == nil:
+			stale = append(stale, pod)
+		case pod.Status.Phase == corev1.PodFailed:
+			// Start over, e.g. if it was evicted before it pulled the
+			// images. Its replacement has the same name, so we create it
+			// once this one is gone.
+			stale = append(stale, pod)
+			pulled[pod.Spec.NodeName] = true
+		default:
+			current = append(current, pod)
+			pulled[pod.Spec.NodeName] = true
+		}
+	}
+
+	// Remove the pods of older versions, of nodes that we no longer target,
+	// and that failed.
+	for _, pod := range stale {
+		err := c.kubeclientset.CoreV1().Pods(wi.Namespace).Delete(pod.Name, &metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, 0, err
+		}
+	}
+	if len(stale) > 0 {
+		c.Logger.Infof("Deleted %d stale one-shot pods", len(stale))
+		c.eventf(wi, corev1.EventTypeNormal, "Deleted", "Deleted %d stale one-shot pods", len(stale))
+	}
+
+	// Pull the images onto the targeted nodes that we haven't pulled them
+	// onto yet, including those that joined since.
+	var created int
+	for name, node := range targeted {
+		if pulled[name] {
+			continue
+		}
+		// Drop the sleeper for the node's architecture into the pod.
+		_, arch := resources.NodePlatform(node)
+		pod := resources.MakeOneShotPod(wi, name, arch, owner, c.configStore.Get())
+		_, err := c.kubeclientset.CoreV1().Pods(wi.Namespace).Create(pod)
+		if errors.IsAlreadyExists(err) {
+			// Our informer cache is stale.
+			continue
+		} else if err != nil {
+			return nil, 0, err
+		}
+		created++
+	}
+	if created > 0 {
+		c.Logger.Infof("Pulling %q onto %d nodes", wi.Spec.Images, created)
+		c.eventf(wi, corev1.EventTypeNormal, "Created", "Created %d one-shot pods to pull the images onto new nodes", created)
+	}
+
+	wi.Status.MarkRolledOut()
+	return current, int32(len(targeted)), nil
+}
+
+// deleteOneShotPods removes the one-shot pods of the WarmImage, e.g. once
+// its images are pinned or pulled by the node agents instead.
+func (c *warmer) deleteOneShotPods(wi *warmimagev3.WarmImage) error {
+	selector := resources.MakeOneShotLabelSelector(wi.UID)
+	pods, err := c.podsLister.Pods(wi.Namespace).List(selector)
+	if err != nil || len(pods) == 0 {
+		return err
+	}
+	err = c.kubeclientset.CoreV1().Pods(wi.Namespace).DeleteCollection(
+		&metav1.DeleteOptions{},
+		metav1.ListOptions{LabelSelector: selector.String()},
+	)
+	if err != nil {
+		return err
+	}
+	c.eventf(wi, corev1.EventTypeNormal, "Deleted", "Deleted %d one-shot pods", len(pods))
+	return nil
+}
+
+// deleteDaemonSets removes the DaemonSets of every version of the WarmImage,
+// e.g. once its images are pulled by one-shot pods or the node agents instead.
+func (c *warmer) deleteDaemonSets(wi *warmimagev3.WarmImage) error {
+	selector := resources.MakeControllerLabelSelector(wi.UID)
+	dss, err := c.daemonsetsLister.DaemonSets(wi.Namespace).List(selector)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, ds := range dss {
+		if ds.DeletionTimestamp == nil {
+			names = append(names, ds.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	propPolicy := metav1.DeletePropagationForeground
+	err = c.kubeclientset.AppsV1().DaemonSets(wi.Namespace).DeleteCollection(
+		&metav1.DeleteOptions{PropagationPolicy: &propPolicy},
+		metav1.ListOptions{LabelSelector: selector.String()},
+	)
+	if err != nil {
+		return err
+	}
+	c.eventf(wi, corev1.EventTypeNormal, "Deleted", "Deleted the DaemonSets that pinned the images: %q", names)
+	return nil
+}
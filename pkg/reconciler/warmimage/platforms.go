@@ -0,0 +1,127 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package warmimage
+
+import (
+	"reflect"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/resources"
+)
+
+// selectedNodes returns the nodes that the WarmImage selects, regardless of
+// whether its images support their platforms.
+func (c *warmer) selectedNodes(wi *warmimagev3.WarmImage) ([]*corev1.Node, error) {
+	nodes, err := c.nodesLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	cfg := c.configStore.Get()
+	var selected []*corev1.Node
+	for _, node := range nodes {
+		if resources.SelectsNode(wi, node, cfg) {
+			selected = append(selected, node)
+		}
+	}
+	return selected, nil
+}
+
+// architectures returns the architectures of the eligible nodes that the
+// WarmImage targets, onto which we warm its images with a DaemonSet each, so that each
+// runs the sleeper for its architecture.
+func (c *warmer) architectures(wi *warmimagev3.WarmImage) ([]string, error) {
+	nodes, _, err := c.eligibleNodes(wi)
+	if err != nil {
+		return nil, err
+	}
+	archs := sets.NewString()
+	for _, node := range nodes {
+		if _, arch := resources.NodePlatform(node); arch != "" {
+			archs.Insert(arch)
+		}
+	}
+	return archs.List(), nil
+}
+
+// reconcilePlatforms records the platforms of the nodes that the WarmImage
+// selects, but that its images don't all support, so that we don't warm them
+// there.
+func (c *warmer) reconcilePlatforms(wi *warmimagev3.WarmImage) error {
+	nodes, err := c.selectedNodes(wi)
+	if err != nil {
+		return err
+	}
+	counts := make(map[string]int32)
+	for _, node := range nodes {
+		if !resources.SupportsNode(wi, node) {
+			os, arch := resources.NodePlatform(node)
+			counts[os+"/"+arch]++
+		}
+	}
+	var skipped []warmimagev3.WarmImageSkippedPlatform
+	for platform, count := range counts {
+		skipped = append(skipped, warmimagev3.WarmImageSkippedPlatform{
+			Platform: platform,
+			Nodes:    count,
+		})
+	}
+	sort.Slice(skipped, func(i, j int) bool {
+		return skipped[i].Platform < skipped[j].Platform
+	})
+
+	// Only tell when the platforms change, rather than their node counts.
+	if platforms := skippedPlatforms(skipped); len(platforms) > 0 &&
+		!reflect.DeepEqual(platforms, skippedPlatforms(wi.Status.SkippedPlatforms)) {
+		c.Logger.Infof("Skipping the nodes with platforms %q, which %q don't support", platforms, wi.Spec.Images)
+		c.eventf(wi, corev1.EventTypeWarning, "PlatformsSkipped",
+			"The images don't support %q, so they aren't warmed on those nodes", platforms)
+	}
+	wi.Status.SkippedPlatforms = skipped
+	return nil
+}
+
+// markUntargeted marks the WarmImage failed when it targets none of the
+// nodes, e.g. because its images support none of the selected nodes'
+// platforms, since it would otherwise be Ready on zero of zero nodes.
+func (c *warmer) markUntargeted(wi *warmimagev3.WarmImage) error {
+	nodes, err := c.targetedNodes(wi)
+	if err != nil || len(nodes) > 0 {
+		return err
+	}
+	// This isn't an error to retry, but it isn't ready either.
+	if platforms := skippedPlatforms(wi.Status.SkippedPlatforms); len(platforms) > 0 {
+		wi.Status.MarkFailed("NoPlatformsSelected",
+			"The images don't support the platforms %q of any of the selected nodes.", platforms)
+	} else {
+		wi.Status.MarkFailed("NoNodesTargeted",
+			"No nodes match the nodeSelector, nodeAffinity and tolerations.")
+	}
+	return nil
+}
+
+func skippedPlatforms(skipped []warmimagev3.WarmImageSkippedPlatform) []string {
+	var platforms []string
+	for _, sp := range skipped {
+		platforms = append(platforms, sp.Platform)
+	}
+	return platforms
+}
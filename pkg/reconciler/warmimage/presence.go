@@ -0,0 +1,203 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package warmimage
+
+import (
+	"reflect"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/resources"
+	"github.com/mattmoor/warm-image/pkg/registry"
+)
+
+// maxReportedImages is how many images the kubelet reports in the status of
+// its node by default (its -node-status-max-images), the largest first. When
+// a node reports that many, the images it leaves out may still be there.
+const maxReportedImages = 50
+
+// nodeImages are the images that a node reports having on disk, by their
+// registry, repository and digest or tag.
+type nodeImages struct {
+	refs sets.String
+
+	// truncated is whether the node may have left some images out.
+	truncated bool
+}
+
+func makeNodeImages(node *corev1.Node) *nodeImages {
+	ni := &nodeImages{
+		refs:      sets.NewString(),
+		truncated: len(node.Status.Images) >= maxReportedImages,
+	}
+	for _, image := range node.Status.Images {
+		for _, name := range image.Names {
+			ref, err := registry.ParseReference(name)
+			if err != nil {
+				continue
+			}
+			if ref.Digest != "" {
+				ni.refs.Insert(imageKey(ref, "@"+ref.Digest))
+			}
+			if ref.Tag != "" {
+				ni.refs.Insert(imageKey(ref, ":"+ref.Tag))
+			}
+		}
+	}
+	return ni
+}
+
+// imageKey identifies an image the same way however the runtime spells it,
+// e.g. busybox, docker.io/library/busybox or index.docker.io/library/busybox.
+func imageKey(ref registry.Reference, identifier string) string {
+	return ref.Registry + "/" + ref.Repository + identifier
+}
+
+// lookupKey returns the key by which we look the image up among those that a
+// node reports having, by its digest if it has one and by its tag otherwise.
+func lookupKey(image string) (string, bool) {
+	ref, err := registry.ParseReference(image)
+	if err != nil {
+		return "", false
+	}
+	if ref.Digest != "" {
+		return imageKey(ref, "@"+ref.Digest), true
+	}
+	return imageKey(ref, ":"+ref.Tag), true
+}
+
+// has returns whether the node reports having the image.
+func (ni *nodeImages) has(image string) bool {
+	key, ok := lookupKey(image)
+	return ok && ni.refs.Has(key)
+}
+
+// isMissing returns whether we can tell that the node doesn't have the image.
+func (ni *nodeImages) isMissing(image string) bool {
+	return !ni.truncated && !ni.has(image)
+}
+
+// listNodeImages returns the images that each node reports having, by the
+// name of the node. We work them out once per reconcile, since nodes report
+// dozens of images each.
+func (c *warmer) listNodeImages() (map[string]*nodeImages, error) {
+	nodes, err := c.nodesLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	images := make(map[string]*nodeImages, len(nodes))
+	for _, node := range nodes {
+		images[node.Name] = makeNodeImages(node)
+	}
+	return images, nil
+}
+
+// imagesOf returns the images that the node reports having, from those we
+// worked out for this reconcile, if the node was around for that.
+func imagesOf(images map[string]*nodeImages, node *corev1.Node) *nodeImages {
+	if ni, ok := images[node.Name]; ok {
+		return ni
+	}
+	return makeNodeImages(node)
+}
+
+// propagatePresence records on how many of the eligible nodes the kubelet
+// reports having each of the images on disk, and all of them, regardless of
+// the state of the warm pods.
+func (c *warmer) propagatePresence(wi *warmimagev3.WarmImage, images map[string]*nodeImages) error {
+	nodes, _, err := c.eligibleNodes(wi)
+	if err != nil {
+		return err
+	}
+	perImage := make([]int32, len(wi.Spec.Images))
+	var present int32
+	for _, node := range nodes {
+		ni := imagesOf(images, node)
+		all := true
+		for i, image := range wi.Spec.Images {
+			if ni.has(resources.UserImage(wi, image)) {
+				perImage[i]++
+			} else {
+				all = false
+			}
+		}
+		if all {
+			present++
+		}
+	}
+	wi.Status.PresentNodes = present
+	for i, image := range wi.Spec.Images {
+		wi.Status.GetImage(image).PresentNodes = perImage[i]
+	}
+	return nil
+}
+
+// nodeChange is what changed on a node that matters to the WarmImages that
+// target it, other than its labels and taints: the images that it started or
+// stopped reporting, and the WarmImages on which its node agent's report
+// changed.
+type nodeChange struct {
+	images sets.String
+	// all is whether the node started or stopped leaving images out, which
+	// changes what we can tell about all of the images.
+	all     bool
+	reports sets.String
+}
+
+// diffNode returns what changed between the old and new versions of the
+// node, or nil if nothing that we report on did.
+func diffNode(old, new *corev1.Node) *nodeChange {
+	if reflect.DeepEqual(old.Status.Images, new.Status.Images) && reflect.DeepEqual(old.Annotations, new.Annotations) {
+		// The usual heartbeat, so spare working out the images.
+		return nil
+	}
+	oldImages, newImages := makeNodeImages(old), makeNodeImages(new)
+	nc := &nodeChange{
+		images:  oldImages.refs.Difference(newImages.refs).Union(newImages.refs.Difference(oldImages.refs)),
+		all:     oldImages.truncated != newImages.truncated,
+		reports: sets.NewString(),
+	}
+	for _, annotations := range []map[string]string{old.Annotations, new.Annotations} {
+		for key := range annotations {
+			if strings.HasPrefix(key, resources.AgentReportPrefix) && old.Annotations[key] != new.Annotations[key] {
+				nc.reports.Insert(strings.TrimPrefix(key, resources.AgentReportPrefix))
+			}
+		}
+	}
+	if nc.images.Len() == 0 && !nc.all && nc.reports.Len() == 0 {
+		return nil
+	}
+	return nc
+}
+
+// affects returns whether the change matters to the WarmImage, because it is
+// to one of its images or to the node agent's report on it.
+func (nc *nodeChange) affects(wi *warmimagev3.WarmImage) bool {
+	if nc.all || nc.reports.Has(string(wi.UID)) {
+		return true
+	}
+	for _, image := range wi.Spec.Images {
+		if key, ok := lookupKey(resources.UserImage(wi, image)); ok && nc.images.Has(key) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package warmimage
+
+import (
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+	"github.com/mattmoor/warm-image/pkg/priorityclass"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/resources"
+)
+
+// newNodeWindow is how soon after joining the cluster we must first see a
+// node for us to track how long the images take to warm onto it, so that we
+// don't mistake nodes that joined long ago, e.g. while the controller was
+// down, for new ones.
+const newNodeWindow = 10 * time.Minute
+
+// priorityOf returns the priority of the WarmImage, which is Normal unless
+// set.
+func priorityOf(wi *warmimagev3.WarmImage) warmimagev3.WarmImagePriority {
+	if wi.Spec.Priority == "" {
+		return warmimagev3.WarmImagePriorityNormal
+	}
+	return wi.Spec.Priority
+}
+
+// reconcilePriorityClass makes sure that the PriorityClass of the warm pods of
+// High priority images exists, if the WarmImage is one of them. The node
+// agents pull the images without any pods, so they don't need it.
+func (c *warmer) reconcilePriorityClass(wi *warmimagev3.WarmImage) error {
+	if priorityOf(wi) != warmimagev3.WarmImagePriorityHigh ||
+		wi.Spec.Strategy == warmimagev3.WarmImageStrategyNodeAgent {
+		return nil
+	}
+	_, err := c.priorityClassesLister.Get(resources.HighPriorityClassName)
+	if !errors.IsNotFound(err) {
+		return err
+	}
+	pc, err := c.priorityclassclient.Create(resources.MakeHighPriorityClass())
+	if errors.IsAlreadyExists(err) {
+		// Our informer cache is stale.
+		return nil
+	} else if err != nil {
+		return err
+	}
+	c.Logger.Infof("Created PriorityClass %q", pc.Name)
+	c.eventf(wi, corev1.EventTypeNormal, "Created", "Created PriorityClass %q", pc.Name)
+	return nil
+}
+
+// enqueueTargeting adds the keys of the given WarmImages that target the
+// node to the work queue right away, bypassing its rate limiting, those of
+// High priority first, so that we warm their images onto a node that just
+// joined the cluster before the workloads that need them land there.
+func (c *warmer) enqueueTargeting(queue workqueue.Interface, node *corev1.Node, wis map[string]*warmimagev3.WarmImage) {
+	cfg := c.configStore.Get()
+	var high, normal []string
+	for key, wi := range wis {
+		switch {
+		case !resources.TargetsNode(wi, node, cfg):
+		case priorityOf(wi) == warmimagev3.WarmImagePriorityHigh:
+			high = append(high, key)
+		default:
+			normal = append(normal, key)
+		}
+	}
+	sort.Strings(high)
+	sort.Strings(normal)
+	for _, key := range append(high, normal...) {
+		queue.Add(key)
+	}
+}
+
+// watchPriorityClass calls resync when the PriorityClass of the warm pods of
+// High priority images is deleted, so that we recreate it. Without it, the
+// API server rejects those warm pods.
+func watchPriorityClass(priorityClassInformer priorityclass.Informer, resync func()) {
+	priorityClassInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			object, ok := obj.(metav1.Object)
+			return ok && object.GetName() == resources.HighPriorityClassName
+		},
+		Handler: cache.ResourceEventHandlerFuncs{
+			DeleteFunc: func(interface{}) { resync() },
+		},
+	})
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package warmimage
+
+import (
+	"encoding/json"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/resources"
+)
+
+// readyLabel returns the key of the label with which we mark the nodes on
+// which the WarmImage's images are warm, or "" if it has none. A
+// ClusterWarmImage's label doesn't have the system namespace in which we warm
+// it.
+func (c *warmer) readyLabel(wi *warmimagev3.WarmImage) string {
+	if c.kind == "ClusterWarmImage" {
+		return resources.ReadyLabel("", wi.Name)
+	}
+	return resources.ReadyLabel(wi.Namespace, wi.Name)
+}
+
+// labelNodes marks the nodes on which the images are warm with the given
+// ready label, and removes it from the other nodes.
+func (c *warmer) labelNodes(key string, warm map[string]bool) error {
+	if key == "" {
+		return nil
+	}
+	nodes, err := c.nodesLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	var labeled, unlabeled int
+	for _, node := range nodes {
+		value, ok := node.Labels[key]
+		switch {
+		case warm[node.Name] && value == resources.ReadyLabelValue:
+		case !warm[node.Name] && !ok:
+		case warm[node.Name]:
+			if err := c.patchNodeLabel(node.Name, key, resources.ReadyLabelValue); err != nil {
+				return err
+			}
+			labeled++
+		default:
+			if err := c.patchNodeLabel(node.Name, key, nil); err != nil {
+				return err
+			}
+			unlabeled++
+		}
+	}
+	if labeled > 0 || unlabeled > 0 {
+		c.Logger.Infof("Labeled %d nodes and unlabeled %d nodes with %q", labeled, unlabeled, key)
+	}
+	return nil
+}
+
+// patchNodeLabel sets the label of the node with the given key to the given
+// value, or removes it when the value is nil.
+func (c *warmer) patchNodeLabel(name, key string, value interface{}) error {
+	// A JSON merge patch of the labels, in which null removes ours.
+	b, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]interface{}{
+				key: value,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.kubeclientset.CoreV1().Nodes().Patch(name, types.MergePatchType, b)
+	if errors.IsNotFound(err) {
+		// The node is gone, and its labels with it.
+		return nil
+	}
+	return err
+}
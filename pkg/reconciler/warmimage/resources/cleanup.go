@@ -0,0 +1,151 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/config"
+)
+
+// CleanupContainerName is the name of the container in the cleanup pods.
+const CleanupContainerName = "the-cleanup"
+
+// MakeCleanupLabels returns the labels of the pods that remove the
+// WarmImage's images from the nodes, which tell them apart from its warm pods.
+func MakeCleanupLabels(wi *warmimagev3.WarmImage) labels.Set {
+	return map[string]string{
+		"controller": string(wi.UID),
+		"cleanup":    "true",
+	}
+}
+
+// MakeCleanupLabelSelector selects the cleanup pods of the WarmImage or
+// ClusterWarmImage with the given UID.
+func MakeCleanupLabelSelector(uid types.UID) labels.Selector {
+	return labels.SelectorFromSet(map[string]string{
+		"controller": string(uid),
+		"cleanup":    "true",
+	})
+}
+
+// MakeCleanupPodName returns the name of the pod that removes the WarmImage's
+// images from the given node, which is deterministic like the names of the
+// one-shot pods.
+func MakeCleanupPodName(wi *warmimagev3.WarmImage, nodeName string) string {
+	return makeOwnedName(wi, "-cleanup-"+shortHash(nodeName))
+}
+
+// ReferencedImages returns the images of the given WarmImages, other than the
+// one with the given UID and those being deleted, along with the digests that
+// they resolved to. We must not remove these from the nodes when cooling the
+// WarmImage with the given UID down.
+func ReferencedImages(wis []*warmimagev3.WarmImage, except types.UID) sets.String {
+	referenced := sets.NewString()
+	for _, wi := range wis {
+		if wi.UID == except || wi.DeletionTimestamp != nil {
+			continue
+		}
+		for _, image := range wi.Spec.Images {
+			referenced.Insert(image)
+			if is := wi.Status.GetImage(image); is != nil && is.Digest != "" {
+				referenced.Insert(is.Digest)
+			}
+		}
+	}
+	return referenced
+}
+
+// RemovableImages returns the WarmImage's images, as they appear in
+// spec.images, that may be removed from the nodes: those that neither the
+// images nor the digests in referenced, see ReferencedImages, refer to.
+func RemovableImages(wi *warmimagev3.WarmImage, referenced sets.String) []string {
+	var removable []string
+	for _, image := range wi.Spec.Images {
+		if referenced.Has(image) {
+			continue
+		}
+		if is := wi.Status.GetImage(image); is != nil && referenced.Has(is.Digest) {
+			continue
+		}
+		removable = append(removable, image)
+	}
+	return removable
+}
+
+// MakeCleanupPod creates the pod that removes the given images of the
+// WarmImage, see RemovableImages, from the given node, of the given
+// architecture, through the container runtime, with the node agent, see
+// //cmd/nodeagent. The pod mounts the runtime's socket, so it runs in the
+// given system namespace, rather than in the WarmImage's, and has no owner,
+// since an owner can't be in another namespace. It is configured by the given
+// Config.
+func MakeCleanupPod(wi *warmimagev3.WarmImage, images []string, nodeName, arch, namespace string, cfg *config.Config) *corev1.Pod {
+	args := []string{"-remove", "-runtime-endpoint", cfg.RuntimeEndpoint}
+	for _, image := range images {
+		args = append(args, UserImage(wi, image))
+	}
+	automount := false
+	socket := strings.TrimPrefix(cfg.RuntimeEndpoint, "unix://")
+	socketType := corev1.HostPathSocket
+	// Land wherever the warm pods did.
+	spec := makePodSpec(wi, "exit", arch, cfg)
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      MakeCleanupPodName(wi, nodeName),
+			Namespace: namespace,
+			Labels:    MakeCleanupLabels(wi),
+		},
+		Spec: corev1.PodSpec{
+			// It has no business with the API server.
+			AutomountServiceAccountToken: &automount,
+			Containers: []corev1.Container{{
+				Name:  CleanupContainerName,
+				Image: cfg.NodeAgentImageFor(arch),
+				Args:  args,
+				VolumeMounts: []corev1.VolumeMount{{
+					Name:      "runtime",
+					MountPath: socket,
+				}},
+				Resources: cfg.Resources,
+			}},
+			Volumes: []corev1.Volume{{
+				Name: "runtime",
+				VolumeSource: corev1.VolumeSource{
+					HostPath: &corev1.HostPathVolumeSource{
+						Path: socket,
+						Type: &socketType,
+					},
+				},
+			}},
+			// We have already picked the node, so bypass the scheduler.
+			NodeName:          nodeName,
+			Tolerations:       spec.Tolerations,
+			PriorityClassName: spec.PriorityClassName,
+			// Retry until the images are removed, e.g. once the
+			// containers of the warm pods that used them are gone.
+			RestartPolicy: corev1.RestartPolicyOnFailure,
+		},
+	}
+}
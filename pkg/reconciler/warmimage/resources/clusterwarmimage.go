@@ -0,0 +1,64 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+)
+
+// MakeWarmImageView returns the WarmImage in the given system namespace as
+// which the ClusterWarmImage is warmed. Its image pull secrets refer to the
+// copies of the ClusterWarmImage's secrets in the system namespace.
+func MakeWarmImageView(cwi *warmimagev3.ClusterWarmImage, systemNamespace string) *warmimagev3.WarmImage {
+	var secrets []corev1.LocalObjectReference
+	for _, ref := range cwi.Spec.ImagePullSecrets {
+		if ref.Namespace == "" || ref.Namespace == systemNamespace {
+			// There's no need to copy these.
+			secrets = append(secrets, corev1.LocalObjectReference{Name: ref.Name})
+		} else {
+			secrets = append(secrets, corev1.LocalObjectReference{Name: MakeSecretName(cwi, ref)})
+		}
+	}
+	return &warmimagev3.WarmImage{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              cwi.Name,
+			Namespace:         systemNamespace,
+			UID:               cwi.UID,
+			Generation:        cwi.Generation,
+			CreationTimestamp: cwi.CreationTimestamp,
+			DeletionTimestamp: cwi.DeletionTimestamp,
+		},
+		Spec: warmimagev3.WarmImageSpec{
+			Images:             cwi.Spec.Images,
+			ImagePullSecrets:   secrets,
+			ServiceAccountName: cwi.Spec.ServiceAccountName,
+			NodeSelector:       cwi.Spec.NodeSelector,
+			NodeAffinity:       cwi.Spec.NodeAffinity,
+			Tolerations:        cwi.Spec.Tolerations,
+			RefreshInterval:    cwi.Spec.RefreshInterval,
+			Rollout:            cwi.Spec.Rollout,
+			Strategy:           cwi.Spec.Strategy,
+			CoolDown:           cwi.Spec.CoolDown,
+			Priority:           cwi.Spec.Priority,
+			Required:           cwi.Spec.Required,
+		},
+		Status: cwi.Status,
+	}
+}
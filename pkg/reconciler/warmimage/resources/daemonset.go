@@ -17,12 +17,30 @@ limitations under the License.
 package resources
 
 import (
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
-	extv1beta1 "k8s.io/api/extensions/v1beta1"
-	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
-	warmimagev2 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v2"
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/config"
+	"github.com/mattmoor/warm-image/pkg/registry"
+)
+
+const (
+	// SleeperContainerName is the name of the init container that drops
+	// the sleeper binary into the warm pod.
+	SleeperContainerName = "the-sleeper"
+
+	// maxNameLength bounds the names of our DaemonSets, which also prefix
+	// the names of their pods and ControllerRevisions.
+	maxNameLength = 63
+
+	// userContainerPrefix is the name of the container running the first
+	// image being warmed, and the prefix of the names of the others.
+	userContainerPrefix = "the-image"
 )
 
 var (
@@ -32,18 +50,14 @@ var (
 			EmptyDir: &corev1.EmptyDirVolumeSource{},
 		},
 	}
-	sleeperResources = corev1.ResourceRequirements{
-		Limits: corev1.ResourceList{
-			corev1.ResourceCPU:    resource.MustParse("1m"),
-			corev1.ResourceMemory: resource.MustParse("20M"),
-		},
-	}
 )
 
-func sleeperContainer(sleeperImage string) corev1.Container {
+// sleeperContainer drops the sleeper for the given architecture into the warm
+// pod, where it runs from each of the images.
+func sleeperContainer(arch string, cfg *config.Config) corev1.Container {
 	return corev1.Container{
-		Name:  "the-sleeper",
-		Image: sleeperImage,
+		Name:  SleeperContainerName,
+		Image: cfg.SleeperImageFor(arch),
 		Args: []string{
 			"-mode", "copy",
 			"-to", "/drop/sleeper",
@@ -52,50 +66,147 @@ func sleeperContainer(sleeperImage string) corev1.Container {
 			Name:      sleeperVolume.Name,
 			MountPath: "/drop/",
 		}},
-		Resources: sleeperResources,
+		Resources: cfg.Resources,
+	}
+}
+
+// UserContainerName returns the name of the container running the i-th
+// image being warmed.
+func UserContainerName(i int) string {
+	if i == 0 {
+		return userContainerPrefix
 	}
+	return fmt.Sprintf("%s-%d", userContainerPrefix, i)
 }
 
-func userContainer(image string) corev1.Container {
+// userContainer runs the sleeper from the given image in the given mode.
+func userContainer(name, image, mode string, cfg *config.Config) corev1.Container {
 	return corev1.Container{
-		Name:            "the-image",
+		Name:            name,
 		Image:           image,
 		ImagePullPolicy: corev1.PullAlways,
 		Command:         []string{"/drop/sleeper"},
-		Args:            []string{"-mode", "sleep"},
+		Args:            []string{"-mode", mode},
 		VolumeMounts: []corev1.VolumeMount{{
 			Name:      sleeperVolume.Name,
 			MountPath: "/drop/",
 		}},
-		Resources: sleeperResources,
+		Resources: cfg.Resources,
+	}
+}
+
+// userImage returns the image reference for the warm pods to run, pinned to
+// the digest that the image resolved to when we know it.
+func UserImage(wi *warmimagev3.WarmImage, image string) string {
+	is := wi.Status.GetImage(image)
+	if is == nil || is.Digest == "" {
+		return image
+	}
+	ref, err := registry.ParseReference(image)
+	if err != nil {
+		return image
+	}
+	return ref.WithDigest(is.Digest)
+}
+
+// MakeDaemonSetName returns the name of the DaemonSet that warms this version
+// of the WarmImage onto the nodes of the given architecture. The name is
+// deterministic, so that a stale informer cache makes us fail to create a
+// second DaemonSet, rather than create a duplicate.
+func MakeDaemonSetName(wi *warmimagev3.WarmImage, arch string) string {
+	return makeOwnedName(wi, "-"+version(wi)+"-"+arch)
+}
+
+// makeOwnedName returns the name of one of the WarmImage's resources, with
+// the given suffix. The name includes a hash of the WarmImage's UID, since
+// a ClusterWarmImage is warmed as a WarmImage in the system namespace, which
+// may share its name with a WarmImage that lives there.
+func makeOwnedName(wi *warmimagev3.WarmImage, suffix string) string {
+	return makeName(wi.Name, "-"+shortHash(string(wi.UID))+suffix)
+}
+
+// makeName joins the prefix and suffix into a name of at most maxNameLength,
+// truncating the prefix if needed.
+func makeName(prefix, suffix string) string {
+	if len(prefix)+len(suffix) > maxNameLength {
+		// Don't leave a dot right before the dash, which is invalid.
+		prefix = strings.TrimRight(prefix[:maxNameLength-len(suffix)], ".")
 	}
+	return prefix + suffix
 }
 
-func MakeDaemonSet(wi *warmimagev2.WarmImage, sleeperImage string) *extv1beta1.DaemonSet {
-	ips := []corev1.LocalObjectReference{}
-	if wi.Spec.ImagePullSecrets != nil {
-		ips = append(ips, *wi.Spec.ImagePullSecrets)
+// MakeDaemonSet creates the DaemonSet that warms the WarmImage's images onto
+// the eligible nodes of the given architecture, with the sleeper for it,
+// controlled by the given owner, if any, and configured by the given Config.
+// It returns nil when the WarmImage selects no nodes, see SelectsNoNodes.
+func MakeDaemonSet(wi *warmimagev3.WarmImage, arch string, owner *metav1.OwnerReference, cfg *config.Config) *appsv1.DaemonSet {
+	var ownerRefs []metav1.OwnerReference
+	if owner != nil {
+		ownerRefs = append(ownerRefs, *owner)
+	}
+	spec := makePodSpec(wi, "sleep", arch, cfg)
+	if !requireArchitecture(&spec, wi, arch) {
+		return nil
 	}
-	return &extv1beta1.DaemonSet{
+	requireEligible(&spec)
+	return &appsv1.DaemonSet{
 		ObjectMeta: metav1.ObjectMeta{
-			GenerateName: wi.Name,
-			Labels:       MakeLabels(wi),
-			OwnerReferences: []metav1.OwnerReference{
-				*metav1.NewControllerRef(wi, warmimagev2.SchemeGroupVersion.WithKind("WarmImage")),
-			},
+			Name:            MakeDaemonSetName(wi, arch),
+			Labels:          MakeDaemonSetLabels(wi, arch),
+			OwnerReferences: ownerRefs,
 		},
-		Spec: extv1beta1.DaemonSetSpec{
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: MakeDaemonSetLabels(wi, arch),
+			},
+			// Roll out repairs to the pod template, rather than waiting
+			// for the pods to be deleted.
+			UpdateStrategy: appsv1.DaemonSetUpdateStrategy{
+				Type: appsv1.RollingUpdateDaemonSetStrategyType,
+			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: MakeLabels(wi),
-				},
-				Spec: corev1.PodSpec{
-					InitContainers:   []corev1.Container{sleeperContainer(sleeperImage)},
-					Containers:       []corev1.Container{userContainer(wi.Spec.Image)},
-					ImagePullSecrets: ips,
-					Volumes:          []corev1.Volume{sleeperVolume},
+					Labels: MakeDaemonSetLabels(wi, arch),
 				},
+				Spec: spec,
 			},
 		},
 	}
 }
+
+// makePodSpec makes the spec of the pods that warm the WarmImage's images
+// onto the nodes of the given architecture, in which the sleeper runs from
+// each image in the given mode.
+func makePodSpec(wi *warmimagev3.WarmImage, mode, arch string, cfg *config.Config) corev1.PodSpec {
+	containers := make([]corev1.Container, 0, len(wi.Spec.Images))
+	for i, image := range wi.Spec.Images {
+		containers = append(containers, userContainer(UserContainerName(i), UserImage(wi, image), mode, cfg))
+	}
+	ips := append([]corev1.LocalObjectReference{}, wi.Spec.ImagePullSecrets...)
+	var tolerations []corev1.Toleration
+	tolerations = append(tolerations, wi.Spec.Tolerations...)
+	tolerations = append(tolerations, cfg.Tolerations...)
+	if cfg.StartupTaint != "" {
+		// The images must be warm on the new nodes before they are
+		// rid of the startup taint.
+		tolerations = append(tolerations, corev1.Toleration{
+			Key:      cfg.StartupTaint,
+			Operator: corev1.TolerationOpExists,
+		})
+	}
+	var affinity *corev1.Affinity
+	if wi.Spec.NodeAffinity != nil {
+		affinity = &corev1.Affinity{NodeAffinity: wi.Spec.NodeAffinity}
+	}
+	return corev1.PodSpec{
+		InitContainers:     []corev1.Container{sleeperContainer(arch, cfg)},
+		Containers:         containers,
+		ImagePullSecrets:   ips,
+		ServiceAccountName: wi.Spec.ServiceAccountName,
+		Volumes:            []corev1.Volume{sleeperVolume},
+		NodeSelector:       wi.Spec.NodeSelector,
+		Affinity:           affinity,
+		Tolerations:        tolerations,
+		PriorityClassName:  priorityClassName(wi, cfg),
+	}
+}
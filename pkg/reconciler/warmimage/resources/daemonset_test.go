@@ -0,0 +1,176 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/config"
+)
+
+func makeWarmImage(name string) *warmimagev3.WarmImage {
+	wi := &warmimagev3.WarmImage{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      name,
+			UID:       "the-uid",
+		},
+		Spec: warmimagev3.WarmImageSpec{
+			Images: []string{"gcr.io/foo/bar:latest"},
+		},
+	}
+	wi.SetDefaults()
+	wi.Status.InitializeImages(wi.Spec.Images)
+	return wi
+}
+
+func TestMakeDaemonSetName(t *testing.T) {
+	base := MakeDaemonSetName(makeWarmImage("foo"), "amd64")
+
+	tests := []struct {
+		name   string
+		mutate func(*warmimagev3.WarmImage)
+		arch   string
+		// wantSame is whether the name stays that of the base WarmImage.
+		wantSame bool
+	}{{
+		name:     "unchanged",
+		arch:     "amd64",
+		wantSame: true,
+	}, {
+		name: "another architecture",
+		arch: "arm64",
+	}, {
+		name: "another image",
+		mutate: func(wi *warmimagev3.WarmImage) {
+			wi.Spec.Images = append(wi.Spec.Images, "busybox")
+			wi.Status.InitializeImages(wi.Spec.Images)
+		},
+		arch: "amd64",
+	}, {
+		name: "the tag resolves to a new digest",
+		mutate: func(wi *warmimagev3.WarmImage) {
+			wi.Status.MarkResolved("gcr.io/foo/bar:latest", "sha256:deadbeef", nil)
+		},
+		arch: "amd64",
+	}, {
+		name: "another image pull secret",
+		mutate: func(wi *warmimagev3.WarmImage) {
+			wi.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "my-secret"}}
+		},
+		arch: "amd64",
+	}, {
+		name: "another node selector",
+		mutate: func(wi *warmimagev3.WarmImage) {
+			wi.Spec.NodeSelector = map[string]string{"disktype": "ssd"}
+		},
+		arch: "amd64",
+	}, {
+		name: "another toleration",
+		mutate: func(wi *warmimagev3.WarmImage) {
+			wi.Spec.Tolerations = []corev1.Toleration{{Key: "gpu", Operator: corev1.TolerationOpExists}}
+		},
+		arch: "amd64",
+	}, {
+		name: "another UID",
+		mutate: func(wi *warmimagev3.WarmImage) {
+			wi.UID = "another-uid"
+		},
+		arch: "amd64",
+	}, {
+		// These don't change what the warm pods run.
+		name: "a rollout policy",
+		mutate: func(wi *warmimagev3.WarmImage) {
+			wi.Spec.Rollout = &warmimagev3.WarmImageRollout{Timeout: &metav1.Duration{Duration: time.Hour}}
+		},
+		arch:     "amd64",
+		wantSame: true,
+	}, {
+		name: "the status",
+		mutate: func(wi *warmimagev3.WarmImage) {
+			wi.Status.MarkRolledOut()
+			wi.Status.ObservedGeneration = 42
+		},
+		arch:     "amd64",
+		wantSame: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wi := makeWarmImage("foo")
+			if test.mutate != nil {
+				test.mutate(wi)
+			}
+			got := MakeDaemonSetName(wi, test.arch)
+			if same := got == base; same != test.wantSame {
+				t.Errorf("MakeDaemonSetName() = %q, base %q, wanted same %v", got, base, test.wantSame)
+			}
+		})
+	}
+}
+
+func TestMakeDaemonSetNameLength(t *testing.T) {
+	for _, name := range []string{
+		strings.Repeat("a", 253),
+		// Truncating it must not leave a dot before the dash.
+		strings.Repeat("a", 40) + "." + strings.Repeat("b", 200),
+	} {
+		got := MakeDaemonSetName(makeWarmImage(name), "amd64")
+		if len(got) > maxNameLength {
+			t.Errorf("MakeDaemonSetName() = %q, longer than %d", got, maxNameLength)
+		}
+		if strings.Contains(got, ".-") {
+			t.Errorf("MakeDaemonSetName() = %q, with a dot before a dash", got)
+		}
+		if !strings.HasPrefix(got, "aaaa") {
+			t.Errorf("MakeDaemonSetName() = %q, wanted the WarmImage's name as prefix", got)
+		}
+	}
+}
+
+func TestMakeDaemonSet(t *testing.T) {
+	cfg := config.New("the-sleeper", "the-node-agent")
+	wi := makeWarmImage("foo")
+	ds := MakeDaemonSet(wi, "amd64", nil, cfg)
+	if ds == nil {
+		t.Fatal("MakeDaemonSet() = nil")
+	}
+	if got, want := ds.Name, MakeDaemonSetName(wi, "amd64"); got != want {
+		t.Errorf("Name = %q, wanted %q", got, want)
+	}
+	for _, labels := range []map[string]string{ds.Labels, ds.Spec.Selector.MatchLabels, ds.Spec.Template.Labels} {
+		if labels["controller"] != "the-uid" || labels["arch"] != "amd64" || labels["version"] == "" {
+			t.Errorf("labels = %v, wanted the controller, arch and version", labels)
+		}
+	}
+
+	// An affinity whose terms are all empty selects no nodes.
+	wi.Spec.NodeAffinity = &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{{}},
+		},
+	}
+	if ds := MakeDaemonSet(wi, "amd64", nil, cfg); ds != nil {
+		t.Errorf("MakeDaemonSet() = %v, wanted nil", ds)
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/config"
+)
+
+// IneligibleLabel labels the nodes that we don't warm images onto, with the
+// reason why, so that the warm pods of the DaemonSets stay off them. We label
+// the nodes, rather than list them in the pod templates, so that a node
+// becoming ineligible doesn't roll the warm pods on every other node.
+const IneligibleLabel = "warmimage.mattmoor.io/ineligible"
+
+// IneligibleReason returns why we don't warm images onto the node, given the
+// Config's IneligibleNodes, or "" if we do.
+func IneligibleReason(node *corev1.Node, cfg *config.Config) string {
+	for _, state := range cfg.IneligibleNodes {
+		switch state {
+		case config.IneligibleUnschedulable:
+			if node.Spec.Unschedulable {
+				return state
+			}
+		case config.IneligibleNotReady:
+			if !hasCondition(node, corev1.NodeReady) {
+				return state
+			}
+		default:
+			if hasCondition(node, corev1.NodeConditionType(state)) {
+				return state
+			}
+		}
+	}
+	return ""
+}
+
+// hasCondition returns whether the node's condition of the given type is
+// true.
+func hasCondition(node *corev1.Node, t corev1.NodeConditionType) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == t {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// requireEligible keeps the pods with the given spec off the nodes that we
+// label ineligible. The spec must already require node affinity terms of its
+// own, which we add to.
+func requireEligible(spec *corev1.PodSpec) {
+	terms := spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	for i := range terms {
+		terms[i].MatchExpressions = append(terms[i].MatchExpressions, corev1.NodeSelectorRequirement{
+			Key:      IneligibleLabel,
+			Operator: corev1.NodeSelectorOpDoesNotExist,
+		})
+	}
+}
@@ -17,32 +17,112 @@ limitations under the License.
 package resources
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
 
-	warmimagev2 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v2"
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
 )
 
-func MakeLabels(wi *warmimagev2.WarmImage) labels.Set {
+func MakeLabels(wi *warmimagev3.WarmImage) labels.Set {
 	return map[string]string{
 		"controller": string(wi.UID),
-		"version":    wi.ResourceVersion,
+		"version":    version(wi),
 	}
 }
 
-func MakeLabelSelector(wi *warmimagev2.WarmImage) labels.Selector {
+// version returns the version of the WarmImage that its resources are
+// labeled with. This is a hash of the fields of the WarmImage that go into
+// the warm pods, so that only changing what the pods run rolls a new
+// DaemonSet, and not e.g. changing the refreshInterval or writing the
+// status.
+func version(wi *warmimagev3.WarmImage) string {
+	images := make([]string, 0, len(wi.Spec.Images))
+	for _, image := range wi.Spec.Images {
+		// A moved tag changes the digest we pin the pods to.
+		images = append(images, UserImage(wi, image))
+	}
+	b, err := json.Marshal(struct {
+		Images             []string
+		ImagePullSecrets   []corev1.LocalObjectReference
+		ServiceAccountName string
+		NodeSelector       map[string]string
+		NodeAffinity       *corev1.NodeAffinity
+		Tolerations        []corev1.Toleration
+	}{
+		Images:             images,
+		ImagePullSecrets:   wi.Spec.ImagePullSecrets,
+		ServiceAccountName: wi.Spec.ServiceAccountName,
+		NodeSelector:       wi.Spec.NodeSelector,
+		NodeAffinity:       wi.Spec.NodeAffinity,
+		Tolerations:        wi.Spec.Tolerations,
+	})
+	if err != nil {
+		panic(fmt.Sprintf("json.Marshal() = %v", err))
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// shortHash returns a short hash of the given string, for names that must be
+// unique but of bounded length.
+func shortHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:8]
+}
+
+func MakeLabelSelector(wi *warmimagev3.WarmImage) labels.Selector {
 	return labels.SelectorFromSet(MakeLabels(wi))
 }
 
-func MakeOldVersionLabelSelector(wi *warmimagev2.WarmImage) labels.Selector {
+// MakeDaemonSetLabels returns the labels of the DaemonSet that warms this
+// version of the WarmImage onto the nodes of the given architecture, and of
+// its pods.
+func MakeDaemonSetLabels(wi *warmimagev3.WarmImage, arch string) labels.Set {
+	l := MakeLabels(wi)
+	l["arch"] = arch
+	return l
+}
+
+// MakeControllerLabelSelector selects the resources of every version of the
+// WarmImage or ClusterWarmImage with the given UID.
+func MakeControllerLabelSelector(uid types.UID) labels.Selector {
+	return labels.SelectorFromSet(map[string]string{
+		"controller": string(uid),
+	})
+}
+
+// MakeAllLabelSelector selects the resources of every WarmImage.
+func MakeAllLabelSelector() labels.Selector {
 	return labels.NewSelector().Add(
-		mustNewRequirement("controller", selection.Equals, []string{string(wi.UID)}),
-		mustNewRequirement("version", selection.NotEquals, []string{wi.ResourceVersion}),
+		mustNewRequirement("controller", selection.Exists, nil),
 	)
 }
 
+// MakeOneShotLabels returns the labels of the pods that pull this version of
+// the WarmImage's images onto a node once, which tell them apart from the
+// pods of its DaemonSets.
+func MakeOneShotLabels(wi *warmimagev3.WarmImage) labels.Set {
+	l := MakeLabels(wi)
+	l["strategy"] = string(warmimagev3.WarmImageStrategyOneShot)
+	return l
+}
+
+// MakeOneShotLabelSelector selects the one-shot pods of every version of the
+// WarmImage or ClusterWarmImage with the given UID.
+func MakeOneShotLabelSelector(uid types.UID) labels.Selector {
+	return labels.SelectorFromSet(map[string]string{
+		"controller": string(uid),
+		"strategy":   string(warmimagev3.WarmImageStrategyOneShot),
+	})
+}
+
 func mustNewRequirement(key string, op selection.Operator, vals []string) labels.Requirement {
 	r, err := labels.NewRequirement(key, op, vals)
 	if err != nil {
@@ -0,0 +1,141 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+	"github.com/mattmoor/warm-image/pkg/registry"
+)
+
+// AgentReportPrefix prefixes the keys of the annotations through which the
+// node agent on a node reports on the images it pulled onto the node.
+const AgentReportPrefix = "agent.warmimage.mattmoor.io/"
+
+// AgentReport is what the node agent on a node reports on pulling the images
+// of a WarmImage or ClusterWarmImage onto the node.
+type AgentReport struct {
+	// Version is the version of the WarmImage whose images were pulled.
+	Version string `json:"version"`
+
+	// Reason is why an image could not be pulled, if any. The agent pulls
+	// the images in order and stops at the first that fails, so the ones
+	// before it were pulled.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// Image is the image that Reason applies to, if any, as it appears in
+	// spec.images.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Message is a human readable description of the failure, if any.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Removed reports that the images were removed from the node, to cool
+	// it down once the WarmImage is deleted, rather than pulled.
+	// +optional
+	Removed bool `json:"removed,omitempty"`
+}
+
+// MakeAgentReportKey returns the key of the annotation through which the node
+// agents report on the WarmImage or ClusterWarmImage with the given UID.
+func MakeAgentReportKey(uid types.UID) string {
+	return AgentReportPrefix + string(uid)
+}
+
+// MakeAgentReport returns the node agent's report on this version of the
+// WarmImage, with the reason the given image failed to pull, if any.
+func MakeAgentReport(wi *warmimagev3.WarmImage, reason, image, message string) *AgentReport {
+	return &AgentReport{
+		Version: version(wi),
+		Reason:  reason,
+		Image:   image,
+		Message: message,
+	}
+}
+
+// MakeAgentRemovedReport returns the node agent's report on having removed the
+// images of this version of the WarmImage from the node, or the reason it
+// failed to remove the given image, if any.
+func MakeAgentRemovedReport(wi *warmimagev3.WarmImage, reason, image, message string) *AgentReport {
+	report := MakeAgentReport(wi, reason, image, message)
+	report.Removed = reason == ""
+	return report
+}
+
+// MakeAgentSecretName returns the name of the secret in the agent namespace
+// with the credentials that the node agents pull the WarmImage's images with.
+func MakeAgentSecretName(wi *warmimagev3.WarmImage) string {
+	return makeOwnedName(wi, "-nodeagent")
+}
+
+// MakeAgentSecret creates the secret in the given agent namespace with the
+// credentials from the keychain for the registries of the WarmImage's images,
+// and for no others. The node agents can only read the secrets in the agent
+// namespace, which holds nothing else, rather than the image pull secrets of
+// every namespace.
+func MakeAgentSecret(wi *warmimagev3.WarmImage, namespace string, kc registry.Keychain) (*corev1.Secret, error) {
+	needed := make(registry.Keychain)
+	for _, image := range wi.Spec.Images {
+		ref, err := registry.ParseReference(image)
+		if err != nil {
+			return nil, err
+		}
+		if cred, ok := kc.Lookup(ref.Registry); ok {
+			needed[ref.Registry] = cred
+		}
+	}
+	b, err := needed.DockerConfigJSON()
+	if err != nil {
+		return nil, err
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      MakeAgentSecretName(wi),
+			Namespace: namespace,
+			Labels: map[string]string{
+				"controller": string(wi.UID),
+			},
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: b,
+		},
+	}, nil
+}
+
+// GetAgentReport returns the node agent's report on the current version of the
+// WarmImage from the node, if it has reported on it.
+func GetAgentReport(wi *warmimagev3.WarmImage, node *corev1.Node) (*AgentReport, bool) {
+	v, ok := node.Annotations[MakeAgentReportKey(wi.UID)]
+	if !ok {
+		return nil, false
+	}
+	var report AgentReport
+	if err := json.Unmarshal([]byte(v), &report); err != nil || report.Version != version(wi) {
+		// Ignore garbled reports and those on older versions.
+		return nil, false
+	}
+	return &report, true
+}
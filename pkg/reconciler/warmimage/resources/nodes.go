@@ -0,0 +1,142 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/config"
+)
+
+// TargetsNode returns whether the WarmImage's images belong on the node,
+// given the Config that adds to its tolerations: it must select the node, and
+// its images must support the node's platform. This is where we pull them
+// ourselves, with one-shot pods or node agents, rather than leave it to the
+// DaemonSet controller.
+func TargetsNode(wi *warmimagev3.WarmImage, node *corev1.Node, cfg *config.Config) bool {
+	return SelectsNode(wi, node, cfg) && SupportsNode(wi, node)
+}
+
+// SelectsNode returns whether the WarmImage's nodeSelector, nodeAffinity and
+// tolerations, along with those of the Config, pick the node, regardless of
+// its platform.
+func SelectsNode(wi *warmimagev3.WarmImage, node *corev1.Node, cfg *config.Config) bool {
+	spec := makePodSpec(wi, "exit", "", cfg)
+	return isTargeted(&spec, node)
+}
+
+// tolerableTaints are the taints that the DaemonSet controller tolerates on
+// behalf of the pods of every DaemonSet, and so do we.
+var tolerableTaints = map[string]bool{
+	"node.kubernetes.io/not-ready":       true,
+	"node.kubernetes.io/unreachable":     true,
+	"node.kubernetes.io/disk-pressure":   true,
+	"node.kubernetes.io/memory-pressure": true,
+	"node.kubernetes.io/unschedulable":   true,
+}
+
+// isTargeted returns whether pods with the given spec belong on the node,
+// the way that the DaemonSet controller decides it: the node must match
+// their node selector and required node affinity, and they must tolerate the
+// node's taints.
+func isTargeted(spec *corev1.PodSpec, node *corev1.Node) bool {
+	if !labels.SelectorFromSet(spec.NodeSelector).Matches(labels.Set(node.Labels)) {
+		return false
+	}
+	if spec.Affinity != nil && spec.Affinity.NodeAffinity != nil {
+		if ns := spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution; ns != nil && !matchesNodeSelector(ns, node) {
+			return false
+		}
+	}
+	for i := range node.Spec.Taints {
+		taint := &node.Spec.Taints[i]
+		if taint.Effect == corev1.TaintEffectPreferNoSchedule || tolerableTaints[taint.Key] {
+			continue
+		}
+		if !toleratesTaint(spec.Tolerations, taint) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesNodeSelector returns whether the node matches any of the terms of
+// the NodeSelector.
+func matchesNodeSelector(ns *corev1.NodeSelector, node *corev1.Node) bool {
+	for _, term := range ns.NodeSelectorTerms {
+		if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+			// An empty term matches no nodes.
+			continue
+		}
+		ls, err := makeNodeSelectorRequirements(term.MatchExpressions)
+		if err != nil || !ls.Matches(labels.Set(node.Labels)) {
+			continue
+		}
+		fs, err := makeNodeSelectorRequirements(term.MatchFields)
+		if err != nil || !fs.Matches(labels.Set{"metadata.name": node.Name}) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// makeNodeSelectorRequirements converts the requirements of a
+// NodeSelectorTerm into a labels.Selector, with which we match fields too.
+func makeNodeSelectorRequirements(nsrs []corev1.NodeSelectorRequirement) (labels.Selector, error) {
+	selector := labels.NewSelector()
+	for _, nsr := range nsrs {
+		var op selection.Operator
+		switch nsr.Operator {
+		case corev1.NodeSelectorOpIn:
+			op = selection.In
+		case corev1.NodeSelectorOpNotIn:
+			op = selection.NotIn
+		case corev1.NodeSelectorOpExists:
+			op = selection.Exists
+		case corev1.NodeSelectorOpDoesNotExist:
+			op = selection.DoesNotExist
+		case corev1.NodeSelectorOpGt:
+			op = selection.GreaterThan
+		case corev1.NodeSelectorOpLt:
+			op = selection.LessThan
+		default:
+			return nil, fmt.Errorf("%q is not a valid node selector operator", nsr.Operator)
+		}
+		r, err := labels.NewRequirement(nsr.Key, op, nsr.Values)
+		if err != nil {
+			return nil, err
+		}
+		selector = selector.Add(*r)
+	}
+	return selector, nil
+}
+
+// toleratesTaint returns whether any of the tolerations tolerate the taint.
+func toleratesTaint(tolerations []corev1.Toleration, taint *corev1.Taint) bool {
+	for i := range tolerations {
+		if tolerations[i].ToleratesTaint(taint) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/config"
+)
+
+// MakeOneShotPodName returns the name of the pod that pulls this version of
+// the WarmImage's images onto the given node. Like the names of our
+// DaemonSets, it is deterministic, so that a stale informer cache makes us
+// fail to create a second pod for the node, rather than pull the images twice.
+func MakeOneShotPodName(wi *warmimagev3.WarmImage, nodeName string) string {
+	// Node names can be long, so identify the node by a hash of its name.
+	return makeOwnedName(wi, "-"+version(wi)+"-"+shortHash(nodeName))
+}
+
+// MakeOneShotPod creates the pod that pulls the WarmImage's images onto the
+// given node, of the given architecture, and then exits, controlled by the
+// given owner, if any, and configured by the given Config.
+func MakeOneShotPod(wi *warmimagev3.WarmImage, nodeName, arch string, owner *metav1.OwnerReference, cfg *config.Config) *corev1.Pod {
+	var ownerRefs []metav1.OwnerReference
+	if owner != nil {
+		ownerRefs = append(ownerRefs, *owner)
+	}
+	spec := makePodSpec(wi, "exit", arch, cfg)
+	// We have already picked the node, so bypass the scheduler.
+	spec.NodeName = nodeName
+	// Retry failed pulls, but leave the pod be once the images are pulled.
+	spec.RestartPolicy = corev1.RestartPolicyOnFailure
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            MakeOneShotPodName(wi, nodeName),
+			Labels:          MakeOneShotLabels(wi),
+			OwnerReferences: ownerRefs,
+		},
+		Spec: spec,
+	}
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+)
+
+const (
+	// ArchLabel and OSLabel are the well-known labels of the architecture
+	// and operating system of a node.
+	ArchLabel = "kubernetes.io/arch"
+	OSLabel   = "kubernetes.io/os"
+
+	// The kubelets of older releases only set these.
+	betaArchLabel = "beta.kubernetes.io/arch"
+	betaOSLabel   = "beta.kubernetes.io/os"
+)
+
+// NodePlatform returns the operating system and architecture of the node,
+// from its labels, or failing that, from what its kubelet reports.
+func NodePlatform(node *corev1.Node) (string, string) {
+	os := firstLabel(node, OSLabel, betaOSLabel)
+	if os == "" {
+		os = node.Status.NodeInfo.OperatingSystem
+	}
+	arch := firstLabel(node, ArchLabel, betaArchLabel)
+	if arch == "" {
+		arch = node.Status.NodeInfo.Architecture
+	}
+	return os, arch
+}
+
+func firstLabel(node *corev1.Node, keys ...string) string {
+	for _, key := range keys {
+		if v := node.Labels[key]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// SupportedPlatforms returns the platforms, as os/arch, that all of the
+// WarmImage's images support, or false if we don't know that, because some
+// of them aren't resolved yet.
+func SupportedPlatforms(wi *warmimagev3.WarmImage) (sets.String, bool) {
+	var supported sets.String
+	for _, image := range wi.Spec.Images {
+		is := wi.Status.GetImage(image)
+		if is == nil || len(is.Platforms) == 0 {
+			return nil, false
+		}
+		if supported == nil {
+			supported = sets.NewString(is.Platforms...)
+		} else {
+			supported = supported.Intersection(sets.NewString(is.Platforms...))
+		}
+	}
+	return supported, supported != nil
+}
+
+// SupportsNode returns whether all of the WarmImage's images support the
+// platform of the node, which we assume when we don't know what they
+// support.
+func SupportsNode(wi *warmimagev3.WarmImage, node *corev1.Node) bool {
+	supported, ok := SupportedPlatforms(wi)
+	if !ok {
+		return true
+	}
+	os, arch := NodePlatform(node)
+	return supported.Has(os + "/" + arch)
+}
+
+// SelectsNoNodes returns whether the WarmImage's required node affinity has
+// only empty terms, which match no nodes, so that there is nothing to warm.
+func SelectsNoNodes(wi *warmimagev3.WarmImage) bool {
+	if wi.Spec.NodeAffinity == nil || wi.Spec.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return false
+	}
+	for _, term := range wi.Spec.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		if len(term.MatchExpressions) > 0 || len(term.MatchFields) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// requireArchitecture restricts the pods with the given spec to the nodes of
+// the given architecture, and to the operating systems that the WarmImage's
+// images support on it, if we know those. Either the well-known labels or
+// their beta predecessors may match. It returns false, leaving the spec be,
+// when the spec's required node affinity has only empty terms: these match
+// no nodes, and the API rejects a node affinity without any terms, so there
+// is no pod to make for the architecture.
+func requireArchitecture(spec *corev1.PodSpec, wi *warmimagev3.WarmImage, arch string) bool {
+	var oses []string
+	if supported, ok := SupportedPlatforms(wi); ok {
+		for _, platform := range supported.List() {
+			if parts := strings.SplitN(platform, "/", 2); len(parts) == 2 && parts[1] == arch {
+				oses = append(oses, parts[0])
+			}
+		}
+	}
+
+	var affinity *corev1.Affinity
+	if spec.Affinity != nil {
+		affinity = spec.Affinity.DeepCopy()
+	} else {
+		affinity = &corev1.Affinity{}
+	}
+	if affinity.NodeAffinity == nil {
+		affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	// The terms are ORed, so add our requirements to each of them.
+	base := []corev1.NodeSelectorTerm{{}}
+	if ns := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution; ns != nil {
+		base = nil
+		for _, term := range ns.NodeSelectorTerms {
+			// An empty term matches no nodes, and should keep not matching.
+			if len(term.MatchExpressions) > 0 || len(term.MatchFields) > 0 {
+				base = append(base, term)
+			}
+		}
+		if len(base) == 0 {
+			return false
+		}
+	}
+	var terms []corev1.NodeSelectorTerm
+	for _, term := range base {
+		for _, keys := range [][2]string{{ArchLabel, OSLabel}, {betaArchLabel, betaOSLabel}} {
+			t := term.DeepCopy()
+			t.MatchExpressions = append(t.MatchExpressions, corev1.NodeSelectorRequirement{
+				Key:      keys[0],
+				Operator: corev1.NodeSelectorOpIn,
+				Values:   []string{arch},
+			})
+			if len(oses) > 0 {
+				t.MatchExpressions = append(t.MatchExpressions, corev1.NodeSelectorRequirement{
+					Key:      keys[1],
+					Operator: corev1.NodeSelectorOpIn,
+					Values:   oses,
+				})
+			}
+			terms = append(terms, *t)
+		}
+	}
+	affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{
+		NodeSelectorTerms: terms,
+	}
+	spec.Affinity = affinity
+	return true
+}
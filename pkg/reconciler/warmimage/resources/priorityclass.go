@@ -0,0 +1,57 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	schedulingv1beta1 "k8s.io/api/scheduling/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/config"
+)
+
+const (
+	// HighPriorityClassName is the name of the PriorityClass that the
+	// controller manages for the warm pods of High priority images.
+	HighPriorityClassName = "warmimage-high"
+
+	// HighPriorityValue is the priority of the warm pods of High priority
+	// images. It is well above that of pods without a priority class, so
+	// that the scheduler places the warm pods on a new node first, and well
+	// below the system-critical priorities.
+	HighPriorityValue = 1000000
+)
+
+// MakeHighPriorityClass makes the PriorityClass of the warm pods of High
+// priority images.
+func MakeHighPriorityClass() *schedulingv1beta1.PriorityClass {
+	return &schedulingv1beta1.PriorityClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: HighPriorityClassName,
+		},
+		Value:       HighPriorityValue,
+		Description: "The priority of the pods that warm the images of High priority WarmImages.",
+	}
+}
+
+// priorityClassName returns the priority class of the WarmImage's warm pods.
+func priorityClassName(wi *warmimagev3.WarmImage, cfg *config.Config) string {
+	if wi.Spec.Priority == warmimagev3.WarmImagePriorityHigh {
+		return HighPriorityClassName
+	}
+	return cfg.PriorityClassName
+}
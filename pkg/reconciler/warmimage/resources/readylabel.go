@@ -0,0 +1,74 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// ReadyLabelValue is the value of the ready labels, see ReadyLabel.
+	ReadyLabelValue = "ready"
+
+	// clusterReadyLabelPrefix prefixes the ready labels of the
+	// ClusterWarmImages with the name of the ClusterWarmImage.
+	clusterReadyLabelPrefix = "clusterwarmimage.mattmoor.io/"
+
+	// readyLabelDomain follows the namespace of a WarmImage, and precedes
+	// its name, in the key of its ready label.
+	readyLabelDomain = ".warmimage.mattmoor.io/"
+)
+
+// ReadyLabel returns the key of the label with which we mark the nodes on
+// which the images of the WarmImage with the given namespace and name are
+// warm, as <namespace>.warmimage.mattmoor.io/<name>, or those of the
+// ClusterWarmImage with the given name when the namespace is empty, as
+// clusterwarmimage.mattmoor.io/<name>, so that workloads can require the
+// images in their node affinity. It returns "" when the name is too long for
+// a label key.
+func ReadyLabel(namespace, name string) string {
+	key := clusterReadyLabelPrefix + name
+	if namespace != "" {
+		key = namespace + readyLabelDomain + name
+	}
+	if len(validation.IsQualifiedName(key)) != 0 {
+		return ""
+	}
+	return key
+}
+
+// IsReadyLabel returns whether the key is that of a ready label.
+func IsReadyLabel(key string) bool {
+	return strings.HasPrefix(key, clusterReadyLabelPrefix) || strings.Contains(key, readyLabelDomain)
+}
+
+// TargetingLabels returns the labels of the node other than the ready
+// labels, which are those that decide which images we warm onto it, so that
+// labeling a node with the images that are warm on it doesn't make us
+// revisit every WarmImage.
+func TargetingLabels(node *corev1.Node) map[string]string {
+	targeting := make(map[string]string, len(node.Labels))
+	for k, v := range node.Labels {
+		if !IsReadyLabel(k) {
+			targeting[k] = v
+		}
+	}
+	return targeting
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+)
+
+// MakeSecretName returns the name of the copy of the given image pull secret
+// of a ClusterWarmImage in the system namespace. The secret is identified by
+// a hash of its reference, since joining its namespace and name could both
+// collide and run past the limit on names.
+func MakeSecretName(cwi *warmimagev3.ClusterWarmImage, ref corev1.SecretReference) string {
+	return makeName(cwi.Name, "-"+shortHash(ref.Namespace+"/"+ref.Name))
+}
+
+// MakeSecret copies an image pull secret of a ClusterWarmImage into the
+// system namespace, so that its warm pods can pull with it.
+func MakeSecret(cwi *warmimagev3.ClusterWarmImage, namespace string, secret *corev1.Secret) *corev1.Secret {
+	ref := corev1.SecretReference{Namespace: secret.Namespace, Name: secret.Name}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      MakeSecretName(cwi, ref),
+			Namespace: namespace,
+			Labels: map[string]string{
+				"controller": string(cwi.UID),
+			},
+		},
+		Type: secret.Type,
+		Data: secret.Data,
+	}
+}
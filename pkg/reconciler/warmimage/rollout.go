@@ -0,0 +1,155 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package warmimage
+
+import (
+	"sort"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/resources"
+)
+
+const (
+	// defaultRolloutReadyPercent is the percentage of its nodes on which a
+	// new version must be warm before we remove the older versions.
+	defaultRolloutReadyPercent = 100
+
+	// defaultRolloutTimeout is how long we keep the older versions while
+	// waiting for the new version to become warm.
+	defaultRolloutTimeout = 10 * time.Minute
+)
+
+// rolloutPolicy returns how warm the new version of the WarmImage must be
+// before we remove the older versions, and for how long we wait for that, if
+// the WarmImage asks us to wait at all.
+func rolloutPolicy(wi *warmimagev3.WarmImage) (int32, time.Duration, bool) {
+	if wi.Spec.Rollout == nil {
+		return 0, 0, false
+	}
+	percent := int32(defaultRolloutReadyPercent)
+	if p := wi.Spec.Rollout.ReadyPercent; p != nil {
+		percent = *p
+	}
+	timeout := defaultRolloutTimeout
+	if t := wi.Spec.Rollout.Timeout; t != nil {
+		timeout = t.Duration
+	}
+	return percent, timeout, true
+}
+
+// nextRolloutCheck returns how long until the rollout of the WarmImage's new
+// version times out, if one is in progress.
+func nextRolloutCheck(wi *warmimagev3.WarmImage) (time.Duration, bool) {
+	_, timeout, ok := rolloutPolicy(wi)
+	if !ok || wi.Status.Rollout == nil {
+		return 0, false
+	}
+	return wi.Status.Rollout.StartTime.Add(timeout).Sub(time.Now()), true
+}
+
+// readyPercent returns the percentage of their nodes on which the
+// DaemonSets' pods are warm.
+func readyPercent(dss []*appsv1.DaemonSet) int32 {
+	var desired, ready int32
+	for _, ds := range dss {
+		if ds.Status.ObservedGeneration < ds.Generation {
+			// Its status doesn't reflect its current spec yet.
+			return 0
+		}
+		desired += ds.Status.DesiredNumberScheduled
+		ready += ds.Status.NumberReady
+	}
+	if desired == 0 {
+		return 100
+	}
+	return 100 * ready / desired
+}
+
+// rolloutStartTime returns when the first of the DaemonSets of the current
+// version was created, if any were.
+func rolloutStartTime(dss []*appsv1.DaemonSet) (metav1.Time, bool) {
+	var start metav1.Time
+	for _, ds := range dss {
+		if start.IsZero() || ds.CreationTimestamp.Before(&start) {
+			start = ds.CreationTimestamp
+		}
+	}
+	return start, !start.IsZero()
+}
+
+// reconcileOldVersions removes the DaemonSets of the WarmImage other than the
+// given ones of its current version: those of older versions, and those of
+// architectures that it no longer targets. When the WarmImage has a rollout
+// policy, we keep them until the current version is warm on enough nodes, or
+// until the rollout times out, so that no node goes cold in between.
+func (c *warmer) reconcileOldVersions(wi *warmimagev3.WarmImage, current []*appsv1.DaemonSet) error {
+	dss, err := c.daemonsetsLister.DaemonSets(wi.Namespace).List(resources.MakeControllerLabelSelector(wi.UID))
+	if err != nil {
+		return err
+	}
+	keep := make(map[string]bool, len(current))
+	for _, ds := range current {
+		keep[ds.Name] = true
+	}
+	var names []string
+	for _, old := range dss {
+		if old.DeletionTimestamp == nil && !keep[old.Name] {
+			names = append(names, old.Name)
+		}
+	}
+	sort.Strings(names)
+
+	if percent, timeout, ok := rolloutPolicy(wi); ok {
+		ready := readyPercent(current)
+		start, started := rolloutStartTime(current)
+		switch {
+		case len(names) == 0:
+			// There is nothing to roll out from.
+			wi.Status.MarkRolledOut()
+			return nil
+		case ready >= percent:
+			c.Logger.Infof("The current version is warm on %d%% of nodes, removing %q", ready, names)
+		case !started || time.Since(start.Time) >= timeout:
+			c.Logger.Warnf("The current version is only warm on %d%% of nodes after %v, removing %q anyway",
+				ready, timeout, names)
+		default:
+			wi.Status.MarkRollingOut(names, ready, start)
+			return nil
+		}
+	}
+
+	// Delete the DaemonSets that we no longer need.
+	propPolicy := metav1.DeletePropagationForeground
+	for _, name := range names {
+		err := c.kubeclientset.AppsV1().DaemonSets(wi.Namespace).Delete(name,
+			&metav1.DeleteOptions{PropagationPolicy: &propPolicy})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	if len(names) > 0 {
+		c.eventf(wi, corev1.EventTypeNormal, "Deleted", "Deleted the DaemonSets of older versions and other architectures: %q", names)
+	}
+	wi.Status.MarkRolledOut()
+	return nil
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package warmimage
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+)
+
+func TestReadyPercent(t *testing.T) {
+	withStatus := func(desired, ready int32, stale bool) *appsv1.DaemonSet {
+		ds := &appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Generation: 2},
+			Status: appsv1.DaemonSetStatus{
+				ObservedGeneration:     2,
+				DesiredNumberScheduled: desired,
+				NumberReady:            ready,
+			},
+		}
+		if stale {
+			ds.Status.ObservedGeneration = 1
+		}
+		return ds
+	}
+
+	tests := []struct {
+		name string
+		dss  []*appsv1.DaemonSet
+		want int32
+	}{{
+		name: "no DaemonSets",
+		want: 100,
+	}, {
+		name: "no nodes",
+		dss:  []*appsv1.DaemonSet{withStatus(0, 0, false)},
+		want: 100,
+	}, {
+		name: "warm everywhere",
+		dss:  []*appsv1.DaemonSet{withStatus(3, 3, false), withStatus(1, 1, false)},
+		want: 100,
+	}, {
+		name: "across architectures",
+		dss:  []*appsv1.DaemonSet{withStatus(3, 1, false), withStatus(1, 1, false)},
+		want: 50,
+	}, {
+		name: "rounds down",
+		dss:  []*appsv1.DaemonSet{withStatus(3, 2, false)},
+		want: 66,
+	}, {
+		name: "status of an older generation",
+		dss:  []*appsv1.DaemonSet{withStatus(3, 3, false), withStatus(1, 1, true)},
+		want: 0,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := readyPercent(test.dss); got != test.want {
+				t.Errorf("readyPercent() = %d, wanted %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReconcileOldVersions(t *testing.T) {
+	percent := func(p int32) *int32 { return &p }
+	withRollout := func(rollout *warmimagev3.WarmImageRollout) *warmimagev3.WarmImage {
+		wi := makeWarmImage("busybox")
+		wi.Spec.Rollout = rollout
+		return wi
+	}
+	// current is warm on the given number of its 3 nodes, and was created
+	// the given time ago.
+	current := func(ready int32, age time.Duration) *appsv1.DaemonSet {
+		ds := makeDaemonSet(makeWarmImage("busybox"), "amd64", ready)
+		ds.CreationTimestamp = metav1.NewTime(time.Now().Add(-age))
+		return ds
+	}
+	old := makeDaemonSet(makeWarmImage("ubuntu"), "amd64", 3)
+	otherArch := makeDaemonSet(makeWarmImage("busybox"), "arm64", 3)
+	deleting := makeDaemonSet(makeWarmImage("alpine"), "amd64", 3)
+	deleting.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+
+	tests := []struct {
+		name        string
+		wi          *warmimagev3.WarmImage
+		current     *appsv1.DaemonSet
+		others      []*appsv1.DaemonSet
+		wantDeleted []string
+		// wantRollout is the rollout status that we report, if any.
+		wantRollout *warmimagev3.WarmImageRolloutStatus
+	}{{
+		name:        "deletes the older versions right away",
+		wi:          withRollout(nil),
+		current:     current(0, time.Minute),
+		others:      []*appsv1.DaemonSet{old, otherArch},
+		wantDeleted: []string{otherArch.Name, old.Name},
+	}, {
+		name:    "leaves the DaemonSets being deleted be",
+		wi:      withRollout(nil),
+		current: current(0, time.Minute),
+		others:  []*appsv1.DaemonSet{deleting},
+	}, {
+		name:    "nothing to roll out from",
+		wi:      withRollout(&warmimagev3.WarmImageRollout{}),
+		current: current(0, time.Minute),
+	}, {
+		name:    "keeps the older versions until the current one is warm",
+		wi:      withRollout(&warmimagev3.WarmImageRollout{}),
+		current: current(2, time.Minute),
+		others:  []*appsv1.DaemonSet{old},
+		wantRollout: &warmimagev3.WarmImageRolloutStatus{
+			OldDaemonSetNames: []string{old.Name},
+			ReadyPercent:      66,
+		},
+	}, {
+		name:        "deletes the older versions once the current one is warm",
+		wi:          withRollout(&warmimagev3.WarmImageRollout{}),
+		current:     current(3, time.Minute),
+		others:      []*appsv1.DaemonSet{old},
+		wantDeleted: []string{old.Name},
+	}, {
+		name:        "deletes the older versions once the current one is warm enough",
+		wi:          withRollout(&warmimagev3.WarmImageRollout{ReadyPercent: percent(50)}),
+		current:     current(2, time.Minute),
+		others:      []*appsv1.DaemonSet{old},
+		wantDeleted: []string{old.Name},
+	}, {
+		name:        "deletes the older versions once the rollout times out",
+		wi:          withRollout(&warmimagev3.WarmImageRollout{}),
+		current:     current(2, time.Hour),
+		others:      []*appsv1.DaemonSet{old},
+		wantDeleted: []string{old.Name},
+	}, {
+		name:    "waits for the given timeout",
+		wi:      withRollout(&warmimagev3.WarmImageRollout{Timeout: &metav1.Duration{Duration: 2 * time.Hour}}),
+		current: current(2, time.Hour),
+		others:  []*appsv1.DaemonSet{old},
+		wantRollout: &warmimagev3.WarmImageRolloutStatus{
+			OldDaemonSetNames: []string{old.Name},
+			ReadyPercent:      66,
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dss := append([]*appsv1.DaemonSet{test.current}, test.others...)
+			kube := newFakeKubeClient(dss...)
+			c := newTestWarmer(kube, []*corev1.Node{readyNode("the-node", "amd64")}, dss)
+
+			// A rollout that was in progress is over unless we say so.
+			test.wi.Status.MarkRollingOut([]string{"stale"}, 0, metav1.Now())
+			if err := c.reconcileOldVersions(test.wi, []*appsv1.DaemonSet{test.current}); err != nil {
+				t.Fatalf("reconcileOldVersions() = %v", err)
+			}
+			// We delete them in the order of their names.
+			sort.Strings(test.wantDeleted)
+			if !reflect.DeepEqual(kube.deleted, test.wantDeleted) {
+				t.Errorf("deleted = %q, wanted %q", kube.deleted, test.wantDeleted)
+			}
+			got := test.wi.Status.Rollout
+			if got != nil {
+				if !got.StartTime.Equal(&test.current.CreationTimestamp) {
+					t.Errorf("StartTime = %v, wanted %v", got.StartTime, test.current.CreationTimestamp)
+				}
+				got = got.DeepCopy()
+				got.StartTime = metav1.Time{}
+			}
+			if !reflect.DeepEqual(got, test.wantRollout) {
+				t.Errorf("Rollout = %+v, wanted %+v", got, test.wantRollout)
+			}
+		})
+	}
+}
@@ -0,0 +1,548 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package warmimage
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/conversion"
+	"k8s.io/apimachinery/pkg/labels"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	appsv1listers "k8s.io/client-go/listers/apps/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	schedulingv1beta1listers "k8s.io/client-go/listers/scheduling/v1beta1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+	listers "github.com/mattmoor/warm-image/pkg/client/listers/warmimage/v3"
+	"github.com/mattmoor/warm-image/pkg/metrics"
+	"github.com/mattmoor/warm-image/pkg/priorityclass"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/config"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/resources"
+	"github.com/mattmoor/warm-image/pkg/registry"
+)
+
+// minRefreshInterval is the shortest interval at which we will re-resolve an
+// image's tag, so that we don't hammer registries.
+const minRefreshInterval = time.Minute
+
+// semantic compares Kubernetes objects by meaning rather than by
+// representation, like k8s.io/apimachinery/pkg/api/equality.Semantic.
+var semantic = conversion.EqualitiesOrDie(
+	func(a, b resource.Quantity) bool {
+		return a.Cmp(b) == 0
+	},
+	func(a, b metav1.Time) bool {
+		return a.UTC() == b.UTC()
+	},
+)
+
+// warmer holds what the WarmImage and ClusterWarmImage reconcilers share to
+// warm images onto nodes. Both operate on a WarmImage: a ClusterWarmImage is
+// warmed as a WarmImage in the system namespace.
+type warmer struct {
+	// kubeclientset is a standard kubernetes clientset
+	kubeclientset kubernetes.Interface
+
+	daemonsetsLister appsv1listers.DaemonSetLister
+	podsLister       corev1listers.PodLister
+	nodesLister      corev1listers.NodeLister
+	// secretsLister lists the secrets in the agent namespace only.
+	secretsLister corev1listers.SecretLister
+
+	// priorityclassclient manages the PriorityClass of the warm pods of
+	// High priority images.
+	priorityclassclient   priorityclass.Interface
+	priorityClassesLister schedulingv1beta1listers.PriorityClassLister
+
+	// warmimagesLister and clusterwarmimagesLister list every WarmImage and
+	// ClusterWarmImage, whose images we leave be when cooling down another.
+	warmimagesLister        listers.WarmImageLister
+	clusterwarmimagesLister listers.ClusterWarmImageLister
+
+	// resolver resolves the tags of the images we warm to digests.
+	resolver registry.Resolver
+
+	// systemNamespace is the namespace in which we warm ClusterWarmImages.
+	systemNamespace string
+	// agentNamespace is the namespace in which we keep the secrets that the
+	// node agents pull with, and nothing else, as the agents may read them.
+	agentNamespace string
+
+	// configStore holds the configuration of the warm pods, from the
+	// config-warmimage ConfigMap.
+	configStore *config.Store
+
+	// kind is the kind of the objects whose images we warm, on which we
+	// record Events.
+	kind     string
+	recorder record.EventRecorder
+	metrics  *metrics.Metrics
+
+	// Sugared logger is easier to use but is not as performant as the
+	// raw logger. In performance critical paths, call logger.Desugar()
+	// and use the returned raw logger instead. In addition to the
+	// performance benefits, raw logger also preserves type-safety at
+	// the expense of slightly greater verbosity.
+	Logger *zap.SugaredLogger
+}
+
+// newRecorder returns an EventRecorder that records Events from the named
+// component, which `kubectl describe` shows on the objects they are about.
+func newRecorder(logger *zap.SugaredLogger, kubeclientset kubernetes.Interface, component string) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartLogging(logger.Named("event-broadcaster").Infof)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeclientset.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: component})
+}
+
+// eventf records an Event on the object whose images the WarmImage warms,
+// which for a ClusterWarmImage isn't the WarmImage itself.
+func (c *warmer) eventf(wi *warmimagev3.WarmImage, eventtype, reason, messageFmt string, args ...interface{}) {
+	ref := &corev1.ObjectReference{
+		APIVersion:      warmimagev3.SchemeGroupVersion.String(),
+		Kind:            c.kind,
+		Name:            wi.Name,
+		UID:             wi.UID,
+		ResourceVersion: wi.ResourceVersion,
+	}
+	if c.kind == "WarmImage" {
+		// Otherwise it is cluster-scoped.
+		ref.Namespace = wi.Namespace
+	}
+	c.recorder.Eventf(ref, eventtype, reason, messageFmt, args...)
+}
+
+// watchNodes calls joined when a node joins the cluster or becomes eligible
+// for warming, which new nodes usually do once they are Ready, so that we can
+// warm the images onto it right away. It calls resync when nodes leave the
+// cluster, or when their labels or taints change, which changes the nodes
+// that we warm the images onto and their architectures. It calls changed
+// with what changed when the images that a node reports having or its node
+// agent's reports change, so that we reconcile only the WarmImages that the
+// change affects, rather than all of them on every pull on every node.
+func watchNodes(nodeInformer corev1informers.NodeInformer, joined func(*corev1.Node), changed func(*nodeChange), resync func()) {
+	nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if node, ok := obj.(*corev1.Node); ok {
+				joined(node)
+			}
+		},
+		UpdateFunc: func(old, new interface{}) {
+			oldNode, ok := old.(*corev1.Node)
+			if !ok {
+				return
+			}
+			newNode, ok := new.(*corev1.Node)
+			if !ok {
+				return
+			}
+			_, wasIneligible := oldNode.Labels[resources.IneligibleLabel]
+			_, isIneligible := newNode.Labels[resources.IneligibleLabel]
+			if wasIneligible && !isIneligible {
+				joined(newNode)
+				return
+			}
+			// Ignore the frequent updates to the status of the nodes,
+			// other than to the images that they report, and the ready
+			// labels with which we mark the nodes.
+			if !reflect.DeepEqual(resources.TargetingLabels(oldNode), resources.TargetingLabels(newNode)) ||
+				!reflect.DeepEqual(oldNode.Spec.Taints, newNode.Spec.Taints) {
+				resync()
+				return
+			}
+			if nc := diffNode(oldNode, newNode); nc != nil {
+				changed(nc)
+			}
+		},
+		DeleteFunc: func(interface{}) { resync() },
+	})
+}
+
+// warm warms the images of the WarmImage onto its nodes, and reports on how
+// that is going in its status. The DaemonSet that warms the images is
+// controlled by the given owner, if any.
+func (c *warmer) warm(ctx context.Context, wi *warmimagev3.WarmImage, owner *metav1.OwnerReference) error {
+	// Note when the images went cold, so that we can tell how long they took
+	// to warm up.
+	wasReady, coldSince := wi.Status.IsReady(), wi.CreationTimestamp
+	if cond := wi.Status.GetCondition(warmimagev3.WarmImageConditionReady); cond != nil {
+		coldSince = cond.LastTransitionTime
+	}
+	defer func() {
+		if !wasReady && wi.Status.IsReady() {
+			c.metrics.ObserveTimeToWarm(c.kind, time.Since(coldSince.Time))
+			c.eventf(wi, corev1.EventTypeNormal, "Warm", "The images are warm on all %d nodes.", wi.Status.ReadyNodes)
+		}
+	}()
+	oldFailures := wi.Status.Failures
+
+	wi.Status.InitializeImages(wi.Spec.Images)
+	if len(wi.Spec.Images) == 0 {
+		// There is nothing to warm, and retrying won't change that.
+		wi.Status.MarkFailed("NoImages", "No images were specified.")
+		return nil
+	}
+	if delay, ok := nextResolveRetry(wi); ok && delay > 0 {
+		// The last try failed, as the status still says, so leave the
+		// registry be until the retry that Reconcile schedules.
+		return nil
+	}
+	if err := c.reconcileDigests(ctx, wi); err != nil {
+		wi.Status.MarkResolveFailed(wi.Generation)
+		wi.Status.MarkFailed("ResolveFailed", "Unable to resolve images: %v", err)
+		c.eventf(wi, corev1.EventTypeWarning, "ResolveFailed", "Unable to resolve images: %v", err)
+		return err
+	}
+	if err := c.reconcilePlatforms(wi); err != nil {
+		return err
+	}
+	if err := c.reconcileEligibility(wi); err != nil {
+		return err
+	}
+	if err := c.reconcilePriorityClass(wi); err != nil {
+		wi.Status.MarkFailed("PriorityClassFailed", "Unable to reconcile PriorityClass: %v", err)
+		c.eventf(wi, corev1.EventTypeWarning, "PriorityClassFailed", "Unable to reconcile PriorityClass: %v", err)
+		return err
+	}
+
+	warmImages := c.pin
+	switch wi.Spec.Strategy {
+	case warmimagev3.WarmImageStrategyOneShot:
+		warmImages = c.pullOnce
+	case warmimagev3.WarmImageStrategyNodeAgent:
+		warmImages = c.delegate
+	}
+	images, err := c.listNodeImages()
+	if err != nil {
+		return err
+	}
+	if err := warmImages(ctx, wi, owner, images); err != nil {
+		return err
+	}
+	if resources.SelectsNoNodes(wi) {
+		// This isn't an error to retry, but it isn't ready either.
+		wi.Status.MarkFailed("NoNodesSelected",
+			"The nodeSelectorTerms of the nodeAffinity are all empty, so no nodes are selected.")
+	} else if err := c.markUntargeted(wi); err != nil {
+		return err
+	}
+	if err := c.propagatePresence(wi, images); err != nil {
+		return err
+	}
+	c.recordFailures(wi, oldFailures)
+
+	wi.Status.ObservedGeneration = wi.Generation
+	return nil
+}
+
+// pin keeps the images warm with a DaemonSet per architecture of the targeted
+// nodes, which runs a pod using them on each of those nodes, and reports on
+// those pods.
+func (c *warmer) pin(ctx context.Context, wi *warmimagev3.WarmImage, owner *metav1.OwnerReference, images map[string]*nodeImages) error {
+	// Remove any one-shot pods from before the images were pinned.
+	if err := c.deleteOneShotPods(wi); err != nil {
+		wi.Status.MarkFailed("PodsFailed", "Unable to delete one-shot pods: %v", err)
+		c.eventf(wi, corev1.EventTypeWarning, "PodsFailed", "Unable to delete one-shot pods: %v", err)
+		return err
+	}
+
+	dss, err := c.reconcileDaemonSets(ctx, wi, owner)
+	if err != nil {
+		wi.Status.MarkFailed("DaemonSetFailed", "Unable to reconcile DaemonSet: %v", err)
+		c.eventf(wi, corev1.EventTypeWarning, "DaemonSetFailed", "Unable to reconcile DaemonSet: %v", err)
+		return err
+	}
+	wi.Status.PropagateDaemonSetStatuses(dss)
+
+	var pods []*corev1.Pod
+	for _, ds := range dss {
+		// Select the pods through the DaemonSet, since an adopted
+		// DaemonSet still labels its pods with the version it was created
+		// for.
+		selector, err := metav1.LabelSelectorAsSelector(ds.Spec.Selector)
+		if err != nil {
+			return err
+		}
+		dsPods, err := c.podsLister.Pods(wi.Namespace).List(selector)
+		if err != nil {
+			return err
+		}
+		pods = append(pods, dsPods...)
+	}
+	return c.propagatePods(wi, pods, images)
+}
+
+// reconcileDaemonSets makes sure that a DaemonSet warms the current version
+// of the WarmImage onto the nodes of each of the targeted architectures, and
+// removes the DaemonSets that it no longer needs.
+func (c *warmer) reconcileDaemonSets(ctx context.Context, wi *warmimagev3.WarmImage, owner *metav1.OwnerReference) ([]*appsv1.DaemonSet, error) {
+	archs, err := c.architectures(wi)
+	if err != nil {
+		return nil, err
+	}
+	dss := make([]*appsv1.DaemonSet, 0, len(archs))
+	for _, arch := range archs {
+		ds, err := c.reconcileDaemonSet(ctx, wi, arch, owner)
+		if err != nil {
+			return nil, err
+		} else if ds == nil {
+			// There is nothing to warm onto this architecture, see
+			// resources.SelectsNoNodes.
+			c.Logger.Infof("Skipping architecture %q, onto which no nodes are selected", arch)
+			continue
+		}
+		dss = append(dss, ds)
+	}
+	if err := c.reconcileOldVersions(wi, dss); err != nil {
+		return nil, err
+	}
+	return dss, nil
+}
+
+func (c *warmer) reconcileDaemonSet(ctx context.Context, wi *warmimagev3.WarmImage, arch string, owner *metav1.OwnerReference) (*appsv1.DaemonSet, error) {
+	// Make sure the desired images are warmed up ASAP.
+	selector := labels.SelectorFromSet(resources.MakeDaemonSetLabels(wi, arch))
+	dss, err := c.daemonsetsLister.DaemonSets(wi.Namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	desired := resources.MakeDaemonSet(wi, arch, owner, c.configStore.Get())
+	if desired == nil {
+		return nil, nil
+	}
+	var ds *appsv1.DaemonSet
+	switch {
+	// If none exist, adopt an older version that already runs what we
+	// want, or else create one.
+	case len(dss) == 0:
+		ds, err = c.adoptDaemonSet(wi, desired)
+		if err != nil {
+			return nil, err
+		} else if ds != nil {
+			break
+		}
+		ds, err = c.createDaemonSet(wi, desired)
+		if err != nil {
+			return nil, err
+		}
+
+	// If multiple exist, delete all but one.
+	case len(dss) > 1:
+		ds, err = c.dedupeDaemonSets(wi, dss)
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		ds = dss[0]
+	}
+
+	// Repair the DaemonSet if it has drifted from what we want, e.g. if
+	// someone edited its pod template or our configuration changed. We leave
+	// the labels alone, since they must match the selector.
+	if hasDrifted(&desired.Spec.Template.Spec, &ds.Spec.Template.Spec) ||
+		ds.Spec.UpdateStrategy.Type != desired.Spec.UpdateStrategy.Type {
+		want := ds.DeepCopy()
+		want.Spec.Template.Spec = desired.Spec.Template.Spec
+		want.Spec.UpdateStrategy = desired.Spec.UpdateStrategy
+		ds, err = c.kubeclientset.AppsV1().DaemonSets(wi.Namespace).Update(want)
+		if err != nil {
+			return nil, err
+		}
+		c.Logger.Infof("Repaired drift in DaemonSet %q", ds.Name)
+		c.eventf(wi, corev1.EventTypeNormal, "Updated", "Repaired drift in DaemonSet %q", ds.Name)
+	}
+	return ds, nil
+}
+
+// hasDrifted returns whether the live pod spec differs from the desired one.
+// We only compare the fields that we set, so that the defaults filled in by
+// the API server aren't drift, but we also notice when we stop setting the
+// priority class or tolerations.
+func hasDrifted(desired, live *corev1.PodSpec) bool {
+	return !semantic.DeepDerivative(desired, live) ||
+		desired.PriorityClassName != live.PriorityClassName ||
+		len(desired.Tolerations) != len(live.Tolerations)
+}
+
+// pulledImages returns the images that the pods with the given spec pull,
+// sorted, with the tags of the WarmImage's images resolved to the digests
+// that we pin them to, since older pods ran them by tag.
+func pulledImages(wi *warmimagev3.WarmImage, spec *corev1.PodSpec) []string {
+	pinned := make(map[string]string, len(wi.Spec.Images))
+	for _, image := range wi.Spec.Images {
+		pinned[image] = resources.UserImage(wi, image)
+	}
+	images := make([]string, 0, len(spec.Containers))
+	for _, c := range spec.Containers {
+		if p, ok := pinned[c.Image]; ok {
+			images = append(images, p)
+		} else {
+			images = append(images, c.Image)
+		}
+	}
+	sort.Strings(images)
+	return images
+}
+
+// adoptDaemonSet looks for a DaemonSet of an older version of the WarmImage
+// whose pods already pull what the desired one would, e.g. because only the
+// refreshInterval changed, or because it was labeled with a version from
+// before versions were hashes of the pod spec. If it finds one, it labels it
+// with the current version, so that we keep it instead of re-pulling the
+// images on every node, and the rest of its pod spec, e.g. the architecture
+// it is restricted to, is then repaired in place as drift.
+//
+// This is also how we migrate the DaemonSets that we created through
+// extensions/v1beta1: the API server serves the same objects through apps/v1,
+// so we adopt those that pull what we want, replace the rest as older
+// versions, and repair their pod spec and OnDelete update strategy as drift.
+func (c *warmer) adoptDaemonSet(wi *warmimagev3.WarmImage, desired *appsv1.DaemonSet) (*appsv1.DaemonSet, error) {
+	dss, err := c.daemonsetsLister.DaemonSets(wi.Namespace).List(resources.MakeControllerLabelSelector(wi.UID))
+	if err != nil {
+		return nil, err
+	}
+	arch := desired.Labels["arch"]
+	images := pulledImages(wi, &desired.Spec.Template.Spec)
+	var candidates []*appsv1.DaemonSet
+	for _, ds := range dss {
+		if ds.DeletionTimestamp != nil {
+			continue
+		}
+		// DaemonSets from before we had one per architecture have no
+		// arch label, and may be adopted for any of them.
+		if a, ok := ds.Labels["arch"]; ok && a != arch {
+			continue
+		}
+		if reflect.DeepEqual(images, pulledImages(wi, &ds.Spec.Template.Spec)) {
+			candidates = append(candidates, ds)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	sortDaemonSets(candidates)
+
+	want := candidates[0].DeepCopy()
+	want.Labels = desired.Labels
+	ds, err := c.kubeclientset.AppsV1().DaemonSets(wi.Namespace).Update(want)
+	if err != nil {
+		return nil, err
+	}
+	c.Logger.Infof("Adopted DaemonSet %q as version %q", ds.Name, desired.Labels["version"])
+	c.eventf(wi, corev1.EventTypeNormal, "Updated", "Adopted DaemonSet %q as version %q", ds.Name, desired.Labels["version"])
+	return ds, nil
+}
+
+// createDaemonSet creates the desired DaemonSet for this version of the
+// WarmImage. If our informer cache is stale and the DaemonSet already exists,
+// we use that one.
+func (c *warmer) createDaemonSet(wi *warmimagev3.WarmImage, ds *appsv1.DaemonSet) (*appsv1.DaemonSet, error) {
+	created, err := c.kubeclientset.AppsV1().DaemonSets(wi.Namespace).Create(ds)
+	if err == nil {
+		c.Logger.Infof("Warming up: %q, with %q", wi.Spec.Images, created.Name)
+		c.eventf(wi, corev1.EventTypeNormal, "Created", "Created DaemonSet %q", created.Name)
+		return created, nil
+	} else if !errors.IsAlreadyExists(err) {
+		return nil, err
+	}
+
+	existing, err := c.kubeclientset.AppsV1().DaemonSets(wi.Namespace).Get(ds.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if existing.Labels["controller"] != string(wi.UID) {
+		return nil, fmt.Errorf("DaemonSet %q already exists and is not ours", ds.Name)
+	}
+	return existing, nil
+}
+
+// dedupeDaemonSets deletes all but one of the DaemonSets for this version of
+// the WarmImage, and returns the one that it keeps. We keep the one with the
+// most warm pods, and then the oldest one, so that we disturb the nodes as
+// little as possible.
+func (c *warmer) dedupeDaemonSets(wi *warmimagev3.WarmImage, dss []*appsv1.DaemonSet) (*appsv1.DaemonSet, error) {
+	dss = append([]*appsv1.DaemonSet{}, dss...)
+	sortDaemonSets(dss)
+
+	propPolicy := metav1.DeletePropagationForeground
+	for _, ds := range dss[1:] {
+		c.Logger.Infof("Deleting duplicate DaemonSet %q, keeping %q", ds.Name, dss[0].Name)
+		err := c.kubeclientset.AppsV1().DaemonSets(wi.Namespace).Delete(ds.Name,
+			&metav1.DeleteOptions{PropagationPolicy: &propPolicy})
+		if errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		c.eventf(wi, corev1.EventTypeNormal, "Deleted", "Deleted duplicate DaemonSet %q", ds.Name)
+	}
+	return dss[0], nil
+}
+
+// sortDaemonSets sorts the DaemonSets that we would rather keep first: those
+// with the most warm pods, and then the oldest.
+func sortDaemonSets(dss []*appsv1.DaemonSet) {
+	sort.Slice(dss, func(i, j int) bool {
+		a, b := dss[i], dss[j]
+		if a.Status.NumberReady != b.Status.NumberReady {
+			return a.Status.NumberReady > b.Status.NumberReady
+		}
+		if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+			return a.CreationTimestamp.Before(&b.CreationTimestamp)
+		}
+		return a.Name < b.Name
+	})
+}
+
+// recordFailures records an Event for each way in which the images started
+// failing to warm since the given failures, e.g. because they can't be pulled.
+func (c *warmer) recordFailures(wi *warmimagev3.WarmImage, oldFailures []warmimagev3.WarmImageFailure) {
+	type failureKey struct {
+		reason, image string
+	}
+	seen := make(map[failureKey]bool, len(oldFailures))
+	for _, f := range oldFailures {
+		seen[failureKey{reason: f.Reason, image: f.Image}] = true
+	}
+	for _, f := range wi.Status.Failures {
+		if seen[failureKey{reason: f.Reason, image: f.Image}] {
+			continue
+		}
+		what := "the sleeper"
+		if f.Image != "" {
+			what = fmt.Sprintf("%q", f.Image)
+		}
+		c.eventf(wi, corev1.EventTypeWarning, f.Reason, "Failed to warm %s on %d nodes, including %q", what, f.Count, f.Nodes)
+	}
+}
@@ -0,0 +1,524 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package warmimage
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	typedappsv1 "k8s.io/client-go/kubernetes/typed/apps/v1"
+	appsv1listers "k8s.io/client-go/listers/apps/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/config"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/resources"
+)
+
+const (
+	testNamespace       = "default"
+	testSystemNamespace = "warmimage-system"
+)
+
+// fakeKubeClient serves the DaemonSets of testNamespace, and records what the
+// warmer does to them, which is all of the API that these tests exercise.
+type fakeKubeClient struct {
+	kubernetes.Interface
+
+	// daemonsets holds the DaemonSets by name.
+	daemonsets map[string]*appsv1.DaemonSet
+	// created, updated and deleted hold the names of the DaemonSets that
+	// were, in order.
+	created, updated, deleted []string
+}
+
+func newFakeKubeClient(dss ...*appsv1.DaemonSet) *fakeKubeClient {
+	c := &fakeKubeClient{daemonsets: make(map[string]*appsv1.DaemonSet, len(dss))}
+	for _, ds := range dss {
+		c.daemonsets[ds.Name] = ds
+	}
+	return c
+}
+
+func (c *fakeKubeClient) AppsV1() typedappsv1.AppsV1Interface {
+	return &fakeAppsV1{c: c}
+}
+
+type fakeAppsV1 struct {
+	typedappsv1.AppsV1Interface
+	c *fakeKubeClient
+}
+
+func (a *fakeAppsV1) DaemonSets(namespace string) typedappsv1.DaemonSetInterface {
+	return &fakeDaemonSets{c: a.c}
+}
+
+type fakeDaemonSets struct {
+	typedappsv1.DaemonSetInterface
+	c *fakeKubeClient
+}
+
+func (d *fakeDaemonSets) Create(ds *appsv1.DaemonSet) (*appsv1.DaemonSet, error) {
+	if _, ok := d.c.daemonsets[ds.Name]; ok {
+		return nil, apierrors.NewAlreadyExists(appsv1.Resource("daemonsets"), ds.Name)
+	}
+	d.c.daemonsets[ds.Name] = ds
+	d.c.created = append(d.c.created, ds.Name)
+	return ds, nil
+}
+
+func (d *fakeDaemonSets) Get(name string, opts metav1.GetOptions) (*appsv1.DaemonSet, error) {
+	ds, ok := d.c.daemonsets[name]
+	if !ok {
+		return nil, apierrors.NewNotFound(appsv1.Resource("daemonsets"), name)
+	}
+	return ds, nil
+}
+
+func (d *fakeDaemonSets) Update(ds *appsv1.DaemonSet) (*appsv1.DaemonSet, error) {
+	if _, ok := d.c.daemonsets[ds.Name]; !ok {
+		return nil, apierrors.NewNotFound(appsv1.Resource("daemonsets"), ds.Name)
+	}
+	d.c.daemonsets[ds.Name] = ds
+	d.c.updated = append(d.c.updated, ds.Name)
+	return ds, nil
+}
+
+func (d *fakeDaemonSets) Delete(name string, opts *metav1.DeleteOptions) error {
+	if _, ok := d.c.daemonsets[name]; !ok {
+		return apierrors.NewNotFound(appsv1.Resource("daemonsets"), name)
+	}
+	delete(d.c.daemonsets, name)
+	d.c.deleted = append(d.c.deleted, name)
+	return nil
+}
+
+// newTestWarmer returns a warmer whose listers serve the given nodes and
+// DaemonSets, and which talks to the given client.
+func newTestWarmer(kube *fakeKubeClient, nodes []*corev1.Node, dss []*appsv1.DaemonSet) *warmer {
+	nodeIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, node := range nodes {
+		nodeIndexer.Add(node)
+	}
+	dsIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, ds := range dss {
+		dsIndexer.Add(ds)
+	}
+	return &warmer{
+		kubeclientset:    kube,
+		daemonsetsLister: appsv1listers.NewDaemonSetLister(dsIndexer),
+		nodesLister:      corev1listers.NewNodeLister(nodeIndexer),
+		systemNamespace:  testSystemNamespace,
+		configStore:      config.NewStore(zap.NewNop().Sugar(), testSystemNamespace, config.New("the-sleeper", "the-node-agent")),
+		kind:             "WarmImage",
+		recorder:         record.NewFakeRecorder(100),
+		Logger:           zap.NewNop().Sugar(),
+	}
+}
+
+// readyNode returns a Ready node of the given architecture.
+func readyNode(name, arch string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				resources.OSLabel:   "linux",
+				resources.ArchLabel: arch,
+			},
+		},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{
+				Type:   corev1.NodeReady,
+				Status: corev1.ConditionTrue,
+			}},
+		},
+	}
+}
+
+// makeWarmImage returns a WarmImage of the given images, as we would
+// reconcile it.
+func makeWarmImage(images ...string) *warmimagev3.WarmImage {
+	wi := &warmimagev3.WarmImage{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: testNamespace,
+			Name:      "foo",
+			UID:       "the-uid",
+		},
+		Spec: warmimagev3.WarmImageSpec{
+			Images: images,
+		},
+	}
+	wi.SetDefaults()
+	wi.Status.InitializeImages(wi.Spec.Images)
+	return wi
+}
+
+// makeDaemonSet returns the DaemonSet that we would create for the WarmImage
+// and architecture, as the API server would return it: with its defaults
+// filled in, and the given number of warm pods.
+func makeDaemonSet(wi *warmimagev3.WarmImage, arch string, ready int32) *appsv1.DaemonSet {
+	ds := resources.MakeDaemonSet(wi, arch, nil, config.New("the-sleeper", "the-node-agent"))
+	ds.Namespace = wi.Namespace
+	withDefaults(ds)
+	ds.Status.DesiredNumberScheduled = 3
+	ds.Status.NumberReady = ready
+	return ds
+}
+
+// withDefaults fills in the defaults that the API server would.
+func withDefaults(ds *appsv1.DaemonSet) {
+	ds.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Hour))
+	ds.Generation = 1
+	ds.Status.ObservedGeneration = 1
+	maxUnavailable := intstr.FromInt(1)
+	ds.Spec.UpdateStrategy.RollingUpdate = &appsv1.RollingUpdateDaemonSet{MaxUnavailable: &maxUnavailable}
+	ds.Spec.RevisionHistoryLimit = int32Ptr(10)
+	spec := &ds.Spec.Template.Spec
+	spec.RestartPolicy = corev1.RestartPolicyAlways
+	spec.DNSPolicy = corev1.DNSClusterFirst
+	spec.SchedulerName = corev1.DefaultSchedulerName
+	spec.SecurityContext = &corev1.PodSecurityContext{}
+	spec.TerminationGracePeriodSeconds = int64Ptr(30)
+	for _, cs := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for i := range cs {
+			cs[i].TerminationMessagePath = corev1.TerminationMessagePathDefault
+			cs[i].TerminationMessagePolicy = corev1.TerminationMessageReadFile
+			if cs[i].ImagePullPolicy == "" {
+				cs[i].ImagePullPolicy = corev1.PullIfNotPresent
+			}
+		}
+	}
+}
+
+func int32Ptr(i int32) *int32 { return &i }
+func int64Ptr(i int64) *int64 { return &i }
+
+func TestHasDrifted(t *testing.T) {
+	wi := makeWarmImage("busybox")
+	desired := resources.MakeDaemonSet(wi, "amd64", nil, config.New("the-sleeper", "the-node-agent")).Spec.Template.Spec
+	defaulted := makeDaemonSet(wi, "amd64", 0).Spec.Template.Spec
+
+	tests := []struct {
+		name    string
+		desired corev1.PodSpec
+		live    func(*corev1.PodSpec)
+		want    bool
+	}{{
+		name:    "unchanged",
+		desired: desired,
+		want:    false,
+	}, {
+		name:    "the API server's defaults",
+		desired: desired,
+		live: func(spec *corev1.PodSpec) {
+			*spec = *defaulted.DeepCopy()
+		},
+		want: false,
+	}, {
+		name:    "another image",
+		desired: desired,
+		live: func(spec *corev1.PodSpec) {
+			spec.Containers[0].Image = "ubuntu"
+		},
+		want: true,
+	}, {
+		name:    "another sleeper",
+		desired: desired,
+		live: func(spec *corev1.PodSpec) {
+			spec.InitContainers[0].Image = "another-sleeper"
+		},
+		want: true,
+	}, {
+		name:    "no longer restricted to the architecture",
+		desired: desired,
+		live: func(spec *corev1.PodSpec) {
+			spec.Affinity = nil
+		},
+		want: true,
+	}, {
+		name:    "a priority class we no longer set",
+		desired: desired,
+		live: func(spec *corev1.PodSpec) {
+			spec.PriorityClassName = "warmimage-high"
+		},
+		want: true,
+	}, {
+		name:    "tolerations we no longer set",
+		desired: desired,
+		live: func(spec *corev1.PodSpec) {
+			spec.Tolerations = append(spec.Tolerations, corev1.Toleration{Key: "foo", Operator: corev1.TolerationOpExists})
+		},
+		want: true,
+	}, {
+		name: "the same quantity, written differently",
+		desired: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name: "the-image",
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+				},
+			}},
+		},
+		live: func(spec *corev1.PodSpec) {
+			spec.Containers[0].Resources.Requests[corev1.ResourceCPU] = resource.MustParse("0.1")
+		},
+		want: false,
+	}, {
+		name: "another quantity",
+		desired: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name: "the-image",
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+				},
+			}},
+		},
+		live: func(spec *corev1.PodSpec) {
+			spec.Containers[0].Resources.Requests[corev1.ResourceCPU] = resource.MustParse("200m")
+		},
+		want: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			live := test.desired.DeepCopy()
+			if test.live != nil {
+				test.live(live)
+			}
+			if got := hasDrifted(&test.desired, live); got != test.want {
+				t.Errorf("hasDrifted() = %v, wanted %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReconcileDaemonSet(t *testing.T) {
+	wi := makeWarmImage("busybox")
+	current := resources.MakeDaemonSetName(wi, "amd64")
+
+	// The DaemonSet of the WarmImage from before it warmed ubuntu.
+	oldWI := makeWarmImage("ubuntu")
+	old := makeDaemonSet(oldWI, "amd64", 3)
+
+	// A DaemonSet from before versions were hashes of the pod spec, and we
+	// had one per architecture.
+	legacy := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: testNamespace,
+			Name:      "foo-legacy",
+			Labels: map[string]string{
+				"controller": "the-uid",
+				"version":    "1",
+			},
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name:  "the-image",
+						Image: "busybox",
+					}},
+				},
+			},
+		},
+	}
+	withDefaults(legacy)
+
+	withName := func(ds *appsv1.DaemonSet, name string) *appsv1.DaemonSet {
+		ds = ds.DeepCopy()
+		ds.Name = name
+		return ds
+	}
+	withLabel := func(ds *appsv1.DaemonSet, key, value string) *appsv1.DaemonSet {
+		ds = ds.DeepCopy()
+		ds.Labels[key] = value
+		return ds
+	}
+	pullingOther := legacy.DeepCopy()
+	pullingOther.Name = "foo-other"
+	pullingOther.Spec.Template.Spec.Containers[0].Image = "ubuntu"
+	olderBy := func(ds *appsv1.DaemonSet, d time.Duration) *appsv1.DaemonSet {
+		ds = ds.DeepCopy()
+		ds.CreationTimestamp = metav1.NewTime(ds.CreationTimestamp.Add(-d))
+		return ds
+	}
+	drifted := makeDaemonSet(wi, "amd64", 3)
+	drifted.Spec.Template.Spec.InitContainers[0].Image = "another-sleeper"
+	onDelete := makeDaemonSet(wi, "amd64", 3)
+	onDelete.Spec.UpdateStrategy = appsv1.DaemonSetUpdateStrategy{Type: appsv1.OnDeleteDaemonSetStrategyType}
+
+	tests := []struct {
+		name string
+		// existing are known to both the lister and the API server, and
+		// unlisted only to the API server, as when our cache is stale.
+		existing []*appsv1.DaemonSet
+		unlisted []*appsv1.DaemonSet
+		want     string
+		// wantCreated, wantUpdated and wantDeleted are the names of the
+		// DaemonSets that we create, update and delete, in order.
+		wantCreated []string
+		wantUpdated []string
+		wantDeleted []string
+	}{{
+		name:        "creates the DaemonSet",
+		want:        current,
+		wantCreated: []string{current},
+	}, {
+		name:     "keeps the DaemonSet with the API server's defaults",
+		existing: []*appsv1.DaemonSet{makeDaemonSet(wi, "amd64", 3)},
+		want:     current,
+	}, {
+		name:        "creates the DaemonSet of a new version under a new name",
+		existing:    []*appsv1.DaemonSet{old},
+		want:        current,
+		wantCreated: []string{current},
+	}, {
+		name:     "uses the DaemonSet missing from our cache",
+		unlisted: []*appsv1.DaemonSet{makeDaemonSet(wi, "amd64", 3)},
+		want:     current,
+	}, {
+		name:        "repairs a drifted pod spec",
+		existing:    []*appsv1.DaemonSet{drifted},
+		want:        current,
+		wantUpdated: []string{current},
+	}, {
+		name:        "repairs the update strategy",
+		existing:    []*appsv1.DaemonSet{onDelete},
+		want:        current,
+		wantUpdated: []string{current},
+	}, {
+		// It is labeled with the current version, and then its pod spec
+		// repaired, e.g. restricted to the architecture.
+		name:        "adopts a legacy DaemonSet that pulls the images",
+		existing:    []*appsv1.DaemonSet{legacy},
+		want:        "foo-legacy",
+		wantUpdated: []string{"foo-legacy", "foo-legacy"},
+	}, {
+		name: "adopts the older version with the most warm pods",
+		existing: []*appsv1.DaemonSet{
+			withLabel(withName(makeDaemonSet(wi, "amd64", 1), "foo-a"), "version", "stale"),
+			withLabel(withName(makeDaemonSet(wi, "amd64", 2), "foo-b"), "version", "stale"),
+		},
+		want:        "foo-b",
+		wantUpdated: []string{"foo-b"},
+	}, {
+		name:        "doesn't adopt a DaemonSet that pulls other images",
+		existing:    []*appsv1.DaemonSet{pullingOther},
+		want:        current,
+		wantCreated: []string{current},
+	}, {
+		name:        "doesn't adopt the DaemonSet of another architecture",
+		existing:    []*appsv1.DaemonSet{withLabel(withName(legacy, "foo-arm64"), "arch", "arm64")},
+		want:        current,
+		wantCreated: []string{current},
+	}, {
+		name: "deletes the duplicate with fewer warm pods",
+		existing: []*appsv1.DaemonSet{
+			withName(makeDaemonSet(wi, "amd64", 1), "foo-a"),
+			withName(makeDaemonSet(wi, "amd64", 3), "foo-b"),
+			withName(makeDaemonSet(wi, "amd64", 2), "foo-c"),
+		},
+		want:        "foo-b",
+		wantDeleted: []string{"foo-c", "foo-a"},
+	}, {
+		name: "deletes the newer duplicate",
+		existing: []*appsv1.DaemonSet{
+			withName(makeDaemonSet(wi, "amd64", 3), "foo-a"),
+			olderBy(withName(makeDaemonSet(wi, "amd64", 3), "foo-b"), time.Minute),
+		},
+		want:        "foo-b",
+		wantDeleted: []string{"foo-a"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kube := newFakeKubeClient(append(append([]*appsv1.DaemonSet{}, test.existing...), test.unlisted...)...)
+			c := newTestWarmer(kube, []*corev1.Node{readyNode("the-node", "amd64")}, test.existing)
+
+			ds, err := c.reconcileDaemonSet(context.Background(), wi, "amd64", nil)
+			if err != nil {
+				t.Fatalf("reconcileDaemonSet() = %v", err)
+			}
+			if ds.Name != test.want {
+				t.Errorf("reconcileDaemonSet() = %q, wanted %q", ds.Name, test.want)
+			}
+			if want := resources.MakeDaemonSetLabels(wi, "amd64"); !reflect.DeepEqual(ds.Labels, map[string]string(want)) {
+				t.Errorf("Labels = %v, wanted %v", ds.Labels, want)
+			}
+			if hasDrifted(&resources.MakeDaemonSet(wi, "amd64", nil, c.configStore.Get()).Spec.Template.Spec, &ds.Spec.Template.Spec) {
+				t.Errorf("reconcileDaemonSet() = %+v, which has drifted", ds.Spec.Template.Spec)
+			}
+			if !reflect.DeepEqual(kube.created, test.wantCreated) {
+				t.Errorf("created = %q, wanted %q", kube.created, test.wantCreated)
+			}
+			if !reflect.DeepEqual(kube.updated, test.wantUpdated) {
+				t.Errorf("updated = %q, wanted %q", kube.updated, test.wantUpdated)
+			}
+			if !reflect.DeepEqual(kube.deleted, test.wantDeleted) {
+				t.Errorf("deleted = %q, wanted %q", kube.deleted, test.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestReconcileDaemonSets(t *testing.T) {
+	// Each architecture gets its own DaemonSet, and the DaemonSets of the
+	// older version and of the architectures we no longer target go.
+	wi := makeWarmImage("busybox")
+	old := makeDaemonSet(makeWarmImage("ubuntu"), "amd64", 3)
+	gone := makeDaemonSet(wi, "s390x", 3)
+	kube := newFakeKubeClient(old, gone)
+	c := newTestWarmer(kube, []*corev1.Node{
+		readyNode("node-a", "amd64"),
+		readyNode("node-b", "arm64"),
+		readyNode("node-c", "amd64"),
+	}, []*appsv1.DaemonSet{old, gone})
+
+	dss, err := c.reconcileDaemonSets(context.Background(), wi, nil)
+	if err != nil {
+		t.Fatalf("reconcileDaemonSets() = %v", err)
+	}
+	var names []string
+	for _, ds := range dss {
+		names = append(names, ds.Name)
+	}
+	want := []string{resources.MakeDaemonSetName(wi, "amd64"), resources.MakeDaemonSetName(wi, "arm64")}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("reconcileDaemonSets() = %q, wanted %q", names, want)
+	}
+	if !reflect.DeepEqual(kube.created, want) {
+		t.Errorf("created = %q, wanted %q", kube.created, want)
+	}
+	wantDeleted := []string{old.Name, gone.Name}
+	sort.Strings(wantDeleted)
+	if !reflect.DeepEqual(kube.deleted, wantDeleted) {
+		t.Errorf("deleted = %q, wanted %q", kube.deleted, wantDeleted)
+	}
+}
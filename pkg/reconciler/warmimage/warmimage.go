@@ -19,48 +19,57 @@ package warmimage
 import (
 	"context"
 	"fmt"
+	"reflect"
+	"time"
 
 	"github.com/knative/pkg/controller"
 	"github.com/knative/pkg/logging/logkey"
 	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/runtime"
-	extv1beta1informers "k8s.io/client-go/informers/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	appsv1informers "k8s.io/client-go/informers/apps/v1"
+	corev1informers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/tools/cache"
 
-	extlisters "k8s.io/client-go/listers/extensions/v1beta1"
-
-	warmimagev2 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v2"
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
 	clientset "github.com/mattmoor/warm-image/pkg/client/clientset/versioned"
 	warmimagescheme "github.com/mattmoor/warm-image/pkg/client/clientset/versioned/scheme"
-	informers "github.com/mattmoor/warm-image/pkg/client/informers/externalversions/warmimage/v2"
-	listers "github.com/mattmoor/warm-image/pkg/client/listers/warmimage/v2"
+	informers "github.com/mattmoor/warm-image/pkg/client/informers/externalversions/warmimage/v3"
+	"github.com/mattmoor/warm-image/pkg/metrics"
+	"github.com/mattmoor/warm-image/pkg/priorityclass"
+	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/config"
 	"github.com/mattmoor/warm-image/pkg/reconciler/warmimage/resources"
+	"github.com/mattmoor/warm-image/pkg/registry"
 )
 
-const controllerAgentName = "warmimage-controller"
+const (
+	controllerAgentName = "warmimage-controller"
+
+	// warmImageFinalizer is the finalizer through which we hold on to a
+	// WarmImage once it is deleted, until its images are removed from the
+	// nodes, if it has a coolDown, and what we created for it in the system
+	// namespace is deleted. It is named for the coolDown, which it used to be
+	// held for alone.
+	warmImageFinalizer = "cooldown.warmimages.mattmoor.io"
+)
 
 // Reconciler is the controller implementation for WarmImage resources
 type Reconciler struct {
-	// kubeclientset is a standard kubernetes clientset
-	kubeclientset kubernetes.Interface
+	warmer
+
 	// warmimageclientset is a clientset for our own API group
 	warmimageclientset clientset.Interface
 
-	daemonsetsLister extlisters.DaemonSetLister
-	warmimagesLister listers.WarmImageLister
-
-	sleeperImage string
-
-	// Sugared logger is easier to use but is not as performant as the
-	// raw logger. In performance critical paths, call logger.Desugar()
-	// and use the returned raw logger instead. In addition to the
-	// performance benefits, raw logger also preserves type-safety at
-	// the expense of slightly greater verbosity.
-	Logger *zap.SugaredLogger
+	// enqueueAfter schedules the WarmImage with the given key to be
+	// reconciled again after a delay.
+	enqueueAfter func(key string, delay time.Duration)
 }
 
 // Check that we implement the controller.Reconciler interface.
@@ -77,34 +86,217 @@ func NewController(
 	logger *zap.SugaredLogger,
 	kubeclientset kubernetes.Interface,
 	warmimageclientset clientset.Interface,
-	daemonsetInformer extv1beta1informers.DaemonSetInformer,
+	daemonsetInformer appsv1informers.DaemonSetInformer,
+	podInformer corev1informers.PodInformer,
+	nodeInformer corev1informers.NodeInformer,
+	secretInformer corev1informers.SecretInformer,
+	priorityclassclient priorityclass.Interface,
+	priorityClassInformer priorityclass.Informer,
 	warmimageInformer informers.WarmImageInformer,
-	sleeperImage string,
+	clusterwarmimageInformer informers.ClusterWarmImageInformer,
+	resolver registry.Resolver,
+	configStore *config.Store,
+	systemNamespace string,
+	agentNamespace string,
+	m *metrics.Metrics,
 ) *controller.Impl {
 
 	// Enrich the logs with controller name
 	logger = logger.Named(controllerAgentName).With(zap.String(logkey.ControllerType, controllerAgentName))
 
 	r := &Reconciler{
-		kubeclientset:      kubeclientset,
+		warmer: warmer{
+			kubeclientset:           kubeclientset,
+			daemonsetsLister:        daemonsetInformer.Lister(),
+			podsLister:              podInformer.Lister(),
+			nodesLister:             nodeInformer.Lister(),
+			secretsLister:           secretInformer.Lister(),
+			priorityclassclient:     priorityclassclient,
+			priorityClassesLister:   priorityClassInformer.Lister(),
+			warmimagesLister:        warmimageInformer.Lister(),
+			clusterwarmimagesLister: clusterwarmimageInformer.Lister(),
+			resolver:                resolver,
+			systemNamespace:         systemNamespace,
+			agentNamespace:          agentNamespace,
+			configStore:             configStore,
+			kind:                    "WarmImage",
+			recorder:                newRecorder(logger, kubeclientset, controllerAgentName),
+			metrics:                 m,
+			Logger:                  logger,
+		},
 		warmimageclientset: warmimageclientset,
-		daemonsetsLister:   daemonsetInformer.Lister(),
-		warmimagesLister:   warmimageInformer.Lister(),
-		sleeperImage:       sleeperImage,
-		Logger:             logger,
 	}
-	impl := controller.NewImpl(r, logger, "WarmImages")
+	impl := controller.NewImpl(m.Reconciler("WarmImages", r), logger, "WarmImages")
+	if err := m.RegisterWorkQueue("WarmImages", impl.WorkQueue); err != nil {
+		logger.Errorw("Failed to export the work queue depth", zap.Error(err))
+	}
+	r.enqueueAfter = func(key string, delay time.Duration) {
+		impl.WorkQueue.AddAfter(key, delay)
+	}
 
 	logger.Info("Setting up event handlers")
-	// Set up an event handler for when WarmImage resources change
+	resync := func() {
+		objs, err := warmimageInformer.Lister().List(labels.Everything())
+		if err != nil {
+			logger.Errorw("Failed to list WarmImages", zap.Error(err))
+			return
+		}
+		for _, obj := range objs {
+			impl.Enqueue(obj)
+		}
+	}
+	// Reconcile all of the WarmImages when a change to our configuration
+	// changes the warm pods.
+	configStore.OnChange(func(*config.Config) { resync() })
+
+	// Reconcile the WarmImages that target a node as soon as it joins, so
+	// that we warm their images onto it, all of them when the nodes' labels
+	// or taints change, so that we warm their images with a DaemonSet for
+	// each of the nodes' architectures, and those whose images the nodes
+	// report or whose node agents' reports change, so that we pick those up.
+	watchNodes(nodeInformer, func(node *corev1.Node) {
+		objs, err := warmimageInformer.Lister().List(labels.Everything())
+		if err != nil {
+			logger.Errorw("Failed to list WarmImages", zap.Error(err))
+			return
+		}
+		wis := make(map[string]*warmimagev3.WarmImage, len(objs))
+		for _, obj := range objs {
+			key, err := cache.MetaNamespaceKeyFunc(obj)
+			if err != nil {
+				continue
+			}
+			wis[key] = obj
+		}
+		r.enqueueTargeting(impl.WorkQueue, node, wis)
+	}, func(nc *nodeChange) {
+		objs, err := warmimageInformer.Lister().List(labels.Everything())
+		if err != nil {
+			logger.Errorw("Failed to list WarmImages", zap.Error(err))
+			return
+		}
+		for _, obj := range objs {
+			if nc.affects(obj) {
+				impl.Enqueue(obj)
+			}
+		}
+	}, resync)
+
+	// Recreate the PriorityClass of the warm pods of High priority images
+	// if it is deleted.
+	watchPriorityClass(priorityClassInformer, resync)
+
+	// Set up an event handler for when WarmImage resources change, and
+	// when they are deleted, so that we remove their ready labels.
 	warmimageInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    impl.Enqueue,
 		UpdateFunc: controller.PassNew(impl.Enqueue),
+		DeleteFunc: impl.Enqueue,
+	})
+
+	// Set up an event handler for when the warm pods change, so that we
+	// keep the per-node status of their WarmImage up to date.
+	podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    r.enqueueWarmImageOfPod(impl),
+		UpdateFunc: controller.PassNew(r.enqueueWarmImageOfPod(impl)),
+		DeleteFunc: r.enqueueWarmImageOfPod(impl),
+	})
+
+	// Set up an event handler for when the DaemonSets that we own change,
+	// so that we recreate them when deleted and repair them when edited.
+	daemonsetInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: isControlledByWarmImage,
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc:    impl.EnqueueControllerOf,
+			UpdateFunc: controller.PassNew(impl.EnqueueControllerOf),
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				impl.EnqueueControllerOf(obj)
+			},
+		},
 	})
 
 	return impl
 }
 
+// isControlledByWarmImage checks whether the object is controlled by a
+// WarmImage. Objects created before v3 are controlled by a v2 WarmImage, so
+// we only check the group and not the version.
+func isControlledByWarmImage(obj interface{}) bool {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	object, ok := obj.(metav1.Object)
+	if !ok {
+		return false
+	}
+	owner := metav1.GetControllerOf(object)
+	if owner == nil || owner.Kind != "WarmImage" {
+		return false
+	}
+	gv, err := schema.ParseGroupVersion(owner.APIVersion)
+	return err == nil && gv.Group == warmimagev3.SchemeGroupVersion.Group
+}
+
+// enqueueWarmImageOfPod returns a handler that enqueues the WarmImage
+// controlling the DaemonSet that controls a warm pod, or controlling the
+// one-shot pod itself.
+func (c *Reconciler) enqueueWarmImageOfPod(impl *controller.Impl) func(interface{}) {
+	return func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		pod, ok := obj.(*corev1.Pod)
+		if !ok {
+			return
+		}
+		// The DaemonSet controller may stamp out pods under a different API
+		// group than the one we created the DaemonSet with, so only check
+		// the Kind.
+		owner := metav1.GetControllerOf(pod)
+		if owner == nil {
+			// A cleanup pod, in the system namespace.
+			c.enqueueWarmImageOfCleanupPod(impl, pod)
+			return
+		} else if owner.Kind == "WarmImage" {
+			// A one-shot pod.
+			impl.EnqueueControllerOf(pod)
+			return
+		} else if owner.Kind != "DaemonSet" {
+			return
+		}
+		ds, err := c.daemonsetsLister.DaemonSets(pod.Namespace).Get(owner.Name)
+		if err != nil {
+			// The DaemonSet is gone, so there is nothing to report on.
+			return
+		}
+		impl.EnqueueControllerOf(ds)
+	}
+}
+
+// enqueueWarmImageOfCleanupPod enqueues the WarmImage whose images the given
+// cleanup pod removes, if any. Cleanup pods have no owner, so we find the
+// WarmImage through their labels.
+func (c *Reconciler) enqueueWarmImageOfCleanupPod(impl *controller.Impl, pod *corev1.Pod) {
+	if pod.Namespace != c.systemNamespace || pod.Labels["cleanup"] == "" {
+		return
+	}
+	uid := pod.Labels["controller"]
+	wis, err := c.warmimagesLister.List(labels.Everything())
+	if err != nil {
+		c.Logger.Errorw("Failed to list WarmImages", zap.Error(err))
+		return
+	}
+	for _, wi := range wis {
+		if string(wi.UID) == uid {
+			impl.Enqueue(wi)
+			return
+		}
+	}
+}
+
 // Reconcile implements controller.Reconciler
 func (c *Reconciler) Reconcile(ctx context.Context, key string) error {
 	// Convert the namespace/name string into a distinct namespace and name
@@ -115,52 +307,126 @@ func (c *Reconciler) Reconcile(ctx context.Context, key string) error {
 	}
 
 	// Get the WarmImage resource with this namespace/name
-	warmimage, err := c.warmimagesLister.WarmImages(namespace).Get(name)
+	original, err := c.warmimagesLister.WarmImages(namespace).Get(name)
 	if errors.IsNotFound(err) {
-		// The WarmImage resource may no longer exist, in which case we stop processing.
+		// The WarmImage resource may no longer exist, in which case we
+		// stop processing, once we no longer mark its images warm on the
+		// nodes.
 		runtime.HandleError(fmt.Errorf("warmimage '%s' in work queue no longer exists", key))
-		return nil
+		return c.labelNodes(resources.ReadyLabel(namespace, name), nil)
 	} else if err != nil {
 		return err
 	}
+	// Don't modify the informer's copy.
+	warmimage := original.DeepCopy()
+	warmimage.SetDefaults()
 
-	if err := c.reconcileDaemonSet(ctx, warmimage); err != nil {
-		return err
+	hasFinalizer := sets.NewString(original.Finalizers...).Has(warmImageFinalizer)
+	var released bool
+	switch {
+	case warmimage.DeletionTimestamp != nil:
+		// Its images are no longer kept warm.
+		if err := c.labelNodes(c.readyLabel(warmimage), nil); err != nil {
+			return err
+		}
+		if !hasFinalizer {
+			// It may have been deleted before we held on to it.
+			return c.cleanUp(warmimage)
+		}
+		// Hold on to the WarmImage until its images are removed from
+		// the nodes, or we give up on that.
+		released = true
+		if warmimage.Spec.CoolDown != nil {
+			released, err = c.coolDown(ctx, warmimage)
+		}
+	case hasFinalizer != needsFinalizer(warmimage):
+		// Only hold on to the WarmImages that we must clean up after.
+		// Updating the finalizers gets us reconciled again.
+		if hasFinalizer {
+			if err := c.cleanUp(warmimage); err != nil {
+				return err
+			}
+		}
+		return c.updateFinalizers(original, !hasFinalizer)
+	default:
+		// Reconcile this copy of the WarmImage and then write back any
+		// status updates regardless of whether the reconciliation
+		// errored out.
+		err = c.reconcile(ctx, warmimage)
+	}
+	if delay, ok := nextRefresh(warmimage); ok {
+		c.enqueueAfter(key, delay)
 	}
+	if delay, ok := nextResolveRetry(warmimage); ok {
+		c.enqueueAfter(key, delay)
+	}
+	if delay, ok := nextRolloutCheck(warmimage); ok {
+		c.enqueueAfter(key, delay)
+	}
+	if delay, ok := nextCoolDownCheck(warmimage); ok && !released {
+		c.enqueueAfter(key, delay)
+	}
+	latest := original
+	if reflect.DeepEqual(original.Status, warmimage.Status) {
+		// If we didn't change anything then don't call updateStatus.
+	} else if updated, uErr := c.updateStatus(warmimage); uErr != nil {
+		c.Logger.Warnw("Failed to update warmimage status", zap.Error(uErr))
+		return uErr
+	} else {
+		latest = updated
+	}
+	if released {
+		if err := c.cleanUp(warmimage); err != nil {
+			return err
+		}
+		return c.updateFinalizers(latest, false)
+	}
+	return err
+}
 
-	return nil
+// needsFinalizer returns whether we must clean up after the WarmImage once it
+// is deleted: remove its images from the nodes, with cleanup pods in the
+// system namespace, or delete the node agents' secret for it from the agent
+// namespace.
+func needsFinalizer(wi *warmimagev3.WarmImage) bool {
+	return wi.Spec.CoolDown != nil || wi.Spec.Strategy == warmimagev3.WarmImageStrategyNodeAgent
 }
 
-func (c *Reconciler) reconcileDaemonSet(ctx context.Context, wi *warmimagev2.WarmImage) error {
-	// Make sure the desired image is warmed up ASAP.
-	dss, err := c.daemonsetsLister.DaemonSets(wi.Namespace).List(resources.MakeLabelSelector(wi))
-	if err != nil {
+// cleanUp deletes what we created for the WarmImage in the system and agent
+// namespaces, which can't be garbage collected with it.
+func (c *Reconciler) cleanUp(wi *warmimagev3.WarmImage) error {
+	if err := c.deleteAgentSecret(wi); err != nil {
 		return err
 	}
+	return c.deleteCleanupPods(wi)
+}
 
-	switch {
-	// If none exist, create one.
-	case len(dss) == 0:
-		ds := resources.MakeDaemonSet(wi, c.sleeperImage)
-		ds, err = c.kubeclientset.ExtensionsV1beta1().DaemonSets(wi.Namespace).Create(ds)
-		if err != nil {
-			return err
-		}
-		c.Logger.Infof("Warming up: %q, with %q", wi.Spec.Image, ds.Name)
+func (c *Reconciler) reconcile(ctx context.Context, wi *warmimagev3.WarmImage) error {
+	return c.warm(ctx, wi, metav1.NewControllerRef(wi, warmimagev3.SchemeGroupVersion.WithKind("WarmImage")))
+}
 
-	// If multiple exist, delete all but one.
-	case len(dss) > 1:
-		c.Logger.Error("NYI: cleaning up multiple daemonsets for a single WarmImage.")
+// updateFinalizers adds or removes our finalizer on the WarmImage.
+func (c *Reconciler) updateFinalizers(wi *warmimagev3.WarmImage, add bool) error {
+	// Don't modify the informer's copy.
+	wi = wi.DeepCopy()
+	finalizers := sets.NewString(wi.Finalizers...)
+	if add {
+		finalizers.Insert(warmImageFinalizer)
+	} else {
+		finalizers.Delete(warmImageFinalizer)
 	}
+	wi.Finalizers = finalizers.List()
+	_, err := c.warmimageclientset.MattmoorV3().WarmImages(wi.Namespace).Update(wi)
+	return err
+}
 
-	// Delete any older versions of this WarmImage.
-	propPolicy := metav1.DeletePropagationForeground
-	err = c.kubeclientset.ExtensionsV1beta1().DaemonSets(wi.Namespace).DeleteCollection(
-		&metav1.DeleteOptions{PropagationPolicy: &propPolicy},
-		metav1.ListOptions{LabelSelector: resources.MakeOldVersionLabelSelector(wi).String()},
-	)
+func (c *Reconciler) updateStatus(desired *warmimagev3.WarmImage) (*warmimagev3.WarmImage, error) {
+	wi, err := c.warmimagesLister.WarmImages(desired.Namespace).Get(desired.Name)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	// Don't modify the informer's copy.
+	existing := wi.DeepCopy()
+	existing.Status = desired.Status
+	return c.warmimageclientset.MattmoorV3().WarmImages(desired.Namespace).UpdateStatus(existing)
 }
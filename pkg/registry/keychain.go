@@ -0,0 +1,132 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Credential is a username and password for a registry.
+type Credential struct {
+	Username string
+	Password string
+}
+
+// Keychain holds the credentials to use for each registry, keyed by host.
+type Keychain map[string]Credential
+
+// dockerConfigEntry is an entry in a .dockercfg, or in the "auths" of a
+// .dockerconfigjson.
+type dockerConfigEntry struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Auth     string `json:"auth,omitempty"`
+}
+
+type dockerConfigJSON struct {
+	Auths map[string]dockerConfigEntry `json:"auths"`
+}
+
+// NewKeychain builds a Keychain from image pull secrets of type
+// kubernetes.io/dockerconfigjson or kubernetes.io/dockercfg. When several
+// secrets have credentials for the same registry, the first one wins.
+func NewKeychain(secrets ...*corev1.Secret) (Keychain, error) {
+	kc := make(Keychain)
+	for _, s := range secrets {
+		var entries map[string]dockerConfigEntry
+		switch s.Type {
+		case corev1.SecretTypeDockerConfigJson:
+			var cfg dockerConfigJSON
+			if err := json.Unmarshal(s.Data[corev1.DockerConfigJsonKey], &cfg); err != nil {
+				return nil, fmt.Errorf("error parsing secret %q: %v", s.Name, err)
+			}
+			entries = cfg.Auths
+		case corev1.SecretTypeDockercfg:
+			if err := json.Unmarshal(s.Data[corev1.DockerConfigKey], &entries); err != nil {
+				return nil, fmt.Errorf("error parsing secret %q: %v", s.Name, err)
+			}
+		default:
+			return nil, fmt.Errorf("secret %q has unsupported type %q", s.Name, s.Type)
+		}
+
+		for key, entry := range entries {
+			cred, err := entry.credential()
+			if err != nil {
+				return nil, fmt.Errorf("error parsing secret %q: %v", s.Name, err)
+			}
+			host := normalizeHost(key)
+			if _, ok := kc[host]; !ok {
+				kc[host] = cred
+			}
+		}
+	}
+	return kc, nil
+}
+
+// Lookup returns the credential to use for the given registry, if any.
+func (kc Keychain) Lookup(registry string) (Credential, bool) {
+	cred, ok := kc[normalizeHost(registry)]
+	return cred, ok
+}
+
+// DockerConfigJSON returns the Keychain as the .dockerconfigjson of an image
+// pull secret of type kubernetes.io/dockerconfigjson, from which NewKeychain
+// reads it back.
+func (kc Keychain) DockerConfigJSON() ([]byte, error) {
+	cfg := dockerConfigJSON{Auths: make(map[string]dockerConfigEntry, len(kc))}
+	for host, cred := range kc {
+		cfg.Auths[host] = dockerConfigEntry{Username: cred.Username, Password: cred.Password}
+	}
+	return json.Marshal(cfg)
+}
+
+func (e dockerConfigEntry) credential() (Credential, error) {
+	if e.Auth == "" {
+		return Credential{Username: e.Username, Password: e.Password}, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(e.Auth)
+	if err != nil {
+		return Credential{}, err
+	}
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return Credential{}, fmt.Errorf("malformed auth for user %q", e.Username)
+	}
+	return Credential{Username: parts[0], Password: parts[1]}, nil
+}
+
+// normalizeHost turns the keys that show up in docker configs, such as
+// "https://index.docker.io/v1/", into a bare registry host.
+func normalizeHost(key string) string {
+	host := key
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+	switch host {
+	case "docker.io", "registry-1.docker.io":
+		return DockerHub
+	}
+	return host
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// imageManifest holds the fields of the manifests and manifest lists that we
+// accept that tell which platforms an image supports.
+type imageManifest struct {
+	// Manifests are the entries of a manifest list, one per platform.
+	Manifests []struct {
+		Platform *platform `json:"platform"`
+	} `json:"manifests"`
+
+	// Config is the configuration of a single-platform image, which holds
+	// its platform.
+	Config *struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+}
+
+// platform is the platform of an entry in a manifest list, or of the image
+// that a configuration belongs to.
+type platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+}
+
+func (p platform) String() string {
+	return p.OS + "/" + p.Architecture
+}
+
+// Platforms implements Resolver
+func (c *client) Platforms(image string, kc Keychain) ([]string, error) {
+	ref, err := ParseReference(image)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.manifest(http.MethodGet, ref, kc)
+	if err != nil {
+		return nil, err
+	}
+	var m imageManifest
+	err = json.NewDecoder(resp.Body).Decode(&m)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("parsing the manifest of %q: %v", image, err)
+	}
+
+	var platforms []platform
+	switch {
+	case len(m.Manifests) > 0:
+		for _, entry := range m.Manifests {
+			if entry.Platform != nil {
+				platforms = append(platforms, *entry.Platform)
+			}
+		}
+	case m.Config != nil && m.Config.Digest != "":
+		// The image only supports the platform it was built for, which its
+		// configuration records.
+		resp, err := c.blob(m.Config.Digest, ref, kc)
+		if err != nil {
+			return nil, err
+		}
+		var p platform
+		err = json.NewDecoder(resp.Body).Decode(&p)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("parsing the configuration of %q: %v", image, err)
+		}
+		platforms = append(platforms, p)
+	}
+	return platformStrings(image, platforms)
+}
+
+// platformStrings returns the distinct os/arch of the platforms, in order,
+// ignoring their variants, since nodes aren't labeled with those, and the
+// "unknown" platform of the attestations that some builders add to lists.
+func platformStrings(image string, platforms []platform) ([]string, error) {
+	seen := make(map[string]bool, len(platforms))
+	var ps []string
+	for _, p := range platforms {
+		if p.OS == "" || p.Architecture == "" || p.OS == "unknown" || seen[p.String()] {
+			continue
+		}
+		seen[p.String()] = true
+		ps = append(ps, p.String())
+	}
+	if len(ps) == 0 {
+		return nil, fmt.Errorf("the manifest of %q does not say which platforms it supports", image)
+	}
+	sort.Strings(ps)
+	return ps, nil
+}
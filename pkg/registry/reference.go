@@ -0,0 +1,107 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// DockerHub is the registry that image references without an explicit
+	// registry resolve against.
+	DockerHub = "index.docker.io"
+
+	defaultTag = "latest"
+)
+
+// Reference is a parsed image reference.
+type Reference struct {
+	// Name is the name of the image as written, without its tag or digest.
+	Name string
+	// Registry is the host of the registry serving the image.
+	Registry string
+	// Repository is the repository of the image within the registry.
+	Repository string
+	// Tag is the tag of the image, if any.
+	Tag string
+	// Digest is the digest of the image, if any.
+	Digest string
+}
+
+// ParseReference parses an image reference of the form
+// [registry/]repository[:tag][@digest].
+func ParseReference(image string) (Reference, error) {
+	ref := Reference{Name: image}
+	if i := strings.Index(ref.Name, "@"); i >= 0 {
+		ref.Name, ref.Digest = ref.Name[:i], ref.Name[i+1:]
+		if !strings.HasPrefix(ref.Digest, "sha256:") {
+			return Reference{}, fmt.Errorf("unsupported digest in image reference %q", image)
+		}
+	}
+	if i := strings.LastIndex(ref.Name, ":"); i > strings.LastIndex(ref.Name, "/") {
+		ref.Name, ref.Tag = ref.Name[:i], ref.Name[i+1:]
+	}
+	if ref.Name == "" || strings.ToLower(ref.Name) != ref.Name {
+		return Reference{}, fmt.Errorf("invalid image reference %q", image)
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = defaultTag
+	}
+
+	ref.Registry, ref.Repository = DockerHub, ref.Name
+	if i := strings.Index(ref.Name, "/"); i >= 0 {
+		// The first component is a registry if it looks like a host.
+		if host := ref.Name[:i]; strings.ContainsAny(host, ".:") || host == "localhost" {
+			ref.Registry, ref.Repository = host, ref.Name[i+1:]
+		}
+	}
+	if ref.Registry == "docker.io" {
+		ref.Registry = DockerHub
+	}
+	if ref.Registry == DockerHub && !strings.Contains(ref.Repository, "/") {
+		// Official images live under library/ on Docker Hub.
+		ref.Repository = "library/" + ref.Repository
+	}
+	return ref, nil
+}
+
+// Identifier returns the digest of the reference if it has one, and its tag
+// otherwise.
+func (r Reference) Identifier() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+// WithDigest returns the image reference pinned to the given digest.
+func (r Reference) WithDigest(digest string) string {
+	return r.Name + "@" + digest
+}
+
+// String returns the image reference as written.
+func (r Reference) String() string {
+	s := r.Name
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
@@ -0,0 +1,261 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package registry talks to container registries over the Docker Registry
+// HTTP API V2.
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Resolver resolves image references to the digests of their manifests, and
+// tells which platforms they support.
+type Resolver interface {
+	// Resolve returns the digest of the manifest that the image reference
+	// points to, authenticating with the credentials in the Keychain.
+	Resolve(image string, kc Keychain) (string, error)
+
+	// Platforms returns the platforms that the image supports, as os/arch,
+	// authenticating with the credentials in the Keychain.
+	Platforms(image string, kc Keychain) ([]string, error)
+}
+
+// acceptedManifests are the manifest media types that we accept. Manifest
+// lists are accepted so that multi-platform images resolve to the digest of
+// the list, rather than that of the default platform.
+var acceptedManifests = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+}
+
+// client is a Resolver that talks to registries over HTTPS.
+type client struct {
+	client *http.Client
+}
+
+// Check that we implement the Resolver interface.
+var _ Resolver = (*client)(nil)
+
+// NewResolver returns a Resolver that sends its requests through the given
+// RoundTripper, or http.DefaultTransport when it is nil.
+func NewResolver(transport http.RoundTripper) Resolver {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &client{client: &http.Client{Transport: transport}}
+}
+
+// Resolve implements Resolver
+func (c *client) Resolve(image string, kc Keychain) (string, error) {
+	ref, err := ParseReference(image)
+	if err != nil {
+		return "", err
+	}
+	if ref.Digest != "" {
+		// Already pinned.
+		return ref.Digest, nil
+	}
+
+	// Try a HEAD first, which doesn't count against pull quotas, and fall
+	// back on hashing the manifest when the registry doesn't tell us the
+	// digest.
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		resp, err := c.manifest(method, ref, kc)
+		if err != nil {
+			return "", err
+		}
+		digest, err := digestOf(resp)
+		resp.Body.Close()
+		if err != nil || digest != "" {
+			return digest, err
+		}
+	}
+	return "", fmt.Errorf("registry did not return a digest for %q", image)
+}
+
+func (c *client) manifest(method string, ref Reference, kc Keychain) (*http.Response, error) {
+	return c.fetch(method, "manifests/"+ref.Identifier(), ref, kc)
+}
+
+func (c *client) blob(digest string, ref Reference, kc Keychain) (*http.Response, error) {
+	return c.fetch(http.MethodGet, "blobs/"+digest, ref, kc)
+}
+
+// fetch sends a request for the given path under the image's repository,
+// answering the registry's authentication challenge if it makes one.
+func (c *client) fetch(method, path string, ref Reference, kc Keychain) (*http.Response, error) {
+	u := fmt.Sprintf("https://%s/v2/%s/%s", apiHost(ref.Registry), ref.Repository, path)
+	newRequest := func() (*http.Request, error) {
+		req, err := http.NewRequest(method, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", strings.Join(acceptedManifests, ","))
+		return req, nil
+	}
+
+	req, err := newRequest()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, checkStatus(resp, path, ref)
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+
+	// Answer the registry's challenge and try again.
+	if req, err = newRequest(); err != nil {
+		return nil, err
+	}
+	cred, hasCred := kc.Lookup(ref.Registry)
+	scheme, params := parseChallenge(challenge)
+	switch scheme {
+	case "basic":
+		if !hasCred {
+			return nil, fmt.Errorf("registry %q requires credentials to pull %q", ref.Registry, ref)
+		}
+		req.SetBasicAuth(cred.Username, cred.Password)
+	case "bearer":
+		token, err := c.token(params, "repository:"+ref.Repository+":pull", cred, hasCred)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	default:
+		return nil, fmt.Errorf("unsupported authentication challenge %q from %q", challenge, ref.Registry)
+	}
+	if resp, err = c.client.Do(req); err != nil {
+		return nil, err
+	}
+	return resp, checkStatus(resp, path, ref)
+}
+
+// token fetches a bearer token for the given scope from the realm in the
+// registry's challenge.
+func (c *client) token(params map[string]string, scope string, cred Credential, hasCred bool) (string, error) {
+	realm, ok := params["realm"]
+	if !ok {
+		return "", fmt.Errorf("bearer challenge is missing a realm")
+	}
+	u, err := url.Parse(realm)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	if service, ok := params["service"]; ok {
+		q.Set("service", service)
+	}
+	q.Set("scope", scope)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if hasCred {
+		req.SetBasicAuth(cred.Username, cred.Password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request to %q failed: %s", realm, resp.Status)
+	}
+
+	var tr struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return "", err
+	}
+	if tr.Token != "" {
+		return tr.Token, nil
+	}
+	if tr.AccessToken != "" {
+		return tr.AccessToken, nil
+	}
+	return "", fmt.Errorf("token response from %q had no token", realm)
+}
+
+// digestOf returns the digest of the manifest in the response, from the
+// Docker-Content-Digest header or by hashing the body of a GET.
+func digestOf(resp *http.Response) (string, error) {
+	if d := resp.Header.Get("Docker-Content-Digest"); d != "" {
+		return d, nil
+	}
+	if resp.Request.Method == http.MethodHead {
+		return "", nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, resp.Body); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func checkStatus(resp *http.Response, path string, ref Reference) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	resp.Body.Close()
+	return fmt.Errorf("fetching %s for %q failed: %s %s", path, ref, resp.Status, strings.TrimSpace(string(body)))
+}
+
+// parseChallenge parses a WWW-Authenticate header, for example:
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseChallenge(challenge string) (string, map[string]string) {
+	parts := strings.SplitN(strings.TrimSpace(challenge), " ", 2)
+	scheme := strings.ToLower(parts[0])
+	params := make(map[string]string)
+	if len(parts) < 2 {
+		return scheme, params
+	}
+	for _, kv := range strings.Split(parts[1], ",") {
+		kv := strings.SplitN(strings.TrimSpace(kv), "=", 2)
+		if len(kv) == 2 {
+			params[strings.ToLower(kv[0])] = strings.Trim(kv[1], "\"")
+		}
+	}
+	return scheme, params
+}
+
+// apiHost returns the host serving the registry API for the given registry.
+func apiHost(registry string) string {
+	if registry == DockerHub {
+		return "registry-1.docker.io"
+	}
+	return registry
+}
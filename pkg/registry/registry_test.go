@@ -0,0 +1,464 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	testUser     = "user"
+	testPassword = "hunter2"
+	testToken    = "the-token"
+)
+
+var (
+	listManifest = `{
+  "schemaVersion": 2,
+  "mediaType": "application/vnd.docker.distribution.manifest.list.v2+json",
+  "manifests": [
+    {"digest": "sha256:aaaa", "platform": {"os": "linux", "architecture": "arm64", "variant": "v8"}},
+    {"digest": "sha256:bbbb", "platform": {"os": "linux", "architecture": "amd64"}},
+    {"digest": "sha256:cccc", "platform": {"os": "linux", "architecture": "arm64"}},
+    {"digest": "sha256:dddd", "platform": {"os": "unknown", "architecture": "unknown"}}
+  ]
+}`
+	imageManifestJSON = `{
+  "schemaVersion": 2,
+  "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+  "config": {"digest": "sha256:config"}
+}`
+	imageConfig = `{"os": "linux", "architecture": "s390x"}`
+)
+
+// fakeRegistry is an in-process registry serving the manifests and blobs of
+// a single repository, behind the given authentication.
+type fakeRegistry struct {
+	t *testing.T
+
+	// auth is "", "basic" or "bearer".
+	auth string
+
+	// headDigest is whether HEAD requests for manifests return the
+	// Docker-Content-Digest header.
+	headDigest bool
+
+	manifests map[string]string
+	blobs     map[string]string
+
+	// server is the fake registry, which also serves the bearer tokens.
+	server *httptest.Server
+}
+
+func newFakeRegistry(t *testing.T, auth string) *fakeRegistry {
+	r := &fakeRegistry{
+		t:          t,
+		auth:       auth,
+		headDigest: true,
+		manifests: map[string]string{
+			"latest": imageManifestJSON,
+			"multi":  listManifest,
+		},
+		blobs: map[string]string{
+			"sha256:config": imageConfig,
+		},
+	}
+	r.server = httptest.NewTLSServer(http.HandlerFunc(r.serveHTTP))
+	return r
+}
+
+// host is the registry host for image references.
+func (r *fakeRegistry) host() string {
+	return strings.TrimPrefix(r.server.URL, "https://")
+}
+
+func (r *fakeRegistry) resolver() Resolver {
+	return NewResolver(r.server.Client().Transport)
+}
+
+func (r *fakeRegistry) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		r.serveToken(w, req)
+		return
+	}
+	if !r.authorized(req) {
+		switch r.auth {
+		case "basic":
+			w.Header().Set("WWW-Authenticate", `Basic realm="fake"`)
+		case "bearer":
+			w.Header().Set("WWW-Authenticate",
+				fmt.Sprintf(`Bearer realm="%s/token",service="fake-registry"`, r.server.URL))
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	const prefix = "/v2/foo/bar/"
+	if !strings.HasPrefix(req.URL.Path, prefix) {
+		http.NotFound(w, req)
+		return
+	}
+	path := strings.TrimPrefix(req.URL.Path, prefix)
+	var body string
+	var ok bool
+	switch {
+	case strings.HasPrefix(path, "manifests/"):
+		if !strings.Contains(req.Header.Get("Accept"), "manifest.list.v2+json") {
+			r.t.Errorf("Accept = %q, wanted manifest lists", req.Header.Get("Accept"))
+		}
+		body, ok = r.manifests[strings.TrimPrefix(path, "manifests/")]
+		if ok && (req.Method == http.MethodGet || r.headDigest) {
+			w.Header().Set("Docker-Content-Digest", digestOfString(body))
+		}
+	case strings.HasPrefix(path, "blobs/"):
+		body, ok = r.blobs[strings.TrimPrefix(path, "blobs/")]
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"errors":[{"code":"MANIFEST_UNKNOWN"}]}`)
+		return
+	}
+	if req.Method == http.MethodGet {
+		fmt.Fprint(w, body)
+	}
+}
+
+func (r *fakeRegistry) authorized(req *http.Request) bool {
+	switch r.auth {
+	case "basic":
+		user, password, ok := req.BasicAuth()
+		return ok && user == testUser && password == testPassword
+	case "bearer":
+		return req.Header.Get("Authorization") == "Bearer "+testToken
+	}
+	return true
+}
+
+func (r *fakeRegistry) serveToken(w http.ResponseWriter, req *http.Request) {
+	if got, want := req.URL.Query().Get("scope"), "repository:foo/bar:pull"; got != want {
+		r.t.Errorf("scope = %q, wanted %q", got, want)
+	}
+	if got, want := req.URL.Query().Get("service"), "fake-registry"; got != want {
+		r.t.Errorf("service = %q, wanted %q", got, want)
+	}
+	user, password, ok := req.BasicAuth()
+	if !ok || user != testUser || password != testPassword {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"token": testToken})
+}
+
+func digestOfString(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return "sha256:" + hex.EncodeToString(h[:])
+}
+
+// dockerConfigJSONSecret returns an image pull secret for the registry.
+func dockerConfigJSONSecret(t *testing.T, host string) *corev1.Secret {
+	b, err := json.Marshal(map[string]interface{}{
+		"auths": map[string]interface{}{
+			"https://" + host + "/v1/": map[string]string{
+				"auth": base64.StdEncoding.EncodeToString([]byte(testUser + ":" + testPassword)),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("json.Marshal() = %v", err)
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "pull-secret"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: b},
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name       string
+		auth       string
+		headDigest bool
+		creds      bool
+		tag        string
+		want       string
+		wantErr    string
+	}{{
+		name:       "anonymous HEAD",
+		headDigest: true,
+		tag:        "latest",
+		want:       digestOfString(imageManifestJSON),
+	}, {
+		name: "falls back on hashing a GET",
+		tag:  "latest",
+		want: digestOfString(imageManifestJSON),
+	}, {
+		name:       "manifest list",
+		headDigest: true,
+		tag:        "multi",
+		want:       digestOfString(listManifest),
+	}, {
+		name:       "basic auth",
+		auth:       "basic",
+		headDigest: true,
+		creds:      true,
+		tag:        "latest",
+		want:       digestOfString(imageManifestJSON),
+	}, {
+		name:       "bearer token",
+		auth:       "bearer",
+		headDigest: true,
+		creds:      true,
+		tag:        "latest",
+		want:       digestOfString(imageManifestJSON),
+	}, {
+		name:       "basic auth without credentials",
+		auth:       "basic",
+		headDigest: true,
+		tag:        "latest",
+		wantErr:    "requires credentials",
+	}, {
+		name:       "bearer token without credentials",
+		auth:       "bearer",
+		headDigest: true,
+		tag:        "latest",
+		wantErr:    "token request",
+	}, {
+		name:       "unknown tag",
+		headDigest: true,
+		tag:        "missing",
+		wantErr:    "404",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newFakeRegistry(t, test.auth)
+			defer r.server.Close()
+			r.headDigest = test.headDigest
+
+			var secrets []*corev1.Secret
+			if test.creds {
+				secrets = append(secrets, dockerConfigJSONSecret(t, r.host()))
+			}
+			kc, err := NewKeychain(secrets...)
+			if err != nil {
+				t.Fatalf("NewKeychain() = %v", err)
+			}
+
+			got, err := r.resolver().Resolve(r.host()+"/foo/bar:"+test.tag, kc)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Resolve() = %v, wanted an error containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve() = %v", err)
+			}
+			if got != test.want {
+				t.Errorf("Resolve() = %q, wanted %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestResolvePinned(t *testing.T) {
+	// The reference doesn't point at a registry that exists, so this only
+	// passes if we don't contact it.
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	got, err := NewResolver(nil).Resolve("registry.invalid/foo/bar@"+digest, Keychain{})
+	if err != nil {
+		t.Fatalf("Resolve() = %v", err)
+	}
+	if got != digest {
+		t.Errorf("Resolve() = %q, wanted %q", got, digest)
+	}
+}
+
+func TestResolveUnsupportedChallenge(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Negotiate`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "https://")
+	_, err := NewResolver(server.Client().Transport).Resolve(host+"/foo/bar", Keychain{})
+	if err == nil || !strings.Contains(err.Error(), "unsupported authentication challenge") {
+		t.Errorf("Resolve() = %v, wanted an unsupported challenge error", err)
+	}
+}
+
+func TestPlatforms(t *testing.T) {
+	tests := []struct {
+		name    string
+		tag     string
+		want    []string
+		wantErr string
+	}{{
+		name: "manifest list",
+		tag:  "multi",
+		want: []string{"linux/amd64", "linux/arm64"},
+	}, {
+		name: "single platform",
+		tag:  "latest",
+		want: []string{"linux/s390x"},
+	}, {
+		name:    "missing configuration",
+		tag:     "noconfig",
+		wantErr: "404",
+	}, {
+		name:    "no platforms",
+		tag:     "empty",
+		wantErr: "does not say which platforms",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newFakeRegistry(t, "bearer")
+			defer r.server.Close()
+			r.manifests["noconfig"] = `{"config": {"digest": "sha256:missing"}}`
+			r.manifests["empty"] = `{"manifests": []}`
+
+			kc, err := NewKeychain(dockerConfigJSONSecret(t, r.host()))
+			if err != nil {
+				t.Fatalf("NewKeychain() = %v", err)
+			}
+			got, err := r.resolver().Platforms(r.host()+"/foo/bar:"+test.tag, kc)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Platforms() = %v, wanted an error containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Platforms() = %v", err)
+			}
+			if strings.Join(got, ",") != strings.Join(test.want, ",") {
+				t.Errorf("Platforms() = %q, wanted %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewKeychain(t *testing.T) {
+	dockercfg, err := json.Marshal(map[string]interface{}{
+		"https://index.docker.io/v1/": map[string]string{
+			"username": "hub-user",
+			"password": "hub-password",
+		},
+	})
+	if err != nil {
+		t.Fatalf("json.Marshal() = %v", err)
+	}
+	kc, err := NewKeychain(
+		dockerConfigJSONSecret(t, "gcr.io"),
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "dockercfg"},
+			Type:       corev1.SecretTypeDockercfg,
+			Data:       map[string][]byte{corev1.DockerConfigKey: dockercfg},
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewKeychain() = %v", err)
+	}
+	if got, ok := kc.Lookup("gcr.io"); !ok || got != (Credential{Username: testUser, Password: testPassword}) {
+		t.Errorf("Lookup(gcr.io) = %v, %v", got, ok)
+	}
+	if got, ok := kc.Lookup("docker.io"); !ok || got != (Credential{Username: "hub-user", Password: "hub-password"}) {
+		t.Errorf("Lookup(docker.io) = %v, %v", got, ok)
+	}
+	if _, ok := kc.Lookup("quay.io"); ok {
+		t.Error("Lookup(quay.io) found credentials")
+	}
+
+	_, err = NewKeychain(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "opaque"},
+		Type:       corev1.SecretTypeOpaque,
+	})
+	if err == nil {
+		t.Error("NewKeychain() = nil, wanted an error for an Opaque secret")
+	}
+}
+
+func TestDockerConfigJSON(t *testing.T) {
+	want := Keychain{
+		"gcr.io":  {Username: testUser, Password: testPassword},
+		DockerHub: {Username: "hub-user", Password: "hub-password"},
+	}
+	b, err := want.DockerConfigJSON()
+	if err != nil {
+		t.Fatalf("DockerConfigJSON() = %v", err)
+	}
+	got, err := NewKeychain(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "round-trip"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: b},
+	})
+	if err != nil {
+		t.Fatalf("NewKeychain() = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NewKeychain(DockerConfigJSON()) = %v, wanted %v", got, want)
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		image   string
+		want    Reference
+		wantErr bool
+	}{{
+		image: "ubuntu",
+		want:  Reference{Name: "ubuntu", Registry: DockerHub, Repository: "library/ubuntu", Tag: "latest"},
+	}, {
+		image: "gcr.io/google-appengine/python:3",
+		want:  Reference{Name: "gcr.io/google-appengine/python", Registry: "gcr.io", Repository: "google-appengine/python", Tag: "3"},
+	}, {
+		image: "localhost:5000/foo@sha256:abcd",
+		want:  Reference{Name: "localhost:5000/foo", Registry: "localhost:5000", Repository: "foo", Digest: "sha256:abcd"},
+	}, {
+		image:   "Upper/Case",
+		wantErr: true,
+	}, {
+		image:   "foo@md5:abcd",
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.image, func(t *testing.T) {
+			got, err := ParseReference(test.image)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseReference() = %v, wanted error %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("ParseReference() = %+v, wanted %+v", got, test.want)
+			}
+		})
+	}
+}
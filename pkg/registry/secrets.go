@@ -0,0 +1,64 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PullSecrets returns the secrets that the kubelet would pull the images of a
+// pod in the namespace with: the pod's own image pull secrets, followed by
+// those of the named ServiceAccount it runs as.
+func PullSecrets(kubeclientset kubernetes.Interface, namespace string, refs []corev1.LocalObjectReference, serviceAccountName string, logger *zap.SugaredLogger) ([]*corev1.Secret, error) {
+	var secrets []*corev1.Secret
+	for _, ips := range refs {
+		secret, err := kubeclientset.CoreV1().Secrets(namespace).Get(ips.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+
+	// Pods without a serviceAccountName run as the default ServiceAccount,
+	// and the kubelet uses its imagePullSecrets too.
+	saName := serviceAccountName
+	if saName == "" {
+		saName = "default"
+	}
+	sa, err := kubeclientset.CoreV1().ServiceAccounts(namespace).Get(saName, metav1.GetOptions{})
+	if errors.IsNotFound(err) && serviceAccountName == "" {
+		return secrets, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, ips := range sa.ImagePullSecrets {
+		secret, err := kubeclientset.CoreV1().Secrets(namespace).Get(ips.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			// Like the kubelet, skip the missing secrets of ServiceAccounts.
+			logger.Warnf("ServiceAccount %q references missing secret %q", saName, ips.Name)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets, nil
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// caKey holds the certificate of the CA that signed the serving
+	// certificate, which the API server trusts.
+	caKey = "ca.crt"
+
+	// certificateValidity is how long the certificates we create are valid.
+	certificateValidity = 10 * 365 * 24 * time.Hour
+)
+
+// GetOrCreateCertificate returns the serving certificate of the given service,
+// and the certificate of the CA that signed it, from the named secret in the
+// service's namespace. It creates a self-signed one if there is none yet, so
+// that every replica serves the same.
+func GetOrCreateCertificate(kubeclientset kubernetes.Interface, namespace, secretName, serviceName string) (*tls.Certificate, []byte, error) {
+	secrets := kubeclientset.CoreV1().Secrets(namespace)
+	secret, err := secrets.Get(secretName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		secret, err = makeCertificateSecret(namespace, secretName, serviceName)
+		if err != nil {
+			return nil, nil, err
+		}
+		if secret, err = secrets.Create(secret); errors.IsAlreadyExists(err) {
+			// Another replica beat us to it.
+			secret, err = secrets.Get(secretName, metav1.GetOptions{})
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid certificate in secret %q: %v", secretName, err)
+	}
+	return &cert, secret.Data[caKey], nil
+}
+
+// makeCertificateSecret creates a CA, and a serving certificate that it signs
+// for the service's DNS names.
+func makeCertificateSecret(namespace, secretName, serviceName string) (*corev1.Secret, error) {
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := notBefore.Add(certificateValidity)
+
+	caKeyPair, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: serviceName + "-ca"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKeyPair.PublicKey, caKeyPair)
+	if err != nil {
+		return nil, err
+	}
+	if ca, err = x509.ParseCertificate(caDER); err != nil {
+		return nil, err
+	}
+
+	keyPair, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	host := serviceName + "." + namespace + ".svc"
+	cert := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{serviceName, serviceName + "." + namespace, host, host + ".cluster.local"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, cert, ca, &keyPair.PublicKey, caKeyPair)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(keyPair)
+	if err != nil {
+		return nil, err
+	}
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName,
+			Namespace: namespace,
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			caKey:                   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+			corev1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+			corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		},
+	}, nil
+}
+
+// PatchCABundle has the API server trust the given CA when it calls the
+// conversion webhook of the named CustomResourceDefinition. Our vendored
+// client-go predates apiextensions.k8s.io/v1, so we patch it directly.
+func PatchCABundle(kubeclientset kubernetes.Interface, crdName string, caBundle []byte) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"conversion": map[string]interface{}{
+				"webhook": map[string]interface{}{
+					"clientConfig": map[string]interface{}{
+						// This is base64 encoded, as the API expects.
+						"caBundle": caBundle,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return kubeclientset.CoreV1().RESTClient().Patch(types.MergePatchType).
+		AbsPath("/apis/apiextensions.k8s.io/v1/customresourcedefinitions", crdName).
+		Body(patch).
+		Do().
+		Error()
+}
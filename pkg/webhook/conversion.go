@@ -0,0 +1,265 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package webhook serves the conversion webhook between the versions of
+// WarmImage. The API server only stores v3, and converts each object that a
+// v2 client reads or writes through us.
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	warmimagev2 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v2"
+	warmimagev3 "github.com/mattmoor/warm-image/pkg/apis/warmimage/v3"
+)
+
+// SpecAnnotation holds the v3 spec of a WarmImage that a v2 client reads, so
+// that what v2 can't hold, such as the images and image pull secrets past the
+// first, survives its updates.
+const SpecAnnotation = "warmimage.mattmoor.io/v3-spec"
+
+// ConversionReview is the apiextensions.k8s.io/v1 ConversionReview, which our
+// vendored dependencies predate.
+type ConversionReview struct {
+	metav1.TypeMeta `json:",inline"`
+
+	Request  *ConversionRequest  `json:"request,omitempty"`
+	Response *ConversionResponse `json:"response,omitempty"`
+}
+
+// ConversionRequest asks to convert the objects to the desired version.
+type ConversionRequest struct {
+	UID               types.UID         `json:"uid"`
+	DesiredAPIVersion string            `json:"desiredAPIVersion"`
+	Objects           []json.RawMessage `json:"objects"`
+}
+
+// ConversionResponse holds the converted objects, in the order of the request.
+type ConversionResponse struct {
+	UID              types.UID         `json:"uid"`
+	ConvertedObjects []json.RawMessage `json:"convertedObjects"`
+	Result           metav1.Status     `json:"result"`
+}
+
+// object is a WarmImage of either version, whose metadata we pass through as
+// is, but for our annotation.
+type object struct {
+	APIVersion string                 `json:"apiVersion"`
+	Kind       string                 `json:"kind"`
+	Metadata   map[string]interface{} `json:"metadata"`
+	Spec       json.RawMessage        `json:"spec,omitempty"`
+	Status     json.RawMessage        `json:"status,omitempty"`
+}
+
+// NewConversionHandler returns the handler of the ConversionReviews that the
+// API server sends us.
+func NewConversionHandler(logger *zap.SugaredLogger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var review ConversionReview
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+			http.Error(w, fmt.Sprintf("invalid ConversionReview: %v", err), http.StatusBadRequest)
+			return
+		}
+		if review.Request == nil {
+			http.Error(w, "ConversionReview has no request", http.StatusBadRequest)
+			return
+		}
+		req := review.Request
+		review.Request, review.Response = nil, convertAll(req)
+		if review.Response.Result.Status != metav1.StatusSuccess {
+			logger.Errorf("Failed to convert to %s: %s", req.DesiredAPIVersion, review.Response.Result.Message)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(review); err != nil {
+			logger.Errorw("Failed to write the ConversionReview", zap.Error(err))
+		}
+	})
+}
+
+// convertAll converts every object of the request, or none at all.
+func convertAll(req *ConversionRequest) *ConversionResponse {
+	resp := &ConversionResponse{UID: req.UID}
+	for _, raw := range req.Objects {
+		converted, err := Convert(raw, req.DesiredAPIVersion)
+		if err != nil {
+			resp.ConvertedObjects = nil
+			resp.Result = metav1.Status{Status: metav1.StatusFailure, Message: err.Error()}
+			return resp
+		}
+		resp.ConvertedObjects = append(resp.ConvertedObjects, converted)
+	}
+	resp.Result = metav1.Status{Status: metav1.StatusSuccess}
+	return resp
+}
+
+// Convert converts the WarmImage to the given version.
+func Convert(raw json.RawMessage, apiVersion string) (json.RawMessage, error) {
+	var obj object
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil, err
+	}
+	if obj.APIVersion == apiVersion {
+		return raw, nil
+	}
+	var err error
+	switch apiVersion {
+	case warmimagev2.SchemeGroupVersion.String():
+		err = toV2(&obj)
+	case warmimagev3.SchemeGroupVersion.String():
+		err = toV3(&obj)
+	default:
+		err = fmt.Errorf("unsupported apiVersion %q", apiVersion)
+	}
+	if err != nil {
+		return nil, err
+	}
+	obj.APIVersion = apiVersion
+	return json.Marshal(obj)
+}
+
+// toV2 converts the v3 WarmImage to v2, which only has its first image and
+// image pull secret, and keeps the whole v3 spec in our annotation. v2 has no
+// status.
+func toV2(obj *object) error {
+	var spec warmimagev3.WarmImageSpec
+	if err := unmarshalSpec(obj.Spec, &spec); err != nil {
+		return err
+	}
+	b, err := json.Marshal(makeV2Spec(spec))
+	if err != nil {
+		return err
+	}
+	if len(obj.Spec) != 0 {
+		setAnnotation(obj, SpecAnnotation, string(obj.Spec))
+	}
+	obj.Spec = b
+	obj.Status = nil
+	return nil
+}
+
+// toV3 converts the v2 WarmImage to v3, from the v3 spec in our annotation if
+// it has one, with the first image and image pull secret that the v2 client
+// may have changed.
+func toV3(obj *object) error {
+	var v2 warmimagev2.WarmImageSpec
+	if err := unmarshalSpec(obj.Spec, &v2); err != nil {
+		return err
+	}
+	stashed, ok := removeAnnotation(obj, SpecAnnotation)
+	if !ok {
+		spec := warmimagev3.WarmImageSpec{}
+		if v2.Image != "" {
+			spec.Images = []string{v2.Image}
+		}
+		if v2.ImagePullSecrets != nil {
+			spec.ImagePullSecrets = []corev1.LocalObjectReference{*v2.ImagePullSecrets}
+		}
+		b, err := json.Marshal(spec)
+		if err != nil {
+			return err
+		}
+		obj.Spec = b
+		return nil
+	}
+
+	var spec warmimagev3.WarmImageSpec
+	if err := json.Unmarshal([]byte(stashed), &spec); err != nil {
+		return fmt.Errorf("invalid %s annotation: %v", SpecAnnotation, err)
+	}
+	if reflect.DeepEqual(makeV2Spec(spec), v2) {
+		// Keep the v3 spec exactly as it was.
+		obj.Spec = json.RawMessage(stashed)
+		return nil
+	}
+	switch {
+	case spec.Image != "":
+		spec.Image = v2.Image
+	case len(spec.Images) != 0:
+		spec.Images[0] = v2.Image
+	case v2.Image != "":
+		spec.Images = []string{v2.Image}
+	}
+	switch ips := spec.ImagePullSecrets; {
+	case v2.ImagePullSecrets == nil && len(ips) != 0:
+		spec.ImagePullSecrets = ips[1:]
+	case v2.ImagePullSecrets != nil && len(ips) == 0:
+		spec.ImagePullSecrets = []corev1.LocalObjectReference{*v2.ImagePullSecrets}
+	case v2.ImagePullSecrets != nil:
+		ips[0] = *v2.ImagePullSecrets
+	}
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	obj.Spec = b
+	return nil
+}
+
+// makeV2Spec returns the first image and image pull secret of the v3 spec.
+func makeV2Spec(spec warmimagev3.WarmImageSpec) warmimagev2.WarmImageSpec {
+	var v2 warmimagev2.WarmImageSpec
+	switch {
+	case spec.Image != "":
+		v2.Image = spec.Image
+	case len(spec.Images) != 0:
+		v2.Image = spec.Images[0]
+	}
+	if len(spec.ImagePullSecrets) != 0 {
+		v2.ImagePullSecrets = spec.ImagePullSecrets[0].DeepCopy()
+	}
+	return v2
+}
+
+// unmarshalSpec decodes the spec, if the object has one.
+func unmarshalSpec(raw json.RawMessage, spec interface{}) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(raw, spec)
+}
+
+func setAnnotation(obj *object, key, value string) {
+	if obj.Metadata == nil {
+		obj.Metadata = map[string]interface{}{}
+	}
+	annotations, _ := obj.Metadata["annotations"].(map[string]interface{})
+	if annotations == nil {
+		annotations = map[string]interface{}{}
+		obj.Metadata["annotations"] = annotations
+	}
+	annotations[key] = value
+}
+
+func removeAnnotation(obj *object, key string) (string, bool) {
+	annotations, _ := obj.Metadata["annotations"].(map[string]interface{})
+	value, ok := annotations[key].(string)
+	if !ok {
+		return "", false
+	}
+	delete(annotations, key)
+	if len(annotations) == 0 {
+		delete(obj.Metadata, "annotations")
+	}
+	return value, true
+}
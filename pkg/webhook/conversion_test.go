@@ -0,0 +1,262 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	v2Version = "mattmoor.io/v2"
+	v3Version = "mattmoor.io/v3"
+)
+
+// makeObject returns a WarmImage of the given version with the given
+// annotations, spec and status, the latter of which may be empty.
+func makeObject(apiVersion string, annotations map[string]string, spec, status string) string {
+	obj := map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       "WarmImage",
+		"metadata": map[string]interface{}{
+			"name":            "foo",
+			"namespace":       "bar",
+			"resourceVersion": "42",
+		},
+		"spec": json.RawMessage(spec),
+	}
+	if len(annotations) != 0 {
+		obj["metadata"].(map[string]interface{})["annotations"] = annotations
+	}
+	if status != "" {
+		obj["status"] = json.RawMessage(status)
+	}
+	b, err := json.Marshal(obj)
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
+
+// assertJSONEqual checks that the JSON documents are equal, regardless of
+// their formatting and the order of their fields.
+func assertJSONEqual(t *testing.T, got []byte, want string) {
+	t.Helper()
+	var g, w interface{}
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("Unmarshal(%s) = %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatalf("Unmarshal(%s) = %v", want, err)
+	}
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("got %s, wanted %s", got, want)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	const (
+		single   = `{"images":["busybox"],"imagePullSecrets":[{"name":"a"}]}`
+		multiple = `{"images":["busybox","ubuntu"],"imagePullSecrets":[{"name":"a"},{"name":"b"}],"serviceAccountName":"builder","strategy":"NodeAgent"}`
+	)
+	stashed := func(spec string) map[string]string {
+		return map[string]string{SpecAnnotation: spec}
+	}
+
+	tests := []struct {
+		name       string
+		in         string
+		apiVersion string
+		want       string
+		wantErr    bool
+	}{{
+		name:       "v3 to v3",
+		in:         makeObject(v3Version, nil, multiple, `{"readyNodes":3}`),
+		apiVersion: v3Version,
+		want:       makeObject(v3Version, nil, multiple, `{"readyNodes":3}`),
+	}, {
+		name:       "v3 to v2",
+		in:         makeObject(v3Version, nil, multiple, `{"readyNodes":3}`),
+		apiVersion: v2Version,
+		want:       makeObject(v2Version, stashed(multiple), `{"image":"busybox","imagePullSecrets":{"name":"a"}}`, ""),
+	}, {
+		name:       "v3 with the deprecated image to v2",
+		in:         makeObject(v3Version, nil, `{"image":"busybox","images":["ubuntu"]}`, ""),
+		apiVersion: v2Version,
+		want:       makeObject(v2Version, stashed(`{"image":"busybox","images":["ubuntu"]}`), `{"image":"busybox"}`, ""),
+	}, {
+		name:       "unchanged v2 to v3",
+		in:         makeObject(v2Version, stashed(multiple), `{"image":"busybox","imagePullSecrets":{"name":"a"}}`, ""),
+		apiVersion: v3Version,
+		want:       makeObject(v3Version, nil, multiple, ""),
+	}, {
+		name:       "v2 with a new image to v3",
+		in:         makeObject(v2Version, stashed(multiple), `{"image":"alpine","imagePullSecrets":{"name":"a"}}`, ""),
+		apiVersion: v3Version,
+		want: makeObject(v3Version, nil,
+			`{"images":["alpine","ubuntu"],"imagePullSecrets":[{"name":"a"},{"name":"b"}],"serviceAccountName":"builder","strategy":"NodeAgent"}`, ""),
+	}, {
+		name:       "v2 with a new secret to v3",
+		in:         makeObject(v2Version, stashed(multiple), `{"image":"busybox","imagePullSecrets":{"name":"c"}}`, ""),
+		apiVersion: v3Version,
+		want: makeObject(v3Version, nil,
+			`{"images":["busybox","ubuntu"],"imagePullSecrets":[{"name":"c"},{"name":"b"}],"serviceAccountName":"builder","strategy":"NodeAgent"}`, ""),
+	}, {
+		name:       "v2 without its secret to v3",
+		in:         makeObject(v2Version, stashed(multiple), `{"image":"busybox"}`, ""),
+		apiVersion: v3Version,
+		want: makeObject(v3Version, nil,
+			`{"images":["busybox","ubuntu"],"imagePullSecrets":[{"name":"b"}],"serviceAccountName":"builder","strategy":"NodeAgent"}`, ""),
+	}, {
+		name:       "v2 with a first secret to v3",
+		in:         makeObject(v2Version, stashed(`{"images":["busybox"]}`), `{"image":"busybox","imagePullSecrets":{"name":"a"}}`, ""),
+		apiVersion: v3Version,
+		want:       makeObject(v3Version, nil, single, ""),
+	}, {
+		name:       "v2 with a new deprecated image to v3",
+		in:         makeObject(v2Version, stashed(`{"image":"busybox","images":["ubuntu"]}`), `{"image":"alpine"}`, ""),
+		apiVersion: v3Version,
+		want:       makeObject(v3Version, nil, `{"image":"alpine","images":["ubuntu"]}`, ""),
+	}, {
+		name:       "v2 without our annotation to v3",
+		in:         makeObject(v2Version, map[string]string{"other": "annotation"}, `{"image":"busybox","imagePullSecrets":{"name":"a"}}`, ""),
+		apiVersion: v3Version,
+		want:       makeObject(v3Version, map[string]string{"other": "annotation"}, single, ""),
+	}, {
+		name:       "v2 without a secret to v3",
+		in:         makeObject(v2Version, nil, `{"image":"busybox"}`, ""),
+		apiVersion: v3Version,
+		want:       makeObject(v3Version, nil, `{"images":["busybox"]}`, ""),
+	}, {
+		name:       "invalid annotation",
+		in:         makeObject(v2Version, stashed(`{"images":`), `{"image":"busybox"}`, ""),
+		apiVersion: v3Version,
+		wantErr:    true,
+	}, {
+		name:       "unknown version",
+		in:         makeObject(v3Version, nil, single, ""),
+		apiVersion: "mattmoor.io/v1",
+		wantErr:    true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Convert(json.RawMessage(test.in), test.apiVersion)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Convert() = %v, wanted error %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			assertJSONEqual(t, got, test.want)
+		})
+	}
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	// A v2 client that reads a v3 WarmImage and writes it back unchanged
+	// doesn't change its v3 spec, nor drop the annotations it had.
+	in := makeObject(v3Version, map[string]string{"other": "annotation"},
+		`{"images":["busybox","ubuntu"],"imagePullSecrets":[{"name":"a"},{"name":"b"}],"cooldown":{"after":"1h"}}`, "")
+	v2, err := Convert(json.RawMessage(in), v2Version)
+	if err != nil {
+		t.Fatalf("Convert(v2) = %v", err)
+	}
+	v3, err := Convert(v2, v3Version)
+	if err != nil {
+		t.Fatalf("Convert(v3) = %v", err)
+	}
+	assertJSONEqual(t, v3, in)
+}
+
+func TestConversionHandler(t *testing.T) {
+	server := httptest.NewServer(NewConversionHandler(zap.NewNop().Sugar()))
+	defer server.Close()
+
+	post := func(review ConversionReview) ConversionReview {
+		t.Helper()
+		b, err := json.Marshal(review)
+		if err != nil {
+			t.Fatalf("Marshal() = %v", err)
+		}
+		resp, err := http.Post(server.URL, "application/json", bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("Post() = %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("StatusCode = %d, wanted %d", resp.StatusCode, http.StatusOK)
+		}
+		var got ConversionReview
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatalf("Decode() = %v", err)
+		}
+		return got
+	}
+	typeMeta := metav1.TypeMeta{APIVersion: "apiextensions.k8s.io/v1", Kind: "ConversionReview"}
+
+	got := post(ConversionReview{
+		TypeMeta: typeMeta,
+		Request: &ConversionRequest{
+			UID:               "the-uid",
+			DesiredAPIVersion: v2Version,
+			Objects: []json.RawMessage{
+				json.RawMessage(makeObject(v3Version, nil, `{"images":["busybox"]}`, "")),
+				json.RawMessage(makeObject(v3Version, nil, `{"images":["ubuntu"]}`, "")),
+			},
+		},
+	})
+	if got.TypeMeta != typeMeta {
+		t.Errorf("TypeMeta = %v, wanted %v", got.TypeMeta, typeMeta)
+	}
+	if got.Request != nil || got.Response == nil {
+		t.Fatalf("ConversionReview = %+v, wanted only a response", got)
+	}
+	if got.Response.UID != "the-uid" {
+		t.Errorf("UID = %q, wanted %q", got.Response.UID, "the-uid")
+	}
+	if got.Response.Result.Status != metav1.StatusSuccess {
+		t.Errorf("Result = %+v, wanted success", got.Response.Result)
+	}
+	if len(got.Response.ConvertedObjects) != 2 {
+		t.Fatalf("ConvertedObjects = %s, wanted 2", got.Response.ConvertedObjects)
+	}
+	assertJSONEqual(t, got.Response.ConvertedObjects[1],
+		makeObject(v2Version, map[string]string{SpecAnnotation: `{"images":["ubuntu"]}`}, `{"image":"ubuntu"}`, ""))
+
+	// Any object that we can't convert fails the whole request.
+	got = post(ConversionReview{
+		TypeMeta: typeMeta,
+		Request: &ConversionRequest{
+			UID:               "the-uid",
+			DesiredAPIVersion: "mattmoor.io/v1",
+			Objects:           []json.RawMessage{json.RawMessage(makeObject(v3Version, nil, `{"images":["busybox"]}`, ""))},
+		},
+	})
+	if got.Response == nil || got.Response.Result.Status != metav1.StatusFailure {
+		t.Errorf("Response = %+v, wanted failure", got.Response)
+	} else if len(got.Response.ConvertedObjects) != 0 {
+		t.Errorf("ConvertedObjects = %s, wanted none", got.Response.ConvertedObjects)
+	}
+}